@@ -0,0 +1,50 @@
+package namesys
+
+import (
+	"errors"
+
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// ErrNilValidator is returned by NewPubsubValueStore when given a nil
+// validator; see AcceptAllValidator for a store that accepts any record.
+var ErrNilValidator = errors.New("invalid validator: nil")
+
+// AcceptAllValidator is a record.Validator accepting any record, for
+// experiments and for applications validating their records themselves, e.g.
+// with WithMessageValidator.
+//
+// The last writer wins: a record received, or put, replaces the current one,
+// newest meaning last received. Nodes receiving concurrent writes in different
+// orders may then keep different records until the next write, and a peer
+// rebroadcasting an older record it still holds brings it back. With
+// WithEnvelopes, the record written last according to the clock of its
+// publisher wins instead, the same way on every node, whatever the order the
+// records arrive in.
+type AcceptAllValidator struct{}
+
+// Validate accepts any record.
+func (AcceptAllValidator) Validate(string, []byte) error {
+	return nil
+}
+
+// Select has no preference, it always selects the first record. The store
+// then prefers the candidate record over the current one.
+func (AcceptAllValidator) Select(string, [][]byte) (int, error) {
+	return 0, nil
+}
+
+// prefersCandidate reports whether the validator is an AcceptAllValidator,
+// whose ties between a candidate and the current record go to the candidate.
+func (p *PubsubValueStore) prefersCandidate() bool {
+	return lastWriterWins(p.GetValidator())
+}
+
+// lastWriterWins reports whether v is an AcceptAllValidator.
+func lastWriterWins(v record.Validator) bool {
+	switch v.(type) {
+	case AcceptAllValidator, *AcceptAllValidator:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package namesys
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MetricPublisherRejected counts records rejected because their publisher
+// isn't on the key's allowlist.
+const MetricPublisherRejected = "publisher_rejected"
+
+type peerSet map[peer.ID]struct{}
+
+func newPeerSet(peers []peer.ID) peerSet {
+	s := make(peerSet, len(peers))
+	for _, p := range peers {
+		s[p] = struct{}{}
+	}
+	return s
+}
+
+// allowlists holds the peers allowed to publish records, per key and by
+// default. A nil set means everyone is allowed.
+type allowlists struct {
+	mx     sync.RWMutex
+	global peerSet
+	perKey map[string]peerSet
+}
+
+// WithAllowedPublishers returns an option that only accepts records published
+// by the given peers, for all keys that don't have their own allowlist set
+// with SetAllowedPublishers.
+func WithAllowedPublishers(peers ...peer.ID) Option {
+	return func(store *PubsubValueStore) error {
+		store.allowed.global = newPeerSet(peers)
+		return nil
+	}
+}
+
+// SetAllowedPublishers restricts the peers allowed to publish records for the
+// key. Messages from any other peer are rejected regardless of their content,
+// and so are records fetched from them when they join the topic. Note that
+// this includes our own host, which must be on the list to publish.
+//
+// Passing no peers removes the key's allowlist, falling back to the default
+// set with WithAllowedPublishers, if any.
+func (p *PubsubValueStore) SetAllowedPublishers(key string, peers []peer.ID) {
+	p.allowed.mx.Lock()
+	defer p.allowed.mx.Unlock()
+
+	if len(peers) == 0 {
+		delete(p.allowed.perKey, key)
+		return
+	}
+	if p.allowed.perKey == nil {
+		p.allowed.perKey = make(map[string]peerSet)
+	}
+	p.allowed.perKey[key] = newPeerSet(peers)
+}
+
+// publisherAllowed reports whether the peer may publish records for the key.
+func (p *PubsubValueStore) publisherAllowed(key string, from peer.ID) bool {
+	p.allowed.mx.RLock()
+	defer p.allowed.mx.RUnlock()
+
+	set, ok := p.allowed.perKey[key]
+	if !ok {
+		set = p.allowed.global
+	}
+	if set == nil {
+		return true
+	}
+	_, ok = set[from]
+	if !ok {
+		p.count(&p.stats.publisherRejected, MetricPublisherRejected)
+	}
+	return ok
+}
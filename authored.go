@@ -0,0 +1,132 @@
+package namesys
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+var authoredPrefix = ds.NewKey("/authored")
+
+// authoredKey returns the datastore key recording that this node published a
+// record of the key.
+func authoredKey(key string) ds.Key {
+	return authoredPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+// markAuthored records that this node published a record of the key, so that
+// it is republished after a restart. The keys already recorded aren't written
+// again.
+// Must be called with the key locked.
+func (p *PubsubValueStore) markAuthored(ctx context.Context, key string) {
+	if has, err := p.ds.Has(ctx, authoredKey(key)); err == nil && has {
+		return
+	}
+	if err := p.ds.Put(ctx, authoredKey(key), nil); err != nil {
+		p.log.Warnf("failed to record the publication of %s: %s", logKey(key), err)
+		p.reportError(key, OpStore, err)
+	}
+}
+
+// AuthoredKeys returns, sorted, the keys this node published a record of with
+// PutValue, PutValues or PutValueIf. The stored records of these keys are
+// republished when a store is constructed over the same datastore, see
+// ForgetAuthored.
+func (p *PubsubValueStore) AuthoredKeys(ctx context.Context) ([]string, error) {
+	res, err := p.ds.Query(ctx, dsq.Query{Prefix: authoredPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		k, err := dshelp.BinaryFromDsKey(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			p.log.Warnf("skipping invalid authored key %s: %s", e.Key, err)
+			continue
+		}
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ForgetAuthored stops republishing the keys after a restart, or all of the
+// authored keys if none is given. It doesn't delete their records, nor
+// unsubscribes from them.
+func (p *PubsubValueStore) ForgetAuthored(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		var err error
+		if keys, err = p.AuthoredKeys(ctx); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if err := p.ds.Delete(ctx, authoredKey(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// republishAuthored resubscribes to the keys authored before the restart and,
+// once the host had some time to connect, republishes their stored records,
+// which are then rebroadcast with the other subscribed keys. The records that
+// are no longer valid, e.g. expired since the restart, are left alone. The
+// subscriptions aren't held, as PutValue's aren't, so Cancel and the eviction
+// of WithMaxSubscriptions release them as before the restart.
+func (p *PubsubValueStore) republishAuthored(ctx context.Context, keys []string) {
+	var valid []string
+	for _, key := range keys {
+		if _, err := p.getLocal(ctx, key); err != nil {
+			p.log.Debugf("PubsubRepublish: not republishing %s: %s", logKey(key), err)
+			continue
+		}
+		if err := p.subscribe(ctx, key, false); err != nil {
+			if ctx.Err() == nil {
+				p.reportError(key, OpSubscribe, err)
+			}
+			continue
+		}
+		valid = append(valid, key)
+	}
+	if len(valid) == 0 {
+		return
+	}
+
+	select {
+	case <-time.After(p.rebroadcastInitialDelay):
+	case <-ctx.Done():
+		return
+	}
+	for _, key := range valid {
+		p.mx.Lock()
+		ti, ok := p.topics[key]
+		p.mx.Unlock()
+		if !ok {
+			continue
+		}
+		// validated again, as it may have expired while waiting
+		val, err := p.getLocal(ctx, key)
+		if err != nil {
+			continue
+		}
+		select {
+		case err := <-p.psPublishChannel(ctx, ti.topic, val):
+			if err != nil && ctx.Err() == nil {
+				p.reportError(key, OpPublish, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+		p.log.Debugf("PubsubRepublish: republished %s", logKey(key))
+	}
+}
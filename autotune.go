@@ -0,0 +1,297 @@
+package namesys
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds of the values picked by the auto-tuner.
+const (
+	MinAutoTunedRebroadcastInterval = time.Minute
+	MaxAutoTunedRebroadcastInterval = time.Hour
+
+	MinAutoTunedDiscoveryInterval = time.Minute
+	MaxAutoTunedDiscoveryInterval = time.Hour
+
+	MinAutoTunedFetchFanout = 2
+	MaxAutoTunedFetchFanout = 16
+)
+
+const (
+	// autoTunePeriod is how often the auto-tuner re-evaluates the intervals.
+	autoTunePeriod = time.Minute
+	// autoTuneAlpha is the smoothing factor of the observed rates.
+	autoTuneAlpha = 0.2
+	// autoTuneChurnScale is the peer churn, in events per minute, that halves
+	// the intervals and doubles the fetch fan-out.
+	autoTuneChurnScale = 1.0
+	// autoTuneMinResponseRate bounds how much a low response rate widens the
+	// fetch fan-out.
+	autoTuneMinResponseRate = 0.25
+)
+
+// WithAutoTune returns an option that lets the store adjust, for each key,
+// to the observed network behavior:
+//
+//   - the rebroadcast interval, within [MinAutoTunedRebroadcastInterval,
+//     MaxAutoTunedRebroadcastInterval]. Keys that are updated rarely don't
+//     need to be rebroadcast more often than they're updated, so their
+//     interval follows the update interval; updating a key often never makes
+//     it rebroadcast more often than the default interval, as the updates are
+//     broadcast themselves. Peers joining and leaving the key's topic may have
+//     missed updates, so high peer churn shortens the interval.
+//   - the discovery interval, within [MinAutoTunedDiscoveryInterval,
+//     MaxAutoTunedDiscoveryInterval]. While the key's topic has fewer peers
+//     than the dial target, see WithPeerDialTarget, its remembered or
+//     bootstrap peers are dialed again every interval. Having fewer peers, and
+//     high peer churn, shortens the interval.
+//   - the fetch fan-out, within [MinAutoTunedFetchFanout,
+//     MaxAutoTunedFetchFanout]. It is the number of peers a missing record is
+//     fetched from, see GetValue, instead of all of the topic's peers. High
+//     peer churn, and peers failing to respond with a record, widen it.
+//
+// An interval set explicitly with WithRebroadcastInterval is never overridden.
+// Every adjustment is logged at debug level along with its rationale, and the
+// effective values are reported by Stats.
+func WithAutoTune() Option {
+	return func(store *PubsubValueStore) error {
+		store.autoTune = true
+		return nil
+	}
+}
+
+// autoTuner keeps track of the observed network behavior of a key.
+type autoTuner struct {
+	mx sync.Mutex
+
+	lastUpdate time.Time
+	// smoothed interval between accepted updates, in seconds. 0 if unknown.
+	updateInterval float64
+
+	churnEvents int
+	// smoothed peer churn, in events per minute
+	churnRate float64
+
+	// smoothed share of the fetched peers that responded with a record, 1 if
+	// unknown
+	responseRate float64
+	fetched      bool
+
+	lastTune time.Time
+}
+
+func ema(avg, sample float64) float64 {
+	return autoTuneAlpha*sample + (1-autoTuneAlpha)*avg
+}
+
+// observeUpdate records an accepted update.
+func (t *autoTuner) observeUpdate(now time.Time) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.lastUpdate.IsZero() {
+		sample := now.Sub(t.lastUpdate).Seconds()
+		if t.updateInterval == 0 {
+			t.updateInterval = sample
+		} else {
+			t.updateInterval = ema(t.updateInterval, sample)
+		}
+	}
+	t.lastUpdate = now
+}
+
+// observeChurn records a peer joining or leaving a topic.
+func (t *autoTuner) observeChurn() {
+	t.mx.Lock()
+	t.churnEvents++
+	t.mx.Unlock()
+}
+
+// observeFetch records whether a peer a missing record was fetched from
+// responded with a record.
+func (t *autoTuner) observeFetch(responded bool) {
+	sample := 0.0
+	if responded {
+		sample = 1
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if !t.fetched {
+		t.responseRate = sample
+		t.fetched = true
+	} else {
+		t.responseRate = ema(t.responseRate, sample)
+	}
+}
+
+// rollLocked folds the churn observed since the last call into the churn
+// rate. It is a no-op if called again for the same time.
+func (t *autoTuner) rollLocked(now time.Time) {
+	elapsed := autoTunePeriod
+	if !t.lastTune.IsZero() {
+		elapsed = now.Sub(t.lastTune)
+	}
+	if elapsed <= 0 {
+		return
+	}
+	t.lastTune = now
+	t.churnRate = ema(t.churnRate, float64(t.churnEvents)/elapsed.Minutes())
+	t.churnEvents = 0
+}
+
+// dampen moves current halfway to target, within [min, max], to dampen
+// oscillations, and settles once close.
+func dampen(current, target, min, max time.Duration) time.Duration {
+	if target < min {
+		target = min
+	}
+	if target > max {
+		target = max
+	}
+	diff := target - current
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < current/20 {
+		if target == min || target == max {
+			return target
+		}
+		return current
+	}
+	return current + (target-current)/2
+}
+
+// tune returns the rebroadcast interval to use from now on, and the reason
+// for the change. The reason is empty if the interval doesn't change. The
+// updates only lengthen the interval beyond base, the default one.
+func (t *autoTuner) tune(now time.Time, current, base time.Duration) (time.Duration, string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.rollLocked(now)
+
+	// without updates, there's nothing to keep fresh
+	target := MaxAutoTunedRebroadcastInterval
+	if t.updateInterval > 0 {
+		target = time.Duration(t.updateInterval * float64(time.Second))
+		if target < base {
+			target = base
+		}
+	}
+	target = time.Duration(float64(target) / (1 + t.churnRate/autoTuneChurnScale))
+
+	next := dampen(current, target, MinAutoTunedRebroadcastInterval, MaxAutoTunedRebroadcastInterval)
+	if next == current {
+		return current, ""
+	}
+
+	updates := "none"
+	if t.updateInterval > 0 {
+		updates = time.Duration(t.updateInterval * float64(time.Second)).Round(time.Second).String()
+	}
+	return next, fmt.Sprintf("update interval %s, peer churn %.2f/min", updates, t.churnRate)
+}
+
+// tuneDiscovery returns the discovery interval to use from now on, given the
+// number of peers of the topic and the dial target, and the reason for the
+// change. The reason is empty if the interval doesn't change.
+func (t *autoTuner) tuneDiscovery(now time.Time, current time.Duration, peers, target int) (time.Duration, string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.rollLocked(now)
+
+	share := 1.0
+	if peers < target {
+		share = float64(peers) / float64(target)
+	}
+	interval := time.Duration(float64(MaxAutoTunedDiscoveryInterval) * share / (1 + t.churnRate/autoTuneChurnScale))
+
+	next := dampen(current, interval, MinAutoTunedDiscoveryInterval, MaxAutoTunedDiscoveryInterval)
+	if next == current {
+		return current, ""
+	}
+	return next, fmt.Sprintf("%d of %d peers, peer churn %.2f/min", peers, target, t.churnRate)
+}
+
+// tuneFanout returns the fetch fan-out to use from now on, and the reason for
+// the change. The reason is empty if the fan-out doesn't change.
+func (t *autoTuner) tuneFanout(now time.Time, current int) (int, string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.rollLocked(now)
+
+	rate := 1.0
+	if t.fetched {
+		rate = math.Max(t.responseRate, autoTuneMinResponseRate)
+	}
+	next := int(math.Ceil(MinAutoTunedFetchFanout * (1 + t.churnRate/autoTuneChurnScale) / rate))
+	if next > MaxAutoTunedFetchFanout {
+		next = MaxAutoTunedFetchFanout
+	}
+	if next == current {
+		return current, ""
+	}
+	responses := "unknown"
+	if t.fetched {
+		responses = fmt.Sprintf("%.2f", t.responseRate)
+	}
+	return next, fmt.Sprintf("response rate %s, peer churn %.2f/min", responses, t.churnRate)
+}
+
+// tuneKey adjusts the tuned values of the key, and reports whether it is due
+// for a rebroadcast, along with the time of its last one. Its remembered or
+// bootstrap peers are dialed again if it is due for a discovery.
+// Must be called from the rebroadcast loop, which owns the key's intervals.
+func (p *PubsubValueStore) tuneKey(now time.Time, key string, ti *topicInfo) (bool, time.Time) {
+	if !now.After(ti.lastTuned) {
+		// already tuned for this tick
+		return false, time.Time{}
+	}
+	if ti.lastTuned.IsZero() {
+		// first seen: rebroadcast and discover once their intervals are over
+		ti.rebroadcastInterval = p.rebroadcastInterval
+		ti.lastRebroadcast = now
+		ti.discoveryInterval = peerDialMaxBackoff
+		ti.lastDiscovery = now
+	}
+	ti.lastTuned = now
+
+	if !p.rebroadcastIntervalSet {
+		next, reason := ti.tuner.tune(now, ti.rebroadcastInterval, p.rebroadcastInterval)
+		if next != ti.rebroadcastInterval {
+			p.log.Debugf("PubsubRebroadcast: interval of %s %s -> %s (%s)", logKey(key), ti.rebroadcastInterval, next, reason)
+			ti.rebroadcastInterval = next
+		}
+	}
+
+	peers := len(ti.topic.ListPeers())
+	next, reason := ti.tuner.tuneDiscovery(now, ti.discoveryInterval, peers, p.peerDialTarget)
+	if next != ti.discoveryInterval {
+		p.log.Debugf("PubsubPeers: discovery interval of %s %s -> %s (%s)", logKey(key), ti.discoveryInterval, next, reason)
+		ti.discoveryInterval = next
+	}
+	if now.Sub(ti.lastDiscovery) >= ti.discoveryInterval {
+		ti.lastDiscovery = now
+		if ti.rediscover != nil && peers < p.peerDialTarget {
+			select {
+			case ti.rediscover <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	fanout := int(atomic.LoadInt32(&ti.fetchFanout))
+	if next, reason := ti.tuner.tuneFanout(now, fanout); next != fanout {
+		p.log.Debugf("PubsubFetch: fan-out of %s %d -> %d (%s)", logKey(key), fanout, next, reason)
+		atomic.StoreInt32(&ti.fetchFanout, int32(next))
+	}
+
+	if now.Sub(ti.lastRebroadcast) < ti.rebroadcastInterval {
+		return false, time.Time{}
+	}
+	last := ti.lastRebroadcast
+	ti.lastRebroadcast = now
+	return true, last
+}
@@ -0,0 +1,355 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runTuner(tuner *autoTuner, start time.Time, interval time.Duration, minutes int, updateEvery time.Duration, churnPerMinute int) time.Duration {
+	base := interval
+	now := start
+	nextUpdate := start
+	for i := 0; i < minutes; i++ {
+		for ; updateEvery > 0 && !nextUpdate.After(now); nextUpdate = nextUpdate.Add(updateEvery) {
+			tuner.observeUpdate(nextUpdate)
+		}
+		for j := 0; j < churnPerMinute; j++ {
+			tuner.observeChurn()
+		}
+		now = now.Add(time.Minute)
+		interval, _ = tuner.tune(now, interval, base)
+		if interval < MinAutoTunedRebroadcastInterval || interval > MaxAutoTunedRebroadcastInterval {
+			panic("auto-tuned interval out of bounds")
+		}
+	}
+	return interval
+}
+
+func TestAutoTuneStable(t *testing.T) {
+	start := time.Unix(0, 0)
+	initial := 10 * time.Minute
+
+	// rare updates, no churn: rebroadcast less often
+	interval := runTuner(&autoTuner{}, start, initial, 240, 30*time.Minute, 0)
+	if interval <= initial {
+		t.Fatalf("expected interval to grow from %s, got %s", initial, interval)
+	}
+
+	// frequent updates alone: the updates are broadcast anyways
+	interval = runTuner(&autoTuner{}, start, initial, 240, 10*time.Second, 0)
+	if interval < initial {
+		t.Fatalf("expected interval not to shrink from %s, got %s", initial, interval)
+	}
+
+	// nothing at all: hit the ceiling, not beyond
+	interval = runTuner(&autoTuner{}, start, initial, 240, 0, 0)
+	if interval != MaxAutoTunedRebroadcastInterval {
+		t.Fatalf("expected interval at the ceiling, got %s", interval)
+	}
+}
+
+func TestAutoTuneChurny(t *testing.T) {
+	start := time.Unix(0, 0)
+	initial := 10 * time.Minute
+
+	// same update rate, lots of churn: rebroadcast more often
+	interval := runTuner(&autoTuner{}, start, initial, 240, 30*time.Minute, 20)
+	if interval >= initial {
+		t.Fatalf("expected interval to shrink from %s, got %s", initial, interval)
+	}
+
+	// frequent updates and churn: hit the floor, not beyond
+	interval = runTuner(&autoTuner{}, start, initial, 240, 10*time.Second, 50)
+	if interval != MinAutoTunedRebroadcastInterval {
+		t.Fatalf("expected interval at the floor, got %s", interval)
+	}
+}
+
+func TestAutoTuneNoChange(t *testing.T) {
+	tuner := &autoTuner{}
+	interval := runTuner(tuner, time.Unix(0, 0), 10*time.Minute, 240, 20*time.Minute, 0)
+	// steady state: the same workload doesn't cause adjustments anymore
+	next, reason := tuner.tune(time.Unix(241*60, 0), interval, 10*time.Minute)
+	if next != interval || reason != "" {
+		t.Fatalf("unexpected adjustment in steady state: %s -> %s (%s)", interval, next, reason)
+	}
+}
+
+func TestAutoTuneDiscovery(t *testing.T) {
+	start := time.Unix(0, 0)
+	run := func(peers, churnPerMinute int) time.Duration {
+		tuner := &autoTuner{}
+		interval := peerDialMaxBackoff
+		for m := 1; m <= 240; m++ {
+			for j := 0; j < churnPerMinute; j++ {
+				tuner.observeChurn()
+			}
+			interval, _ = tuner.tuneDiscovery(start.Add(time.Duration(m)*time.Minute), interval, peers, DefaultPeerDialTarget)
+			if interval < MinAutoTunedDiscoveryInterval || interval > MaxAutoTunedDiscoveryInterval {
+				t.Fatalf("auto-tuned discovery interval out of bounds: %s", interval)
+			}
+		}
+		return interval
+	}
+
+	// enough peers and no churn: hit the ceiling
+	if interval := run(DefaultPeerDialTarget, 0); interval != MaxAutoTunedDiscoveryInterval {
+		t.Fatalf("expected the discovery interval at the ceiling, got %s", interval)
+	}
+	// enough peers, but churny: discover more often
+	if interval := run(DefaultPeerDialTarget, 5); interval >= MaxAutoTunedDiscoveryInterval/2 {
+		t.Fatalf("expected the discovery interval to shrink with churn, got %s", interval)
+	}
+	// fewer peers: discover more often
+	few := run(1, 0)
+	if few >= MaxAutoTunedDiscoveryInterval || few <= MinAutoTunedDiscoveryInterval {
+		t.Fatalf("expected the discovery interval within bounds with few peers, got %s", few)
+	}
+	// no peers at all: hit the floor
+	if interval := run(0, 0); interval != MinAutoTunedDiscoveryInterval {
+		t.Fatalf("expected the discovery interval at the floor, got %s", interval)
+	}
+}
+
+func TestAutoTuneFanout(t *testing.T) {
+	start := time.Unix(0, 0)
+	run := func(responseRate float64, churnPerMinute int) int {
+		tuner := &autoTuner{}
+		fanout := 0
+		for m := 1; m <= 240; m++ {
+			for j := 0; j < 10; j++ {
+				tuner.observeFetch(float64(j) < responseRate*10)
+			}
+			for j := 0; j < churnPerMinute; j++ {
+				tuner.observeChurn()
+			}
+			fanout, _ = tuner.tuneFanout(start.Add(time.Duration(m)*time.Minute), fanout)
+			if fanout < MinAutoTunedFetchFanout || fanout > MaxAutoTunedFetchFanout {
+				t.Fatalf("auto-tuned fetch fan-out out of bounds: %d", fanout)
+			}
+		}
+		return fanout
+	}
+
+	// every peer responds and no churn: hit the floor
+	if fanout := run(1, 0); fanout != MinAutoTunedFetchFanout {
+		t.Fatalf("expected the fetch fan-out at the floor, got %d", fanout)
+	}
+	// few peers respond: fetch from more of them
+	if fanout := run(0.1, 0); fanout <= MinAutoTunedFetchFanout || fanout >= MaxAutoTunedFetchFanout {
+		t.Fatalf("expected the fetch fan-out to widen within bounds, got %d", fanout)
+	}
+	// lots of churn: hit the ceiling
+	if fanout := run(1, 20); fanout != MaxAutoTunedFetchFanout {
+		t.Fatalf("expected the fetch fan-out at the ceiling, got %d", fanout)
+	}
+
+	// steady state: no adjustments anymore
+	tuner := &autoTuner{}
+	tuner.observeFetch(true)
+	fanout, reason := tuner.tuneFanout(start, 0)
+	if next, reason := tuner.tuneFanout(start.Add(time.Minute), fanout); next != fanout || reason != "" {
+		t.Fatalf("unexpected adjustment in steady state: %d -> %d (%s)", fanout, next, reason)
+	}
+	if reason == "" {
+		t.Fatal("expected the first adjustment to have a rationale")
+	}
+}
+
+// mockClock is a clock whose time only moves on Add.
+type mockClock struct {
+	mx      sync.Mutex
+	now     time.Time
+	afters  []mockAfter
+	tickers []*mockTicker
+}
+
+type mockAfter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+type mockTicker struct {
+	period  time.Duration
+	next    time.Time
+	ch      chan time.Time
+	stopped chan struct{}
+}
+
+func withClock(c clock) Option {
+	return func(store *PubsubValueStore) error {
+		store.clock = c
+		return nil
+	}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *mockClock) After(d time.Duration) <-chan time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	ch := make(chan time.Time, 1)
+	c.afters = append(c.afters, mockAfter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *mockClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	t := &mockTicker{period: d, next: c.now.Add(d), ch: make(chan time.Time), stopped: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t.ch, func() { close(t.stopped) }
+}
+
+// Add moves the time forward, and waits for the ticks due meanwhile to be
+// received. Once a ticker got all its ticks, the last one is sent again,
+// which is only received once the previous one was handled.
+func (c *mockClock) Add(d time.Duration) {
+	c.mx.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	afters := c.afters[:0]
+	for _, a := range c.afters {
+		if a.at.After(now) {
+			afters = append(afters, a)
+			continue
+		}
+		a.ch <- a.at
+	}
+	c.afters = afters
+	tickers := append([]*mockTicker(nil), c.tickers...)
+	c.mx.Unlock()
+
+	send := func(t *mockTicker, tick time.Time) {
+		select {
+		case t.ch <- tick:
+		case <-t.stopped:
+		}
+	}
+	for _, t := range tickers {
+		var last time.Time
+		for ; !t.next.After(now); t.next = t.next.Add(t.period) {
+			send(t, t.next)
+			last = t.next
+		}
+		if !last.IsZero() {
+			send(t, last)
+		}
+	}
+}
+
+// waitTicker waits for a ticker to be started.
+func (c *mockClock) waitTicker(t *testing.T) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		c.mx.Lock()
+		n := len(c.tickers)
+		c.mx.Unlock()
+		if n > 0 {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the ticker")
+}
+
+func TestAutoTuneKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := &mockClock{now: time.Unix(0, 0)}
+	vs := newTestStore(ctx, t, WithAutoTune(), withClock(clk))
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/namespace/key%d", i)
+		if err := vs.Subscribe(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	churny := keys[0]
+	vs.mx.Lock()
+	topics := make(map[string]*topicInfo, len(keys))
+	for _, k := range keys {
+		topics[k] = vs.topics[k]
+	}
+	vs.mx.Unlock()
+
+	// the other keys get a peer
+	other := newTestStore(ctx, t)
+	for _, k := range keys[1:] {
+		if err := other.Subscribe(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connect(t, vs.host(), other.host())
+	for _, k := range keys[1:] {
+		for start := time.Now(); len(topics[k].topic.ListPeers()) == 0; time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("timed out waiting for a peer of %s", k)
+			}
+		}
+	}
+
+	clk.Add(vs.rebroadcastInitialDelay)
+	clk.waitTicker(t)
+
+	// every key is updated every 30 minutes, staggered, which makes an update
+	// every 90 seconds overall
+	rebroadcasts := make(map[string]int)
+	for m := 0; m < 240; m++ {
+		now := clk.Now()
+		for i, k := range keys {
+			if (m+i)%30 == 0 {
+				topics[k].tuner.observeUpdate(now)
+			}
+		}
+		for i := 0; i < 20; i++ {
+			topics[churny].tuner.observeChurn()
+		}
+		clk.Add(autoTunePeriod)
+		for _, k := range keys {
+			if topics[k].lastRebroadcast.Equal(clk.Now()) {
+				rebroadcasts[k]++
+			}
+		}
+	}
+
+	base := vs.rebroadcastInterval
+	for _, k := range keys[1:] {
+		ti := topics[k]
+		if ti.rebroadcastInterval <= base {
+			t.Fatalf("expected the interval of %s to grow from %s, got %s", k, base, ti.rebroadcastInterval)
+		}
+		if n := rebroadcasts[k]; n >= rebroadcasts[churny] {
+			t.Fatalf("expected %s to be rebroadcast less than the churny key, %d >= %d", k, n, rebroadcasts[churny])
+		}
+		if ti.discoveryInterval <= MinAutoTunedDiscoveryInterval || ti.discoveryInterval >= MaxAutoTunedDiscoveryInterval {
+			t.Fatalf("expected the discovery interval of %s within bounds, got %s", k, ti.discoveryInterval)
+		}
+		if n := atomic.LoadInt32(&ti.fetchFanout); n != MinAutoTunedFetchFanout {
+			t.Fatalf("expected the fetch fan-out of %s at the floor, got %d", k, n)
+		}
+	}
+	ti := topics[churny]
+	if ti.rebroadcastInterval >= base {
+		t.Fatalf("expected the interval of the churny key to shrink from %s, got %s", base, ti.rebroadcastInterval)
+	}
+	// no peers and churny: discover as often as possible
+	if ti.discoveryInterval != MinAutoTunedDiscoveryInterval {
+		t.Fatalf("expected the discovery interval of the churny key at the floor, got %s", ti.discoveryInterval)
+	}
+	if n := atomic.LoadInt32(&ti.fetchFanout); n != MaxAutoTunedFetchFanout {
+		t.Fatalf("expected the fetch fan-out of the churny key at the ceiling, got %d", n)
+	}
+
+	stats := vs.Stats()
+	if stats.RebroadcastInterval != ti.rebroadcastInterval || stats.DiscoveryInterval != MinAutoTunedDiscoveryInterval || stats.FetchFanout != MaxAutoTunedFetchFanout {
+		t.Fatalf("unexpected effective values in stats: %s, %s, %d", stats.RebroadcastInterval, stats.DiscoveryInterval, stats.FetchFanout)
+	}
+}
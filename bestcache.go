@@ -0,0 +1,145 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// bestRevalidateAfter is how long the validity of a cached record is trusted
+// before it is validated again, as it may have expired meanwhile.
+const bestRevalidateAfter = time.Second
+
+// bestCache caches the record of a subscribed key as stored in the record
+// store, and its validity, sparing the topic validator a read and a
+// validation per message. It goes away with the subscription.
+//
+// The cache is updated by the writes of the key, which are serialized by the
+// key's dbWriteMx, and invalidated by the other writes. Every update bumps the
+// generation, so that the reads that raced with it don't fill the cache with
+// the record they read before.
+type bestCache struct {
+	mx     sync.Mutex
+	gen    uint64
+	loaded bool
+	// nil if there is no record
+	val []byte
+
+	// result of the last validation of val, with the validator generation it
+	// was validated with; unset if checked is zero
+	checked      time.Time
+	validatorGen uint64
+	validErr     error
+}
+
+// cacheOf returns the key's cache, nil if the key isn't subscribed to.
+func (p *PubsubValueStore) cacheOf(key string) *bestCache {
+	v, ok := p.live.Load(key)
+	if !ok {
+		return nil
+	}
+	return &v.(*topicInfo).best
+}
+
+// getBest is records.GetBest, through the key's cache if it is subscribed to.
+func (p *PubsubValueStore) getBest(ctx context.Context, key string) ([]byte, error) {
+	c := p.cacheOf(key)
+	if c == nil {
+		return p.records.GetBest(ctx, key)
+	}
+
+	c.mx.Lock()
+	if c.loaded {
+		val := c.val
+		c.mx.Unlock()
+		if val == nil {
+			return nil, routing.ErrNotFound
+		}
+		return val, nil
+	}
+	gen := c.gen
+	c.mx.Unlock()
+
+	val, err := p.records.GetBest(ctx, key)
+	if err != nil && err != routing.ErrNotFound {
+		return nil, err
+	}
+	c.mx.Lock()
+	if c.gen == gen {
+		c.loaded = true
+		c.val = val
+		c.checked = time.Time{}
+	}
+	c.mx.Unlock()
+	return val, err
+}
+
+// validateBest is validator.Validate, reusing the cached validity of val if it
+// is the key's cached record, validated recently by the current validator.
+func (p *PubsubValueStore) validateBest(validator record.Validator, key string, val []byte) error {
+	c := p.cacheOf(key)
+	if c == nil {
+		return validator.Validate(key, val)
+	}
+
+	vgen := atomic.LoadUint64(&p.validatorGen)
+	c.mx.Lock()
+	cached := c.loaded && bytes.Equal(c.val, val)
+	if cached && !c.checked.IsZero() && c.validatorGen == vgen && time.Since(c.checked) < bestRevalidateAfter {
+		err := c.validErr
+		c.mx.Unlock()
+		return err
+	}
+	gen := c.gen
+	c.mx.Unlock()
+
+	err := validator.Validate(key, val)
+	if cached {
+		c.mx.Lock()
+		if c.gen == gen {
+			c.checked = time.Now()
+			c.validatorGen = vgen
+			c.validErr = err
+		}
+		c.mx.Unlock()
+	}
+	return err
+}
+
+// bestWritten updates the key's cache with the valid record just written to
+// the record store.
+// Requires that the ti.dbWriteMx is held when called.
+func (p *PubsubValueStore) bestWritten(key string, val []byte) {
+	c := p.cacheOf(key)
+	if c == nil {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.gen++
+	c.loaded = true
+	c.val = val
+	c.checked = time.Now()
+	c.validatorGen = atomic.LoadUint64(&p.validatorGen)
+	c.validErr = nil
+}
+
+// invalidateBest drops the key's cached record, e.g. once changed in the record
+// store by a write that isn't serialized with the others.
+func (p *PubsubValueStore) invalidateBest(key string) {
+	c := p.cacheOf(key)
+	if c == nil {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.gen++
+	c.loaded = false
+	c.val = nil
+	c.checked = time.Time{}
+}
@@ -0,0 +1,104 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// bootstrapConcurrency is the maximum number of keys Bootstrap works on
+// concurrently.
+const bootstrapConcurrency = 16
+
+// BootstrapError is returned by Bootstrap when some of the subscribed keys
+// could not be bootstrapped. It maps each failing key to its error.
+type BootstrapError map[string]error
+
+func (e BootstrapError) Error() string {
+	return fmt.Sprintf("failed to bootstrap %d keys: %s", len(e), formatKeyErrors(e))
+}
+
+// Bootstrap dials the remembered peers of every subscribed key, see
+// WithRememberedPeers, or those set with WithBootstrapPeers, and fetches the
+// key's record from its topic's peers. Keys subscribed to WithoutBootstrap are
+// skipped.
+// Keys are bootstrapped concurrently and independently of each other: a
+// BootstrapError describing the keys that could not be is returned.
+//
+// It has the signature routing helpers look for, so that composing the store
+// with others bootstraps it too.
+func (p *PubsubValueStore) Bootstrap(ctx context.Context) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "Bootstrap")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if p.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	var (
+		errsLk sync.Mutex
+		errs   = BootstrapError{}
+		wg     sync.WaitGroup
+		limit  = make(chan struct{}, bootstrapConcurrency)
+	)
+
+	setErr := func(key string, err error) {
+		errsLk.Lock()
+		errs[key] = err
+		errsLk.Unlock()
+	}
+
+	for _, key := range p.GetSubscriptions() {
+		select {
+		case limit <- struct{}{}:
+		case <-ctx.Done():
+			setErr(key, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			if err := p.bootstrapKey(ctx, key); err != nil {
+				setErr(key, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *PubsubValueStore) bootstrapKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mx.Lock()
+	_, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		// canceled meanwhile
+		return nil
+	}
+	if p.bootstrapFor(key).skip {
+		return nil
+	}
+
+	p.dialPass(ctx, key)
+	select {
+	case <-p.fetchMissing(key):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return ErrClosed
+	}
+}
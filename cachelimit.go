@@ -0,0 +1,172 @@
+package namesys
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// MetricRecordsEvicted counts the records deleted to keep the number of
+// stored records within the limit set with WithMaxCachedRecords.
+const MetricRecordsEvicted = "records_evicted"
+
+// WithMaxCachedRecords returns an option that limits the number of records
+// stored, e.g. on a resolver storing the record of every key ever looked up.
+// Once the limit is exceeded, the least recently read or written record of a
+// key that isn't subscribed to is deleted, along with its metadata, history
+// and remembered peers. The keys watched or subscribed to, e.g. through
+// Subscribe, are never evicted, and may keep the store above the limit. The
+// authored and sequence number entries of the keys this node published
+// survive eviction, as republishing and sealing them later depend on them, so
+// they are bounded by this node's own writes instead. Zero, the default,
+// means unlimited.
+//
+// The records stored before the store was constructed are only accounted for
+// if the record store implements RecordLister, as the default one does.
+func WithMaxCachedRecords(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max cached records: %d", n)
+		}
+		store.maxCachedRecords = n
+		return nil
+	}
+}
+
+// cachedRecords is the LRU of the keys with a stored record, see
+// WithMaxCachedRecords.
+type cachedRecords struct {
+	mx sync.Mutex
+	// front is the most recently used
+	lru   *list.List
+	elems map[string]*list.Element
+	// writes in progress per key, which keep it from being evicted meanwhile
+	writing map[string]int
+}
+
+// loadCachedRecords accounts for the records already stored, and evicts the
+// ones above the limit.
+func (p *PubsubValueStore) loadCachedRecords(ctx context.Context) {
+	p.cached.lru = list.New()
+	p.cached.elems = make(map[string]*list.Element)
+	p.cached.writing = make(map[string]int)
+
+	lister, ok := p.records.(RecordLister)
+	if !ok {
+		return
+	}
+	keys, err := lister.Keys(ctx)
+	if err != nil {
+		p.log.Warnf("failed to list the stored records: %s", err)
+		return
+	}
+	p.cached.mx.Lock()
+	defer p.cached.mx.Unlock()
+	for _, key := range keys {
+		if _, ok := p.cached.elems[key]; !ok {
+			p.cached.elems[key] = p.cached.lru.PushBack(key)
+		}
+	}
+	p.evictCachedLocked(ctx)
+}
+
+// touchCached marks the key's record as just used.
+func (p *PubsubValueStore) touchCached(key string) {
+	if p.maxCachedRecords == 0 {
+		return
+	}
+	p.cached.mx.Lock()
+	defer p.cached.mx.Unlock()
+	if e, ok := p.cached.elems[key]; ok {
+		p.cached.lru.MoveToFront(e)
+		return
+	}
+	p.cached.elems[key] = p.cached.lru.PushFront(key)
+}
+
+// cacheWriting keeps the key from being evicted until the returned function
+// is called, with whether the record was stored, which then makes room for
+// it if needed. A write following an eviction of the key stores its record
+// again.
+func (p *PubsubValueStore) cacheWriting(ctx context.Context, key string) func(stored bool) {
+	if p.maxCachedRecords == 0 {
+		return func(bool) {}
+	}
+	p.cached.mx.Lock()
+	p.cached.writing[key]++
+	p.cached.mx.Unlock()
+
+	return func(stored bool) {
+		p.cached.mx.Lock()
+		defer p.cached.mx.Unlock()
+		if p.cached.writing[key]--; p.cached.writing[key] == 0 {
+			delete(p.cached.writing, key)
+		}
+		if !stored {
+			return
+		}
+		if e, ok := p.cached.elems[key]; ok {
+			p.cached.lru.MoveToFront(e)
+		} else {
+			p.cached.elems[key] = p.cached.lru.PushFront(key)
+		}
+		p.evictCachedLocked(ctx)
+	}
+}
+
+// uncache forgets the key, whose record was deleted.
+func (p *PubsubValueStore) uncache(key string) {
+	if p.maxCachedRecords == 0 {
+		return
+	}
+	p.cached.mx.Lock()
+	defer p.cached.mx.Unlock()
+	if e, ok := p.cached.elems[key]; ok {
+		p.cached.lru.Remove(e)
+		delete(p.cached.elems, key)
+	}
+}
+
+// evictCachedLocked deletes the least recently used records of the keys
+// neither subscribed to nor being written until the limit is met, or there are
+// none left. Watched keys are subscribed to.
+// Must be called with p.cached.mx held, which holds the writes of the evicted
+// keys back until their record is deleted.
+func (p *PubsubValueStore) evictCachedLocked(ctx context.Context) {
+	e := p.cached.lru.Back()
+	for len(p.cached.elems) > p.maxCachedRecords && e != nil {
+		prev := e.Prev()
+		key := e.Value.(string)
+		_, subscribed := p.live.Load(key)
+		if !subscribed && p.cached.writing[key] == 0 {
+			if err := p.evictRecord(ctx, key); err != nil {
+				p.reportError(key, OpStore, err)
+			} else {
+				p.cached.lru.Remove(e)
+				delete(p.cached.elems, key)
+			}
+		}
+		e = prev
+	}
+}
+
+// evictRecord deletes the key's record, and its metadata, history and
+// remembered peers, as purging it does.
+func (p *PubsubValueStore) evictRecord(ctx context.Context, key string) error {
+	if err := p.records.Delete(ctx, key); err != nil {
+		return err
+	}
+	// subscribed to meanwhile
+	p.invalidateBest(key)
+	for _, k := range []ds.Key{metaKey(key), historyKey(key), peersKey(key)} {
+		if err := p.ds.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	p.count(&p.stats.recordsEvicted, MetricRecordsEvicted)
+	p.log.Debugf("PubsubCache: evicted the record of %s", logKey(key))
+	return nil
+}
@@ -0,0 +1,102 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+)
+
+// ValueChangedFunc is called when the best known value for a key changes.
+type ValueChangedFunc func(key string, val []byte)
+
+// valueCallback is a registered ValueChangedFunc. Every callback runs on its
+// own goroutine, so a slow callback can't stall the subscription loop or the
+// other watchers of the key.
+type valueCallback struct {
+	key string
+	fn  ValueChangedFunc
+
+	// last value delivered, guarded by the store's watchLk
+	last []byte
+
+	// Note: this chan must be buffered, see deliver
+	next chan []byte
+	done chan struct{}
+	once sync.Once
+}
+
+// deliver hands the value over to the callback goroutine. If the callback
+// hasn't picked up the previous value yet, it is replaced by the newer one.
+func (cb *valueCallback) deliver(data []byte) {
+	select {
+	case <-cb.next:
+		cb.next <- data
+	case cb.next <- data:
+	}
+}
+
+func (cb *valueCallback) run() {
+	for {
+		select {
+		case val := <-cb.next:
+			// don't fire after unregister, even if a value was pending
+			select {
+			case <-cb.done:
+				return
+			default:
+			}
+			cb.fn(cb.key, val)
+		case <-cb.done:
+			return
+		}
+	}
+}
+
+// RegisterOnValueChanged subscribes to the key (if not already subscribed)
+// and calls fn every time the best known value for the key changes, including
+// changes made by local PutValue calls.
+//
+// The returned function unregisters the callback. It may be called multiple
+// times, including from within the callback itself.
+func (p *PubsubValueStore) RegisterOnValueChanged(key string, fn ValueChangedFunc) (unregister func(), err error) {
+	return p.registerCallback(key, fn, false)
+}
+
+// registerCallback is RegisterOnValueChanged, also calling fn with the stored
+// record first if initial is set.
+func (p *PubsubValueStore) registerCallback(key string, fn ValueChangedFunc, initial bool) (func(), error) {
+	if err := p.subscribe(context.Background(), key, false); err != nil {
+		return nil, err
+	}
+
+	cb := &valueCallback{
+		key:  key,
+		fn:   fn,
+		next: make(chan []byte, 1),
+		done: make(chan struct{}),
+	}
+
+	// as in Search, the records accepted after the read are delivered
+	p.watchLk.Lock()
+	if initial {
+		if lv, err := p.getLocal(p.ctx, key); err == nil {
+			cb.last = lv
+			cb.deliver(p.open(lv))
+		}
+	}
+	wg := p.watchGroupLocked(key)
+	wg.callbacks[cb] = struct{}{}
+	p.watchLk.Unlock()
+
+	go cb.run()
+
+	return func() {
+		cb.once.Do(func() {
+			close(cb.done)
+
+			p.watchLk.Lock()
+			delete(wg.callbacks, cb)
+			p.dropWatchGroupLocked(key, wg)
+			p.watchLk.Unlock()
+		})
+	}, nil
+}
@@ -0,0 +1,106 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// ErrCASMismatch matches the CASMismatchError returned by PutValueIf when the
+// current record isn't the expected one, with errors.Is.
+var ErrCASMismatch = errors.New("current record mismatch")
+
+// CASMismatchError is returned by PutValueIf and PutValueIfFunc when the
+// key's current record isn't the expected one. Current is the record found,
+// nil if there is none.
+type CASMismatchError struct {
+	Current []byte
+}
+
+func (e *CASMismatchError) Error() string {
+	if e.Current == nil {
+		return fmt.Sprintf("%s: no current record", ErrCASMismatch)
+	}
+	return fmt.Sprintf("%s: current record is %q", ErrCASMismatch, e.Current)
+}
+
+// Is reports whether target is ErrCASMismatch.
+func (e *CASMismatchError) Is(target error) bool {
+	return target == ErrCASMismatch
+}
+
+// PutValueIf is PutValue, publishing value only if the locally stored best
+// record of the key is expectedCurrent, byte for byte. A nil expectedCurrent
+// expects the key to have no record. A CASMismatchError carrying the current
+// record is returned otherwise.
+//
+// The check and the write are atomic with respect to the other writes of the
+// key, local or received from the network. The check is against the record
+// we know of though: the network may already have seen a newer one.
+func (p *PubsubValueStore) PutValueIf(ctx context.Context, key string, expectedCurrent, value []byte, opts ...routing.Option) error {
+	return p.PutValueIfFunc(ctx, key, func(current []byte) bool {
+		if expectedCurrent == nil {
+			return current == nil
+		}
+		return current != nil && bytes.Equal(current, expectedCurrent)
+	}, value, opts...)
+}
+
+// PutValueIfFunc is PutValueIf, with match deciding whether the current
+// record, nil if there is none, is the expected one. match is called with the
+// key's writes held back, it should be quick.
+func (p *PubsubValueStore) PutValueIfFunc(ctx context.Context, key string, match func(current []byte) bool, value []byte, opts ...routing.Option) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "PutValueIf")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if match == nil {
+		return errors.New("invalid match function: nil")
+	}
+	if _, err := parseOptions(opts, false, skipSecondaryKey{}, ttlKey{}); err != nil {
+		return err
+	}
+	if err := p.checkTTL(opts); err != nil {
+		return err
+	}
+	if err := p.putValueIf(ctx, key, match, value, ttlOf(opts), nil); err != nil {
+		return err
+	}
+	// the secondary store can't take part in the check, it only gets the
+	// records that passed it
+	if p.secondary != nil && !skipSecondary(opts) {
+		if err := p.secondary.PutValue(ctx, key, value, opts...); err != nil {
+			return &HybridError{DHT: err}
+		}
+	}
+	return nil
+}
+
+// checkCurrent returns a CASMismatchError unless match accepts the key's
+// current record. An invalid record, e.g. an expired one, counts as none, as
+// any valid record replaces it.
+// Requires that the ti.dbWriteMx is held when called.
+func (p *PubsubValueStore) checkCurrent(ctx context.Context, key string, match func(current []byte) bool) error {
+	current, err := p.getStored(ctx, key)
+	switch {
+	case err == routing.ErrNotFound:
+		current = nil
+	case err != nil:
+		return err
+	case p.recordValidator().Validate(key, current) != nil:
+		current = nil
+	default:
+		current = p.open(current)
+	}
+	if !match(current) {
+		return &CASMismatchError{Current: current}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package namesys
+
+import "time"
+
+// clock is the time source of the rebroadcast loop, which tests replace to
+// drive the auto-tuning, see WithAutoTune.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns the ticks, and a function stopping them.
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
@@ -0,0 +1,160 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WithWriteCoalescing returns an option that delays writing accepted records.
+// They are served from memory right away, and each key's best record is
+// written at most once per interval, on Cancel and on Close. This spares the
+// datastore a write per update on busy topics.
+//
+// Records accepted since the last write of their key are lost if the process
+// dies before the next one, and the history set with WithHistory only keeps
+// the records that were written. Write errors are reported on Errors rather
+// than returned. Disabled by default.
+func WithWriteCoalescing(interval time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid write coalescing interval: %s", interval)
+		}
+		store.coalesceInterval = interval
+		return nil
+	}
+}
+
+// pendingWrite is a record waiting to be written by its key's flusher.
+type pendingWrite struct {
+	value []byte
+	meta  RecordMeta
+}
+
+// pendingWrites holds the best records accepted since the last write of their
+// key, see WithWriteCoalescing.
+type pendingWrites struct {
+	mx   sync.Mutex
+	recs map[string]pendingWrite
+}
+
+func (w *pendingWrites) get(key string) []byte {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.recs[key].value
+}
+
+func (w *pendingWrites) keys() []string {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	keys := make([]string, 0, len(w.recs))
+	for k := range w.recs {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (w *pendingWrites) forget(key string) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	delete(w.recs, key)
+}
+
+// putPending is putLocal with write coalescing: it keeps the value in memory
+// if it's better than the current record, and schedules its write.
+// Requires that the ti.dbWriteMx is held when called.
+func (p *PubsubValueStore) putPending(ctx context.Context, key string, value []byte, meta RecordMeta, checked *verdict) (int, error) {
+	validator := p.recordValidator()
+	if checked == nil && validator.Validate(key, value) != nil {
+		return -1, nil
+	}
+	old, err := p.getStored(ctx, key)
+	if err != nil {
+		old = nil
+	}
+	var cmp int
+	if checked.holds(old) {
+		cmp = checked.cmp
+	} else {
+		if old != nil && p.validateBest(validator, key, old) != nil {
+			old = nil
+		}
+		cmp = p.compareRecords(validator, key, value, old)
+	}
+	if cmp <= 0 {
+		return cmp, nil
+	}
+
+	p.pending.mx.Lock()
+	defer p.pending.mx.Unlock()
+	if p.pending.recs == nil {
+		p.pending.recs = make(map[string]pendingWrite)
+	}
+	_, scheduled := p.pending.recs[key]
+	p.pending.recs[key] = pendingWrite{value: value, meta: meta}
+	if !scheduled {
+		go p.flushLater(key)
+	}
+	return cmp, nil
+}
+
+// flushLater writes the key's pending record every coalescing interval until
+// there is none left, or the store is closed.
+func (p *PubsubValueStore) flushLater(key string) {
+	timer := time.NewTimer(p.coalesceInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			// written by Close
+			return
+		}
+		if !p.flushPending(p.ctx, key) {
+			return
+		}
+		timer.Reset(p.coalesceInterval)
+	}
+}
+
+// flushPending writes the key's pending record, if any. It returns whether a
+// better record was accepted while writing, which is left pending.
+func (p *PubsubValueStore) flushPending(ctx context.Context, key string) bool {
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if ok {
+		ti.dbWriteMx.Lock()
+		defer ti.dbWriteMx.Unlock()
+	}
+
+	p.pending.mx.Lock()
+	w, ok := p.pending.recs[key]
+	p.pending.mx.Unlock()
+	if !ok {
+		return false
+	}
+
+	if _, err := p.writeLocal(ctx, ti, key, w.value, w.meta, nil); err != nil {
+		p.warnf(key, logStore, "PubsubResolve: error writing %s: %s", logKey(key), err)
+		p.reportError(key, OpStore, err)
+	}
+
+	p.pending.mx.Lock()
+	defer p.pending.mx.Unlock()
+	if cur, ok := p.pending.recs[key]; ok && !bytes.Equal(cur.value, w.value) {
+		return true
+	}
+	delete(p.pending.recs, key)
+	return false
+}
+
+// flushAllPending writes all the pending records.
+func (p *PubsubValueStore) flushAllPending(ctx context.Context) {
+	for _, key := range p.pending.keys() {
+		for p.flushPending(ctx, key) {
+		}
+	}
+}
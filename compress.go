@@ -0,0 +1,78 @@
+package namesys
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// compressedMagic prefixes the gzipped values published with
+// WithCompression. Values without it are published as is.
+var compressedMagic = []byte("\x00gz")
+
+// ErrCorruptCompression is the validation error of the messages whose value
+// can't be decompressed.
+var ErrCorruptCompression = errors.New("corrupt compressed value")
+
+// maxDecompressedSize bounds the values decompressed without a maximum record
+// size, so that a small message can't decompress to an unbounded value.
+const maxDecompressedSize = 16 << 20
+
+// WithCompression returns an option that gzips the values published through
+// pubsub, when that makes them smaller. Values are stored and validated
+// decompressed, and stores decompress the values they receive whether or not
+// they use the option, so it only needs every node of the network to run a
+// version supporting it. Values decompressing past the maximum record size,
+// or 16 MiB if it is unlimited, are rejected. Disabled by default.
+func WithCompression() Option {
+	return func(store *PubsubValueStore) error {
+		store.compression = true
+		return nil
+	}
+}
+
+// compress returns the value to publish, compressed if enabled and worth it.
+func (p *PubsubValueStore) compress(value []byte) []byte {
+	if !p.compression {
+		return value
+	}
+	var buf bytes.Buffer
+	buf.Write(compressedMagic)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(value); err != nil {
+		return value
+	}
+	if err := zw.Close(); err != nil {
+		return value
+	}
+	if buf.Len() >= len(value) {
+		return value
+	}
+	return buf.Bytes()
+}
+
+// decompress returns the value carried by a message. Values larger than the
+// maximum record size are cut just past it, for the size check to reject
+// them, and values larger than maxDecompressedSize are rejected without one.
+func (p *PubsubValueStore) decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressedMagic) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data[len(compressedMagic):]))
+	if err != nil {
+		return nil, ErrCorruptCompression
+	}
+	limit := maxDecompressedSize
+	if p.maxRecordSize > 0 {
+		limit = p.maxRecordSize
+	}
+	value, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, ErrCorruptCompression
+	}
+	if p.maxRecordSize == 0 && len(value) > limit {
+		return nil, ErrRecordTooLarge
+	}
+	return value, nil
+}
@@ -0,0 +1,167 @@
+package namesys
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+	record "github.com/libp2p/go-libp2p-record"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// decommissionBatchSize is the number of keys DecommissionNamespace tears down
+// before yielding to other operations.
+const decommissionBatchSize = 100
+
+// DecommissionReport describes the outcome of a DecommissionNamespace call.
+type DecommissionReport struct {
+	Namespace string
+	// Canceled is the number of subscriptions that were canceled.
+	Canceled int
+	// Purged is the number of records deleted from the datastore.
+	Purged int
+	// Failed maps the keys that couldn't be torn down to the reason why.
+	Failed map[string]error
+	// Remaining is the number of keys that weren't processed because the
+	// context ended. Calling DecommissionNamespace again resumes the work.
+	Remaining int
+}
+
+// DecommissionNamespace cancels every subscription in the namespace and, if
+// purge is set, deletes the records of the namespace, subscribed to or not,
+// along with their metadata, history, remembered peers, and authored and
+// sequence number entries. A record is deleted last, so that calling
+// DecommissionNamespace again retries the keys whose purge failed. Listing the
+// records that aren't subscribed to requires a record store implementing
+// RecordLister, as the default one does. Keys are processed in small batches so
+// that other namespaces keep being served in the meantime.
+// Progress is reported as events on the "DecommissionNamespace" span.
+//
+// Keys with active watchers are not canceled and are reported in Failed. If
+// ctx ends, the report of the work done so far is returned along with the
+// context's error.
+func (p *PubsubValueStore) DecommissionNamespace(ctx context.Context, ns string, purge bool) (DecommissionReport, error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "DecommissionNamespace", Attr("namespace", ns))
+	defer span.End()
+
+	report := DecommissionReport{
+		Namespace: ns,
+		Failed:    make(map[string]error),
+	}
+
+	keys, err := p.namespaceKeys(ctx, ns, purge)
+	if err != nil {
+		span.RecordError(err)
+		return report, err
+	}
+
+	for start := 0; start < len(keys); start += decommissionBatchSize {
+		if err := ctx.Err(); err != nil {
+			report.Remaining = len(keys) - start
+			span.RecordError(err)
+			return report, err
+		}
+
+		end := start + decommissionBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		for _, k := range keys[start:end] {
+			p.decommissionKey(ctx, k, purge, &report)
+		}
+
+		span.AddEvent("batch",
+			Attr("done", strconv.Itoa(end)),
+			Attr("total", strconv.Itoa(len(keys))),
+		)
+		p.log.Debugf("PubsubDecommission: %s: %d/%d keys", ns, end, len(keys))
+
+		// let other operations grab the locks
+		runtime.Gosched()
+	}
+
+	return report, nil
+}
+
+// namespaceKeys returns the subscribed keys of the namespace, sorted, along
+// with the keys of its stored records if stored is set.
+func (p *PubsubValueStore) namespaceKeys(ctx context.Context, ns string, stored bool) ([]string, error) {
+	inNamespace := func(k string) bool {
+		kns, _, err := record.SplitKey(k)
+		return err == nil && kns == ns
+	}
+
+	seen := make(map[string]struct{})
+	p.mx.Lock()
+	for k := range p.topics {
+		if inNamespace(k) {
+			seen[k] = struct{}{}
+		}
+	}
+	p.mx.Unlock()
+
+	if _, ok := p.records.(RecordLister); stored && ok {
+		prefix := "/" + ns + "/"
+		ks, err := p.ListKeys(ctx, prefix, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			if !strings.HasPrefix(k, prefix) {
+				break
+			}
+			if inNamespace(k) {
+				seen[k] = struct{}{}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (p *PubsubValueStore) decommissionKey(ctx context.Context, key string, purge bool, report *DecommissionReport) {
+	ok, err := p.Cancel(key)
+	if err != nil {
+		report.Failed[key] = err
+		return
+	}
+	if ok {
+		report.Canceled++
+	}
+	if !purge {
+		return
+	}
+
+	p.unsaved.forget(key)
+	p.pending.forget(key)
+	_, err = p.records.GetBest(ctx, key)
+	if err == routing.ErrNotFound {
+		return
+	}
+	if err != nil {
+		report.Failed[key] = err
+		return
+	}
+	for _, k := range []ds.Key{metaKey(key), historyKey(key), peersKey(key), authoredKey(key), seqKey(key)} {
+		if err := p.ds.Delete(ctx, k); err != nil {
+			report.Failed[key] = err
+			return
+		}
+	}
+	if err := p.records.Delete(ctx, key); err != nil {
+		report.Failed[key] = err
+		return
+	}
+	p.invalidateBest(key)
+	p.uncache(key)
+	report.Purged++
+}
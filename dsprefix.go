@@ -0,0 +1,101 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// DefaultDatastorePrefix is the datastore namespace of the store's entries,
+// see WithDatastorePrefix.
+const DefaultDatastorePrefix = "/pubsub-valuestore"
+
+// WithDatastorePrefix returns an option that sets the namespace the store
+// keeps its records, metadata, history and subscriptions under in the
+// datastore, so that it can be shared with other components. Defaults to
+// DefaultDatastorePrefix; "/" puts them at the root, as before the prefix was
+// introduced, see WithDatastoreMigration.
+func WithDatastorePrefix(prefix string) Option {
+	return func(store *PubsubValueStore) error {
+		if prefix == "" || prefix[0] != '/' {
+			return fmt.Errorf("invalid datastore prefix: %q", prefix)
+		}
+		store.dsPrefix = ds.NewKey(prefix)
+		return nil
+	}
+}
+
+// WithDatastoreMigration returns an option that moves the entries written at
+// the root of the datastore, before its namespace was introduced, under the
+// datastore prefix when the store is created. The root must not hold someone
+// else's entries that the store would mistake for records, i.e. dshelp-encoded
+// keys.
+func WithDatastoreMigration() Option {
+	return func(store *PubsubValueStore) error {
+		store.dsMigrate = true
+		return nil
+	}
+}
+
+// legacyPrefixes are the namespaces, besides the records, that the store used
+// at the datastore root.
+var legacyPrefixes = []ds.Key{metaPrefix, historyPrefix, seqPrefix, subscriptionsPrefix}
+
+// legacyEntry reports whether the datastore root key k is one of the store's.
+func legacyEntry(k ds.Key) bool {
+	for _, prefix := range legacyPrefixes {
+		if prefix.IsAncestorOf(k) {
+			return true
+		}
+	}
+	// records, at the top level
+	if len(k.Namespaces()) != 1 {
+		return false
+	}
+	_, err := dshelp.BinaryFromDsKey(k)
+	return err == nil
+}
+
+// migrateDatastore moves the store's entries at the root of d under prefix.
+func migrateDatastore(ctx context.Context, d ds.Datastore, prefix ds.Key) error {
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		k := ds.RawKey(e.Key)
+		if k == prefix || prefix.IsAncestorOf(k) || !legacyEntry(k) {
+			continue
+		}
+		if err := d.Put(ctx, prefix.Child(k), e.Value); err != nil {
+			return err
+		}
+		if err := d.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixDatastore migrates and wraps the store's datastore under its prefix.
+func (p *PubsubValueStore) prefixDatastore() error {
+	if p.dsPrefix.String() == "/" {
+		return nil
+	}
+	if p.dsMigrate {
+		if err := migrateDatastore(p.ctx, p.ds, p.dsPrefix); err != nil {
+			return fmt.Errorf("migrating the datastore: %w", err)
+		}
+	}
+	p.ds = namespace.Wrap(p.ds, p.dsPrefix)
+	return nil
+}
@@ -0,0 +1,237 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pb "github.com/libp2p/go-libp2p-pubsub-router/pb"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// envelopeMagic prefixes the records wrapped in an envelope. Records without
+// it are plain values, e.g. published by a store without WithEnvelopes.
+var envelopeMagic = []byte("\x00env")
+
+var seqPrefix = ds.NewKey("/seq")
+
+// seqKey returns the datastore key of the last sequence number we published
+// for the key.
+func seqKey(key string) ds.Key {
+	return seqPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+// WithEnvelopes returns an option that makes PutValue wrap values in an
+// envelope carrying a sequence number, incremented on each publish and
+// persisted per key. Records the validator considers equal are then ordered by
+// sequence number and publisher, so that every node keeps the same one. Plain
+// records count as sequence 0.
+//
+// Every store opens envelopes, with or without this option, so that stores
+// with and without it interoperate: the option only makes PutValue seal its
+// records. Envelopes are opened before values are handed out by GetValue,
+// SearchValue and the watchers, while Export, Import and GetHistory deal in
+// the records as stored. It can't be used with WithMerger.
+func WithEnvelopes() Option {
+	return func(store *PubsubValueStore) error {
+		store.envelopes = true
+		return nil
+	}
+}
+
+// openRecord returns the envelope of val. Plain records come in an envelope of
+// sequence 0 without a publisher.
+func openRecord(val []byte) (*pb.Envelope, error) {
+	if !bytes.HasPrefix(val, envelopeMagic) {
+		return &pb.Envelope{Payload: val}, nil
+	}
+	env := new(pb.Envelope)
+	if err := env.Unmarshal(val[len(envelopeMagic):]); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// payload returns the value wrapped in val, or val itself if it isn't an
+// envelope.
+func payload(val []byte) []byte {
+	env, err := openRecord(val)
+	if err != nil {
+		return val
+	}
+	return env.Payload
+}
+
+// open returns the value handed out for the stored record val.
+func (p *PubsubValueStore) open(val []byte) []byte {
+	if val == nil {
+		return val
+	}
+	return payload(val)
+}
+
+// seal wraps value in an envelope with the key's next sequence number, which
+// is persisted before returning, the publisher from, and the expiry of the ttl
+// if not 0.
+// Requires that the ti.dbWriteMx is held when called.
+func (p *PubsubValueStore) seal(ctx context.Context, key string, value []byte, ttl time.Duration, from peer.ID) ([]byte, error) {
+	var seq uint64
+	b, err := p.ds.Get(ctx, seqKey(key))
+	switch {
+	case err == nil && len(b) == 8:
+		seq = binary.BigEndian.Uint64(b)
+	case err == nil:
+		return nil, errors.New("corrupt sequence number")
+	case err != ds.ErrNotFound:
+		return nil, err
+	}
+	// stay ahead of the current record, e.g. if we lost our sequence numbers
+	// or the clock went back
+	now := time.Now()
+	wallclock := now.UnixNano()
+	if cur, err := p.getBest(ctx, key); err == nil {
+		if env, err := openRecord(cur); err == nil {
+			if env.Seq > seq {
+				seq = env.Seq
+			}
+			if env.Wallclock >= wallclock {
+				wallclock = env.Wallclock + 1
+			}
+		}
+	}
+	seq++
+
+	b = make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	if err := p.ds.Put(ctx, seqKey(key), b); err != nil {
+		return nil, err
+	}
+
+	env := &pb.Envelope{
+		Seq:       seq,
+		Wallclock: wallclock,
+		Publisher: []byte(from),
+		Payload:   value,
+	}
+	if ttl > 0 {
+		env.Expires = now.Add(ttl).UnixNano()
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, envelopeMagic...), data...), nil
+}
+
+// recordValidator returns the validator of the records as stored: the current
+// validator, wrapped to open envelopes.
+func (p *PubsubValueStore) recordValidator() record.Validator {
+	return envelopeValidator{p.GetValidator()}
+}
+
+// envelopeValidator validates and selects the payloads of the records. Among
+// the records the wrapped validator can't tell apart, the one with the highest
+// sequence number and then publisher wins, and the highest raw record after
+// that. The record sealed last wins first if the wrapped validator is an
+// AcceptAllValidator.
+type envelopeValidator struct {
+	record.Validator
+}
+
+func (v envelopeValidator) Validate(key string, value []byte) error {
+	env, err := openRecord(value)
+	if err != nil {
+		return err
+	}
+	if expired(env, time.Now()) {
+		return ErrRecordExpired
+	}
+	return v.Validator.Validate(key, env.Payload)
+}
+
+func (v envelopeValidator) Select(key string, values [][]byte) (int, error) {
+	if !anyEnveloped(values) {
+		return v.Validator.Select(key, values)
+	}
+	envs := make([]*pb.Envelope, len(values))
+	payloads := make([][]byte, len(values))
+	for i, val := range values {
+		env, err := openRecord(val)
+		if err != nil {
+			// left for the validator to reject
+			env = &pb.Envelope{Payload: val}
+		}
+		envs[i] = env
+		payloads[i] = env.Payload
+	}
+
+	best, err := v.Validator.Select(key, payloads)
+	if err != nil {
+		return 0, err
+	}
+	for i := range values {
+		if i == best || !v.tied(key, payloads[i], payloads[best]) {
+			continue
+		}
+		c := compareEnvelopes(envs[i], envs[best])
+		if lastWriterWins(v.Validator) {
+			c = compareWallclocks(envs[i], envs[best])
+		}
+		if c > 0 || (c == 0 && bytes.Compare(values[i], values[best]) > 0) {
+			best = i
+		}
+	}
+	return best, nil
+}
+
+// anyEnveloped reports whether any of the records is an envelope.
+func anyEnveloped(values [][]byte) bool {
+	for _, val := range values {
+		if bytes.HasPrefix(val, envelopeMagic) {
+			return true
+		}
+	}
+	return false
+}
+
+// tied reports whether the wrapped validator selects a or b by position
+// only, e.g. whichever comes first.
+func (v envelopeValidator) tied(key string, a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	i, err := v.Validator.Select(key, [][]byte{a, b})
+	if err != nil {
+		return false
+	}
+	j, err := v.Validator.Select(key, [][]byte{b, a})
+	return err == nil && i == j
+}
+
+// compareWallclocks orders envelopes by the time they were sealed, then as
+// compareEnvelopes does.
+func compareWallclocks(a, b *pb.Envelope) int {
+	switch {
+	case a.Wallclock > b.Wallclock:
+		return 1
+	case a.Wallclock < b.Wallclock:
+		return -1
+	}
+	return compareEnvelopes(a, b)
+}
+
+// compareEnvelopes orders envelopes by sequence number, then publisher.
+func compareEnvelopes(a, b *pb.Envelope) int {
+	switch {
+	case a.Seq > b.Seq:
+		return 1
+	case a.Seq < b.Seq:
+		return -1
+	}
+	return bytes.Compare(a.Publisher, b.Publisher)
+}
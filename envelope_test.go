@@ -0,0 +1,399 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub-router/pb"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// tieValidator is a testValidator that can't tell valid records apart: it
+// selects whichever comes first.
+type tieValidator struct {
+	testValidator
+}
+
+func (tieValidator) Select(string, [][]byte) (int, error) {
+	return 0, nil
+}
+
+func sealed(t *testing.T, env *pb.Envelope) []byte {
+	t.Helper()
+	b, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{}, envelopeMagic...), b...)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithEnvelopes())
+	key := "/namespace/key"
+
+	for _, v := range []string{"valid for key 1", "valid for key 2"} {
+		if err := vs.PutValue(ctx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 2"))
+
+	raw, err := vs.records.GetBest(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := openRecord(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Seq != 2 || string(env.Publisher) != string(vs.host().ID()) || string(env.Payload) != "valid for key 2" || env.Wallclock == 0 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+	if !bytes.Equal(sealed(t, env), raw) {
+		t.Fatal("envelope didn't round-trip")
+	}
+
+	b, err := vs.ds.Get(ctx, seqKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := binary.BigEndian.Uint64(b); seq != 2 {
+		t.Fatalf("expected the sequence number 2 to be persisted, got %d", seq)
+	}
+
+	// plain records are their own payload
+	env, err = openRecord([]byte("valid for key 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Seq != 0 || env.Publisher != nil || string(env.Payload) != "valid for key 3" {
+		t.Fatalf("unexpected envelope of a plain record %v", env)
+	}
+	if _, err := openRecord(append(append([]byte{}, envelopeMagic...), 0xff)); err == nil {
+		t.Fatal("expected a corrupt envelope to fail")
+	}
+}
+
+func TestEnvelopeSelect(t *testing.T) {
+	key := "/namespace/key"
+	plain := []byte("valid for key")
+	first := sealed(t, &pb.Envelope{Seq: 1, Publisher: []byte("a"), Payload: []byte("valid for key")})
+	second := sealed(t, &pb.Envelope{Seq: 1, Publisher: []byte("b"), Payload: []byte("valid for key")})
+	latest := sealed(t, &pb.Envelope{Seq: 2, Publisher: []byte("a"), Payload: []byte("valid for key too")})
+
+	v := envelopeValidator{tieValidator{}}
+	for _, vals := range [][][]byte{
+		{plain, first, second, latest},
+		{latest, second, first, plain},
+		{second, latest, plain, first},
+	} {
+		i, err := v.Select(key, vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(vals[i], latest) {
+			t.Fatalf("expected the latest envelope to win, got %q", vals[i])
+		}
+	}
+
+	for _, vals := range [][][]byte{{plain, first, second}, {second, plain, first}} {
+		i, err := v.Select(key, vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(vals[i], second) {
+			t.Fatalf("expected the highest publisher to break the tie, got %q", vals[i])
+		}
+	}
+
+	// the validator comes first
+	v = envelopeValidator{testValidator{}}
+	older := []byte("valid for key, the best one")
+	i, err := v.Select(key, [][]byte{latest, older})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatal("expected the sequence number to only break ties")
+	}
+}
+
+func TestEnvelopeConvergence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, tieValidator{}, WithEnvelopes())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, vs := range vss {
+		if err := vs.PutValue(ctx, key, []byte("valid for key from "+string(rune('a'+i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		v0, err0 := vss[0].GetValue(ctx, key)
+		v1, err1 := vss[1].GetValue(ctx, key)
+		return err0 == nil && err1 == nil && bytes.Equal(v0, v1), nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		for i, vs := range vss {
+			val, _ := vs.GetValue(ctx, key)
+			t.Logf("[ValueStore %d] %q", i, val)
+		}
+		t.Fatal("stores didn't converge")
+	}
+
+	// a merger can't break ties the same way everywhere
+	if _, err := NewPubsubValueStore(ctx, hosts[0], nil, tieValidator{}, WithEnvelopes(), WithMerger(unionMerger{})); err == nil {
+		t.Fatal("expected envelopes to be refused with a merger")
+	}
+}
+
+func TestEnvelopeInterop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts []Option
+		if i == 0 {
+			opts = append(opts, WithEnvelopes())
+		}
+		if vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the plain store opens the envelopes of the other
+	val := []byte("valid for key")
+	if err := vss[0].PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		got, err := vss[1].GetValue(ctx, key, routing.Offline)
+		return err == nil && bytes.Equal(got, val), nil
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal("the plain store didn't accept the enveloped record")
+	}
+	if st := vss[1].Stats(); st.InvalidRecords != 0 || st.BlacklistRejected != 0 {
+		t.Fatalf("the enveloped record was rejected: %+v", st)
+	}
+
+	// and honors their expiry
+	expiring := []byte("valid for key, expiring")
+	if err := vss[0].PutValue(ctx, key, expiring, WithTTL(300*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		got, err := vss[1].GetValue(ctx, key, routing.Offline)
+		return err == nil && bytes.Equal(got, expiring), nil
+	}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal("the plain store didn't accept the record with a TTL")
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		_, err := vss[1].GetValue(ctx, key, routing.Offline)
+		return err == routing.ErrNotFound, nil
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal("the plain store kept the expired record")
+	}
+}
+
+// lastValidator is a testValidator that can't tell valid records apart: it
+// selects whichever comes last.
+type lastValidator struct {
+	testValidator
+}
+
+func (lastValidator) Select(_ string, vals [][]byte) (int, error) {
+	return len(vals) - 1, nil
+}
+
+func TestTieBreakConvergence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 3)
+	for i := 1; i < len(hosts); i++ {
+		if err := hosts[i].Connect(ctx, hosts[i-1].Peerstore().PeerInfo(hosts[i-1].ID())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []record.Validator{tieValidator{}, lastValidator{}} {
+		key := fmt.Sprintf("/namespace/key%T", v)
+		vss := make([]*PubsubValueStore, len(hosts))
+		for i, h := range hosts {
+			fs, err := pubsub.NewFloodSub(ctx, h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vss[i], err = NewPubsubValueStore(ctx, h, fs, v); err != nil {
+				t.Fatal(err)
+			}
+			defer vss[i].Close()
+		}
+
+		wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+		defer wcancel()
+		for _, vs := range vss {
+			if err := vs.Subscribe(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, vs := range vss {
+			if err := vs.SubscribeAndWait(wctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// published at once, so each node gets them in a different order
+		var wg sync.WaitGroup
+		for i, vs := range vss {
+			wg.Add(1)
+			go func(i int, vs *PubsubValueStore) {
+				defer wg.Done()
+				if err := vs.PutValue(ctx, key, []byte(fmt.Sprintf("valid for %s from %d", key[len("/namespace/"):], i))); err != nil {
+					t.Error(err)
+				}
+			}(i, vs)
+		}
+		wg.Wait()
+
+		want := []byte(fmt.Sprintf("valid for %s from %d", key[len("/namespace/"):], len(vss)-1))
+		err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+			for _, vs := range vss {
+				val, err := vs.GetValue(ctx, key, routing.Offline)
+				if err != nil || !bytes.Equal(val, want) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}, 100*time.Millisecond)
+		if err != nil {
+			for i, vs := range vss {
+				val, _ := vs.GetValue(ctx, key, routing.Offline)
+				t.Logf("[ValueStore %d] %q", i, val)
+			}
+			t.Fatalf("stores didn't converge to the largest record with %T", v)
+		}
+	}
+}
+
+func TestRecordTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := newTestStore(ctx, t).PutValue(ctx, "/namespace/key", []byte("valid for key"), WithTTL(time.Minute)); err != ErrTTLWithoutEnvelopes {
+		t.Fatalf("expected ErrTTLWithoutEnvelopes, got %v", err)
+	}
+
+	vs := newTestStore(ctx, t, WithEnvelopes(), WithRebroadcastInitialDelay(0), WithRebroadcastInterval(100*time.Millisecond))
+	key := "/namespace/key"
+	if err := vs.PutValue(ctx, key, []byte("valid for key 1"), WithTTL(500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 1"))
+	raw, err := vs.records.GetBest(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := openRecord(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Expires == 0 {
+		t.Fatal("expected the envelope to carry the expiry")
+	}
+
+	// peers may see the record late, they aren't held responsible for it
+	_, from := newSigner(t)
+	msg := newSignedMessage(t, nil, from, key, sealed(t, &pb.Envelope{Seq: 5, Expires: time.Now().UnixNano(), Payload: []byte("valid for key 2")}))
+	if res := vs.validate(ctx, from, msg); res != pubsub.ValidationIgnore {
+		t.Fatalf("expected the expired message to be ignored, got %v", res)
+	}
+
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		_, err := vs.records.GetBest(ctx, key)
+		return err == routing.ErrNotFound, nil
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected the expired record to be deleted")
+	}
+	checkNotFound(ctx, t, 0, vs, key)
+	if n := vs.Stats().RecordsExpired; n != 1 {
+		t.Fatalf("expected 1 expired record, got %d", n)
+	}
+
+	// a record without TTL stays
+	if err := vs.PutValue(ctx, key, []byte("valid for key 3")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 3"))
+}
@@ -0,0 +1,99 @@
+package namesys
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	record "github.com/libp2p/go-libp2p-record"
+
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// KeyExplanation describes every stage of the mapping from a record key to
+// the pubsub topic and datastore entry the store uses for it. It's meant for
+// debugging why two nodes don't meet on the same topic.
+type KeyExplanation struct {
+	// Key is the key as given.
+	Key string
+	// Namespace is the record namespace of the key, empty if the key isn't
+	// namespaced.
+	Namespace string
+	// DisplayKey is the human readable form of the key used in logs.
+	DisplayKey string
+	// Topic is the pubsub topic the key's records are published on.
+	Topic string
+	// DatastoreKey is the key the record is stored under locally by the
+	// default record store, within the datastore prefix, see
+	// WithDatastorePrefix.
+	DatastoreKey string
+	// MetadataKey is the datastore key of the record's metadata.
+	MetadataKey string
+	// SequenceKey is the datastore key of the last sequence number this node
+	// sealed a record of the key with, empty without WithEnvelopes.
+	SequenceKey string
+	// HistoryKey is the datastore key of the key's record history, empty
+	// without WithHistory.
+	HistoryKey string
+	// SubscriptionLifetime is how long the key's subscription is kept while
+	// unused, see WithUnusedSubscriptionTTL.
+	SubscriptionLifetime time.Duration
+	// Err is why the store refuses to operate on the key, e.g. an
+	// ErrUnsupportedNamespace, nil if it doesn't.
+	Err error
+}
+
+// ExplainKey returns the mapping of the key to its topic and datastore entries
+// with the store's configuration. It doesn't log anything, even for keys the
+// store refuses, which are explained along with the reason.
+func (p *PubsubValueStore) ExplainKey(key string) KeyExplanation {
+	ns, _, err := record.SplitKey(key)
+	if err != nil {
+		ns = ""
+	}
+	prefix := p.topicPrefix
+	if prefix == "" {
+		// zero store, not built by NewPubsubValueStore
+		prefix = DefaultTopicPrefix
+	}
+	display, _ := displayKey(key)
+	lifetime, _ := p.getTTLForKey(key)
+	ex := KeyExplanation{
+		Key:                  key,
+		Namespace:            ns,
+		DisplayKey:           display,
+		Topic:                keyToTopic(prefix, key),
+		DatastoreKey:         p.dsPrefix.Child(dshelp.NewKeyFromBinary([]byte(key))).String(),
+		MetadataKey:          p.dsPrefix.Child(metaKey(key)).String(),
+		SubscriptionLifetime: lifetime,
+		Err:                  p.checkKey(key),
+	}
+	if p.envelopes {
+		ex.SequenceKey = p.dsPrefix.Child(seqKey(key)).String()
+	}
+	if p.historySize > 0 {
+		ex.HistoryKey = p.dsPrefix.Child(historyKey(key)).String()
+	}
+	return ex
+}
+
+func (e KeyExplanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "key:           %q\n", e.Key)
+	fmt.Fprintf(&b, "namespace:     %q\n", e.Namespace)
+	fmt.Fprintf(&b, "display key:   %q\n", e.DisplayKey)
+	fmt.Fprintf(&b, "topic:         %s\n", e.Topic)
+	fmt.Fprintf(&b, "datastore key: %s\n", e.DatastoreKey)
+	fmt.Fprintf(&b, "metadata key:  %s\n", e.MetadataKey)
+	if e.SequenceKey != "" {
+		fmt.Fprintf(&b, "sequence key:  %s\n", e.SequenceKey)
+	}
+	if e.HistoryKey != "" {
+		fmt.Fprintf(&b, "history key:   %s\n", e.HistoryKey)
+	}
+	fmt.Fprintf(&b, "lifetime:      %s\n", e.SubscriptionLifetime)
+	if e.Err != nil {
+		fmt.Fprintf(&b, "error:         %s\n", e.Err)
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestExplainKey(t *testing.T) {
+	pid, err := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := &PubsubValueStore{}
+	for _, tc := range []struct {
+		key    string
+		golden string
+	}{
+		{"/namespace/key", `key:           "/namespace/key"
+namespace:     "namespace"
+display key:   "/namespace/key"
+topic:         /record/L25hbWVzcGFjZS9rZXk
+datastore key: /F5XGC3LFONYGCY3FF5VWK6I
+metadata key:  /meta/F5XGC3LFONYGCY3FF5VWK6I
+lifetime:      8760h0m0s
+`},
+		{"/ipns/" + string(pid), `key:           "/ipns/\x00$\b\x01\x12 /\xfa5\xa9\x9d:<\xfb\xb1{\xb7\xc1\xdcUa\xb1\x8a\x8d̤\xdf8\xdca>\xa8Y\xc3~\xb13k"
+namespace:     "ipns"
+display key:   "/ipns/12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA"
+topic:         /record/L2lwbnMvACQIARIgL_o1qZ06PPuxe7fB3FVhsYqNzKTfONxhPqhZw36xM2s
+datastore key: /F5UXA3TTF4ACICABCIQC76RVVGOTUPH3WF53PQO4KVQ3DCUNZSSN6OG4ME7KQWODP2YTG2Y
+metadata key:  /meta/F5UXA3TTF4ACICABCIQC76RVVGOTUPH3WF53PQO4KVQ3DCUNZSSN6OG4ME7KQWODP2YTG2Y
+lifetime:      8760h0m0s
+`},
+		{"no-namespace", `key:           "no-namespace"
+namespace:     ""
+display key:   "no-namespace"
+topic:         /record/bm8tbmFtZXNwYWNl
+datastore key: /NZXS23TBNVSXG4DBMNSQ
+metadata key:  /meta/NZXS23TBNVSXG4DBMNSQ
+lifetime:      8760h0m0s
+`},
+		{"/bin/a\x00b/c", `key:           "/bin/a\x00b/c"
+namespace:     "bin"
+display key:   "/bin/a\x00b/c"
+topic:         /record/L2Jpbi9hAGIvYw
+datastore key: /F5RGS3RPMEAGEL3D
+metadata key:  /meta/F5RGS3RPMEAGEL3D
+lifetime:      8760h0m0s
+`},
+	} {
+		if out := vs.ExplainKey(tc.key).String(); out != tc.golden {
+			t.Errorf("unexpected explanation for %q:\n%s\nexpected:\n%s", tc.key, out, tc.golden)
+		}
+	}
+}
+
+func TestExplainKeyOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		key    string
+		golden string
+	}{
+		{"defaults", nil, key, `key:           "/namespace/key"
+namespace:     "namespace"
+display key:   "/namespace/key"
+topic:         /record/L25hbWVzcGFjZS9rZXk
+datastore key: /pubsub-valuestore/F5XGC3LFONYGCY3FF5VWK6I
+metadata key:  /pubsub-valuestore/meta/F5XGC3LFONYGCY3FF5VWK6I
+lifetime:      8760h0m0s
+`},
+		{"prefixes, envelopes, history and TTL", []Option{
+			WithDatastorePrefix("/vs"), WithTopicPrefix("/private/"), WithEnvelopes(), WithHistory(4),
+			WithUnusedSubscriptionTTL(time.Minute, "namespace"),
+		}, key, `key:           "/namespace/key"
+namespace:     "namespace"
+display key:   "/namespace/key"
+topic:         /private/L25hbWVzcGFjZS9rZXk
+datastore key: /vs/F5XGC3LFONYGCY3FF5VWK6I
+metadata key:  /vs/meta/F5XGC3LFONYGCY3FF5VWK6I
+sequence key:  /vs/seq/F5XGC3LFONYGCY3FF5VWK6I
+history key:   /vs/history/F5XGC3LFONYGCY3FF5VWK6I
+lifetime:      1m0s
+`},
+		{"TTL of another namespace", []Option{WithUnusedSubscriptionTTL(time.Minute, "other")}, "no-namespace", `key:           "no-namespace"
+namespace:     ""
+display key:   "no-namespace"
+topic:         /record/bm8tbmFtZXNwYWNl
+datastore key: /pubsub-valuestore/NZXS23TBNVSXG4DBMNSQ
+metadata key:  /pubsub-valuestore/meta/NZXS23TBNVSXG4DBMNSQ
+lifetime:      8760h0m0s
+`},
+		{"unsupported namespace", []Option{WithNamespaces("ipns")}, key, `key:           "/namespace/key"
+namespace:     "namespace"
+display key:   "/namespace/key"
+topic:         /record/L25hbWVzcGFjZS9rZXk
+datastore key: /pubsub-valuestore/F5XGC3LFONYGCY3FF5VWK6I
+metadata key:  /pubsub-valuestore/meta/F5XGC3LFONYGCY3FF5VWK6I
+lifetime:      8760h0m0s
+error:         unsupported key namespace: "namespace"
+`},
+		{"key too large", []Option{WithMaxKeySize(8)}, key, `key:           "/namespace/key"
+namespace:     "namespace"
+display key:   "/namespace/key"
+topic:         /record/L25hbWVzcGFjZS9rZXk
+datastore key: /pubsub-valuestore/F5XGC3LFONYGCY3FF5VWK6I
+metadata key:  /pubsub-valuestore/meta/F5XGC3LFONYGCY3FF5VWK6I
+lifetime:      8760h0m0s
+error:         invalid key: 14 bytes, over the maximum of 8
+`},
+	} {
+		vs := newTestStore(ctx, t, tc.opts...)
+		if out := vs.ExplainKey(tc.key).String(); out != tc.golden {
+			t.Errorf("%s: unexpected explanation for %q:\n%s\nexpected:\n%s", tc.name, tc.key, out, tc.golden)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// Export returns the best known record of every key in the local record
+// store, which must implement RecordLister. Records that no longer validate,
+// e.g. expired ones, are left out.
+func (p *PubsubValueStore) Export(ctx context.Context) (map[string][]byte, error) {
+	keys, err := p.ListKeys(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := p.recordValidator()
+	out := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		val, err := p.getStored(ctx, key)
+		if err == routing.ErrNotFound {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validator.Validate(key, val); err != nil {
+			continue
+		}
+		out[key] = val
+	}
+	return out, nil
+}
+
+// ListKeys returns the keys of the records stored locally, sorted, whether
+// they are subscribed to or not. The record store must implement
+// RecordLister. Keys are listed from the first one after the given key, or
+// from the start if after is empty, and at most limit keys are returned
+// unless limit is zero: passing the last key of a page lists the next one.
+// The records aren't validated, so they may have expired.
+func (p *PubsubValueStore) ListKeys(ctx context.Context, after string, limit int) ([]string, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("invalid limit: %d", limit)
+	}
+	lister, ok := p.records.(RecordLister)
+	if !ok {
+		return nil, errors.New("record store can't list its records")
+	}
+	stored, err := lister.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(stored))
+	var keys []string
+	for _, k := range append(append(stored, p.unsaved.keys()...), p.pending.keys()...) {
+		if _, ok := seen[k]; ok || k <= after {
+			continue
+		}
+		seen[k] = struct{}{}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// PutLocal stores the record locally and notifies the key's watchers, without
+// publishing it or subscribing to the key; this is up to the rebroadcaster,
+// for subscribed keys. Injecting a record that isn't better than the current
+// one is a no-op.
+func (p *PubsubValueStore) PutLocal(ctx context.Context, key string, value []byte) error {
+	if err := p.checkKey(key); err != nil {
+		return err
+	}
+	if p.oversized(value) {
+		return ErrRecordTooLarge
+	}
+	if err := p.recordValidator().Validate(key, value); err != nil {
+		return &InvalidRecordError{Reason: err}
+	}
+
+	meta := RecordMeta{Received: time.Now()}
+	cmp, value, err := p.putLocalKey(ctx, key, value, meta)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		p.notifyWatchers(key, value, meta)
+	}
+	return nil
+}
+
+// Import stores the given records with PutLocal, e.g. a snapshot obtained with
+// Export on another node. Records that aren't better than the current ones
+// are skipped. Invalid records are reported in a PutValuesError, without
+// preventing the others from being imported.
+func (p *PubsubValueStore) Import(ctx context.Context, records map[string][]byte) error {
+	errs := PutValuesError{}
+	for key, value := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.PutLocal(ctx, key, value); err != nil {
+			errs[key] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// putLocalKey is putLocal for keys that may not be subscribed to. It also
+// returns the stored record, which differs from value if it was merged.
+func (p *PubsubValueStore) putLocalKey(ctx context.Context, key string, value []byte, meta RecordMeta) (int, []byte, error) {
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if ok {
+		ti.dbWriteMx.Lock()
+		defer ti.dbWriteMx.Unlock()
+	}
+	value, err := p.mergeLocal(ctx, key, value)
+	if err != nil {
+		return -1, nil, err
+	}
+	cmp, err := p.putLocal(ctx, ti, key, value, meta, nil)
+	return cmp, value, err
+}
@@ -19,15 +19,21 @@ import (
 
 const FetchProtoID = protocol.ID("/libp2p/fetch/0.0.1")
 
+// DefaultFetchTimeout is the default time allowed to open a fetch stream to a
+// peer.
+const DefaultFetchTimeout = 10 * time.Second
+
 type fetchProtocol struct {
-	ctx  context.Context
-	host host.Host
+	ctx     context.Context
+	host    host.Host
+	timeout time.Duration
+	log     Logger
 }
 
 type getValue func(ctx context.Context, key string) ([]byte, error)
 
 func newFetchProtocol(ctx context.Context, host host.Host, getData getValue) *fetchProtocol {
-	p := &fetchProtocol{ctx, host}
+	p := &fetchProtocol{ctx, host, DefaultFetchTimeout, log}
 
 	host.SetStreamHandler(FetchProtoID, func(s network.Stream) {
 		p.receive(s, getData)
@@ -41,7 +47,7 @@ func (p *fetchProtocol) receive(s network.Stream, getData getValue) {
 
 	msg := &pb.FetchRequest{}
 	if err := readMsg(p.ctx, s, msg); err != nil {
-		log.Infof("error reading request from %s: %s", s.Conn().RemotePeer(), err)
+		p.log.Infof("error reading request from %s: %s", s.Conn().RemotePeer(), err)
 		s.Reset()
 		return
 	}
@@ -56,13 +62,14 @@ func (p *fetchProtocol) receive(s network.Stream, getData getValue) {
 	}
 
 	if err := writeMsg(p.ctx, s, &respProto); err != nil {
+		p.log.Infof("error writing response to %s: %s", s.Conn().RemotePeer(), err)
 		s.Reset()
 		return
 	}
 }
 
 func (p *fetchProtocol) Fetch(ctx context.Context, pid peer.ID, key string) ([]byte, error) {
-	peerCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	peerCtx, cancel := context.WithTimeout(ctx, p.timeout)
 	defer cancel()
 
 	s, err := p.host.NewStream(peerCtx, pid, FetchProtoID)
@@ -118,10 +125,6 @@ func writeMsg(ctx context.Context, s network.Stream, msg proto.Message) error {
 	case <-ctx.Done():
 		retErr = ctx.Err()
 	}
-
-	if retErr != nil {
-		log.Infof("error writing response to %s: %s", s.Conn().RemotePeer(), retErr)
-	}
 	return retErr
 }
 
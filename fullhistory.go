@@ -0,0 +1,191 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// MetricHistoryDropped counts the records dropped from the buffer of
+// full-history searches, see OverflowDropOldest.
+const MetricHistoryDropped = "history_dropped"
+
+// Overflow is what a full-history search does with a new record when its
+// buffer is full, see WithFullHistory.
+type Overflow int
+
+const (
+	// OverflowBlock waits for the consumer to make room, queueing the records
+	// beyond the buffer for that search only, as long as it lags: the updates
+	// of the key and its other searches go on meanwhile.
+	OverflowBlock Overflow = iota
+	// OverflowDropOldest drops the oldest buffered record to make room,
+	// counting it in Stats.HistoryDropped.
+	OverflowDropOldest
+)
+
+type fullHistoryKey struct{}
+
+type fullHistory struct {
+	buffer   int
+	overflow Overflow
+}
+
+// WithFullHistory is a SearchValue option that delivers every accepted record
+// of the key in order, starting with the stored one, until ctx ends or the
+// store is closed, instead of the latest record only. Up to buffer records are
+// queued while the consumer is busy; the overflow policy decides what happens
+// beyond that. Other searches of the same key are unaffected.
+func WithFullHistory(buffer int, overflow Overflow) routing.Option {
+	return func(opts *routing.Options) error {
+		if buffer <= 0 {
+			return fmt.Errorf("invalid history buffer: %d", buffer)
+		}
+		if overflow != OverflowBlock && overflow != OverflowDropOldest {
+			return fmt.Errorf("invalid overflow policy: %d", overflow)
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[fullHistoryKey{}] = fullHistory{buffer: buffer, overflow: overflow}
+		return nil
+	}
+}
+
+// historyListener is the listener of a full-history search.
+type historyListener struct {
+	out      chan []byte
+	overflow Overflow
+	// last record queued, guarded by the store's watchLk
+	last []byte
+
+	// the records waiting for room in out with OverflowBlock, moved there by
+	// forward
+	mx    sync.Mutex
+	queue [][]byte
+	wake  chan struct{}
+	// closed when the search ends, stopping forward
+	done chan struct{}
+}
+
+// send queues the value, without blocking. Must be called with the store's
+// watchLk held, which orders the sends.
+func (p *PubsubValueStore) send(l *historyListener, value []byte) {
+	if l.overflow == OverflowBlock {
+		l.mx.Lock()
+		l.queue = append(l.queue, value)
+		l.mx.Unlock()
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	select {
+	case l.out <- value:
+		return
+	default:
+	}
+	select {
+	case <-l.out:
+		p.count(&p.stats.historyDropped, MetricHistoryDropped)
+	default:
+	}
+	select {
+	case l.out <- value:
+	default:
+	}
+}
+
+// forward moves the queued records to out, in order, waiting for the consumer
+// to make room, until the search ends.
+func (l *historyListener) forward() {
+	for {
+		l.mx.Lock()
+		if len(l.queue) == 0 {
+			l.mx.Unlock()
+			select {
+			case <-l.wake:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		value := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.mx.Unlock()
+
+		select {
+		case l.out <- value:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (p *PubsubValueStore) searchHistory(ctx context.Context, key string, h fullHistory) (*Search, error) {
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	s := &Search{
+		out: make(chan []byte, h.buffer),
+		stop: func() {
+			stopOnce.Do(func() { close(stop) })
+		},
+		done: make(chan struct{}),
+	}
+	l := &historyListener{
+		out:      s.out,
+		overflow: h.overflow,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	forwarded := make(chan struct{})
+	if h.overflow == OverflowBlock {
+		go func() {
+			defer close(forwarded)
+			l.forward()
+		}()
+	} else {
+		close(forwarded)
+	}
+
+	p.watchLk.Lock()
+	if lv, err := p.getLocal(ctx, key); err == nil {
+		s.out <- p.open(lv)
+		l.last = lv
+	}
+	wg := p.watchGroupLocked(key)
+	wg.history[l] = struct{}{}
+	p.watchLk.Unlock()
+
+	go func() {
+		defer close(s.done)
+
+		select {
+		case <-ctx.Done():
+			s.err = ctx.Err()
+		case <-p.ctx.Done():
+			s.err = ErrClosed
+		case <-wg.ended:
+			s.err = ErrSubscriptionEnded
+		case <-stop:
+			s.err = ErrSearchCanceled
+		}
+
+		// stop the senders before closing out
+		close(l.done)
+		<-forwarded
+		p.watchLk.Lock()
+		delete(wg.history, l)
+		p.dropWatchGroupLocked(key, wg)
+		p.watchLk.Unlock()
+
+		close(s.out)
+	}()
+
+	return s, nil
+}
@@ -0,0 +1,72 @@
+package namesys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+var historyPrefix = ds.NewKey("/history")
+
+// historyKey returns the datastore key of the key's record history.
+func historyKey(key string) ds.Key {
+	return historyPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+// WithHistory returns an option that keeps the last n accepted records of
+// every key in the datastore, see GetHistory. Zero disables the history.
+func WithHistory(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n < 0 {
+			return fmt.Errorf("invalid history size: %d", n)
+		}
+		store.historySize = n
+		return nil
+	}
+}
+
+// GetHistory returns the last accepted records of the key, newest first. The
+// current record is the first one. It returns nothing unless the store was
+// configured with WithHistory.
+func (p *PubsubValueStore) GetHistory(ctx context.Context, key string) ([][]byte, error) {
+	b, err := p.ds.Get(ctx, historyKey(key))
+	if err != nil {
+		if err == ds.ErrNotFound {
+			err = nil
+		}
+		return nil, err
+	}
+	var hist [][]byte
+	if err := json.Unmarshal(b, &hist); err != nil {
+		return nil, err
+	}
+	return hist, nil
+}
+
+// appendHistory adds the value to the key's history, dropping the oldest
+// records beyond the configured size in the same write.
+// Requires that the ti.dbWriteMx is held when called.
+func (p *PubsubValueStore) appendHistory(ctx context.Context, key string, value []byte) error {
+	if p.historySize == 0 {
+		return nil
+	}
+
+	hist, err := p.GetHistory(ctx, key)
+	if err != nil {
+		p.log.Debugf("resetting corrupt history for %s: %s", logKey(key), err)
+		hist = nil
+	}
+	hist = append([][]byte{value}, hist...)
+	if len(hist) > p.historySize {
+		hist = hist[:p.historySize]
+	}
+
+	b, err := json.Marshal(hist)
+	if err != nil {
+		return err
+	}
+	return p.ds.Put(ctx, historyKey(key), b)
+}
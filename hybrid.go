@@ -0,0 +1,180 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// HybridValueStore is a routing.ValueStore that combines a PubsubValueStore
+// with a second, slower value store such as the DHT: records are written to
+// both and read from whichever has the best one. Records are compared with
+// the validator of the PubsubValueStore. Routing options the PubsubValueStore
+// doesn't support, e.g. a DHT quorum, are only passed to the second store.
+type HybridValueStore struct {
+	ps  *PubsubValueStore
+	dht routing.ValueStore
+}
+
+var _ routing.ValueStore = (*HybridValueStore)(nil)
+
+// NewHybridValueStore returns a value store backed by both ps and dht.
+func NewHybridValueStore(ps *PubsubValueStore, dht routing.ValueStore) *HybridValueStore {
+	return &HybridValueStore{ps: ps, dht: dht}
+}
+
+// HybridError is returned when at least one of the backends of a
+// HybridValueStore failed, or when PutValue failed on either pubsub or the
+// secondary store set with WithSecondaryStore, reported as DHT. A nil field
+// means that backend succeeded.
+type HybridError struct {
+	Pubsub error
+	DHT    error
+}
+
+func (e *HybridError) Error() string {
+	switch {
+	case e.Pubsub != nil && e.DHT != nil:
+		return fmt.Sprintf("pubsub: %s; dht: %s", e.Pubsub, e.DHT)
+	case e.Pubsub != nil:
+		return fmt.Sprintf("pubsub: %s", e.Pubsub)
+	default:
+		return fmt.Sprintf("dht: %s", e.DHT)
+	}
+}
+
+func (e *HybridError) notFound() bool {
+	return e.Pubsub == routing.ErrNotFound && e.DHT == routing.ErrNotFound
+}
+
+// PutValue writes the record to both backends concurrently. The record is
+// published as long as one of them succeeds, but failures of either are
+// reported in a HybridError.
+func (h *HybridValueStore) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	dhtErr := make(chan error, 1)
+	go func() {
+		dhtErr <- h.dht.PutValue(ctx, key, value, opts...)
+	}()
+
+	herr := &HybridError{Pubsub: h.ps.PutValue(ctx, key, value, ownOptions(opts)...)}
+	herr.DHT = <-dhtErr
+	if herr.Pubsub == nil && herr.DHT == nil {
+		return nil
+	}
+	return herr
+}
+
+type hybridResult struct {
+	val []byte
+	err error
+}
+
+// GetValue queries both backends concurrently and returns the best of the
+// records found. The DHT's record is validated first, and dropped if invalid,
+// reported as an InvalidRecordError. It only fails with routing.ErrNotFound if
+// neither backend has a record; other errors are ignored as long as one of
+// them has one.
+func (h *HybridValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	dhtRes := make(chan hybridResult, 1)
+	go func() {
+		val, err := h.dht.GetValue(ctx, key, opts...)
+		dhtRes <- hybridResult{val, err}
+	}()
+
+	psVal, psErr := h.ps.GetValue(ctx, key, ownOptions(opts)...)
+	res := <-dhtRes
+	if res.err == nil {
+		if err := h.validate(key, res.val); err != nil {
+			res = hybridResult{err: err}
+		}
+	}
+
+	switch {
+	case psErr == nil && res.err == nil:
+		if h.ps.strictlyBetter(key, res.val, psVal) {
+			return res.val, nil
+		}
+		return psVal, nil
+	case psErr == nil:
+		return psVal, nil
+	case res.err == nil:
+		return res.val, nil
+	}
+
+	herr := &HybridError{Pubsub: psErr, DHT: res.err}
+	if herr.notFound() {
+		return nil, routing.ErrNotFound
+	}
+	return nil, herr
+}
+
+// SearchValue searches both backends and merges the results, sending every
+// value that is better than all the values sent before it, and dropping the
+// DHT's invalid ones. The channel is closed once both searches are done. If
+// one of the searches fails to start, the other one's results are returned
+// along with a HybridError reporting the failure.
+func (h *HybridValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	psCh, psErr := h.ps.SearchValue(ctx, key, ownOptions(opts)...)
+	dhtCh, dhtErr := h.dht.SearchValue(ctx, key, opts...)
+	if psErr != nil && dhtErr != nil {
+		cancel()
+		return nil, &HybridError{Pubsub: psErr, DHT: dhtErr}
+	}
+
+	var herr error
+	if psErr != nil || dhtErr != nil {
+		herr = &HybridError{Pubsub: psErr, DHT: dhtErr}
+	}
+
+	out := make(chan []byte, 1)
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		var best []byte
+		for psCh != nil || dhtCh != nil {
+			var val []byte
+			var ok bool
+			select {
+			case val, ok = <-psCh:
+				if !ok {
+					psCh = nil
+					continue
+				}
+			case val, ok = <-dhtCh:
+				if !ok {
+					dhtCh = nil
+					continue
+				}
+				if h.validate(key, val) != nil {
+					continue
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			if !h.ps.strictlyBetter(key, val, best) {
+				continue
+			}
+			best = val
+
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, herr
+}
+
+// validate validates a record of the DHT with the PubsubValueStore's validator.
+func (h *HybridValueStore) validate(key string, val []byte) error {
+	if err := h.ps.recordValidator().Validate(key, val); err != nil {
+		return &InvalidRecordError{Reason: err}
+	}
+	return nil
+}
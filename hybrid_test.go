@@ -0,0 +1,180 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// mapValueStore is a routing.ValueStore standing in for the DHT.
+type mapValueStore struct {
+	mx        sync.Mutex
+	vals      map[string][]byte
+	putErr    error
+	searchErr error
+}
+
+func (m *mapValueStore) PutValue(_ context.Context, key string, val []byte, _ ...routing.Option) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.putErr != nil {
+		return m.putErr
+	}
+	m.vals[key] = val
+	return nil
+}
+
+func (m *mapValueStore) GetValue(_ context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	val, ok := m.vals[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return val, nil
+}
+
+func (m *mapValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	if m.searchErr != nil {
+		return nil, m.searchErr
+	}
+	out := make(chan []byte, 1)
+	if val, err := m.GetValue(ctx, key, opts...); err == nil {
+		out <- val
+	}
+	close(out)
+	return out, nil
+}
+
+func TestHybridValueStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps := newTestStore(ctx, t)
+	dht := &mapValueStore{vals: map[string][]byte{}}
+	h := NewHybridValueStore(ps, dht)
+
+	if _, err := h.GetValue(ctx, "/namespace/key"); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// only in the DHT
+	dht.vals["/namespace/key"] = []byte("valid for key")
+	checkValue(ctx, t, 0, h, "/namespace/key", []byte("valid for key"))
+
+	// better in pubsub
+	better := []byte("valid for key 2")
+	if err := ps.PutValue(ctx, "/namespace/key", better); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, h, "/namespace/key", better)
+
+	ch, err := h.SearchValue(ctx, "/namespace/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vals [][]byte
+	for v := range ch {
+		vals = append(vals, v)
+	}
+	if len(vals) == 0 || !bytes.Equal(vals[len(vals)-1], better) {
+		t.Fatalf("expected search to end with the best value, got %q", vals)
+	}
+
+	// both written
+	best := []byte("valid for key 3")
+	if err := h.PutValue(ctx, "/namespace/key", best); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, ps, "/namespace/key", best)
+	checkValue(ctx, t, 0, dht, "/namespace/key", best)
+
+	// a DHT failure doesn't prevent the pubsub put
+	dht.putErr = errors.New("dht down")
+	best = []byte("valid for key 4")
+	err = h.PutValue(ctx, "/namespace/key", best)
+	var herr *HybridError
+	if !errors.As(err, &herr) || herr.Pubsub != nil || herr.DHT != dht.putErr {
+		t.Fatalf("expected a dht HybridError, got %v", err)
+	}
+	checkValue(ctx, t, 0, h, "/namespace/key", best)
+
+	// options of the DHT only are kept from pubsub
+	dht.putErr = nil
+	if _, err := h.GetValue(ctx, "/namespace/key", quorum(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.PutValue(ctx, "/namespace/key", []byte("valid for key 5"), quorum(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid DHT record doesn't beat a valid pubsub one
+	dht.vals["/namespace/key"] = []byte("valid for key 9 invalid")
+	checkValue(ctx, t, 0, h, "/namespace/key", []byte("valid for key 5"))
+	ch, err = h.SearchValue(ctx, "/namespace/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := range ch {
+		if bytes.Contains(v, []byte("invalid")) {
+			t.Fatalf("unexpected invalid value %q", v)
+		}
+	}
+	dht.vals["/namespace/other"] = []byte("valid for other invalid")
+	_, err = h.GetValue(ctx, "/namespace/other", routing.Offline)
+	if !errors.As(err, &herr) || !errors.Is(herr.DHT, ErrInvalidRecord) {
+		t.Fatalf("expected the DHT's invalid record to be reported, got %v", err)
+	}
+
+	// a search failing on one backend is reported along with the other's
+	dht.searchErr = errors.New("dht down")
+	ch, err = h.SearchValue(ctx, "/namespace/key")
+	if !errors.As(err, &herr) || herr.Pubsub != nil || herr.DHT != dht.searchErr {
+		t.Fatalf("expected a dht HybridError, got %v", err)
+	}
+	var last []byte
+	for v := range ch {
+		last = v
+	}
+	if string(last) != "valid for key 5" {
+		t.Fatalf("expected the pubsub search's results, got %q", last)
+	}
+}
+
+func TestSecondaryStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dht := &mapValueStore{vals: map[string][]byte{}}
+	ps := newTestStore(ctx, t, WithSecondaryStore(dht))
+	key := "/namespace/key"
+
+	val := []byte("valid for key 1")
+	if err := ps.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, ps, key, val)
+	checkValue(ctx, t, 0, dht, key, val)
+
+	// opted out
+	val = []byte("valid for key 2")
+	if err := ps.PutValue(ctx, key, val, SkipSecondary); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, ps, key, val)
+	checkValue(ctx, t, 0, dht, key, []byte("valid for key 1"))
+
+	// a secondary failure doesn't prevent the pubsub put
+	dht.putErr = errors.New("dht down")
+	val = []byte("valid for key 3")
+	err := ps.PutValue(ctx, key, val)
+	var herr *HybridError
+	if !errors.As(err, &herr) || herr.Pubsub != nil || herr.DHT != dht.putErr {
+		t.Fatalf("expected a dht HybridError, got %v", err)
+	}
+	checkValue(ctx, t, 0, ps, key, val)
+}
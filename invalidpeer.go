@@ -0,0 +1,242 @@
+package namesys
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// MetricInvalidRecords counts the messages rejected for carrying an
+	// invalid record or signature.
+	MetricInvalidRecords = "invalid_records"
+
+	// invalidPeerInterval is the minimum interval between two calls of the
+	// InvalidPeerFunc for the same peer.
+	invalidPeerInterval = time.Second
+	// maxInvalidPeers is the maximum number of peers whose invalid messages
+	// are counted; the peer that sent one least recently is forgotten to
+	// make room for another.
+	maxInvalidPeers = 1024
+
+	// MetricBlacklisted counts the messages rejected because the peer they
+	// were received from is blacklisted.
+	MetricBlacklisted = "blacklist_rejected"
+
+	// maxBlacklisted is the maximum number of peers blacklisted at once;
+	// peers going over the threshold beyond that aren't blacklisted.
+	maxBlacklisted = 1024
+)
+
+// InvalidPeerFunc is called when a peer sends us a message that fails
+// validation, with the error it failed with.
+type InvalidPeerFunc func(key string, from peer.ID, err error)
+
+// WithInvalidPeerHandler returns an option that calls fn when a peer forwards
+// a message with an invalid record or signature, e.g. to lower its score or
+// tag it in the connection manager. from is the peer the message was received
+// from, not necessarily its author.
+//
+// fn is called on its own goroutine, at most once per second for each peer;
+// the invalid messages in between are only counted, see InvalidRecords.
+func WithInvalidPeerHandler(fn InvalidPeerFunc) Option {
+	return func(store *PubsubValueStore) error {
+		if fn == nil {
+			return errors.New("invalid peer handler: nil")
+		}
+		store.onInvalidPeer = fn
+		return nil
+	}
+}
+
+// WithBlacklist returns an option that blacklists the peers forwarding
+// threshold messages with an invalid record or signature within window: their
+// messages are rejected without being validated for cooldown, after which they
+// get a clean slate. Disabled by default.
+//
+// At most 1024 peers are blacklisted at once. See BlacklistedPeers and
+// ClearBlacklist.
+func WithBlacklist(threshold int, window, cooldown time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if threshold <= 0 || window <= 0 || cooldown <= 0 {
+			return fmt.Errorf("invalid blacklist policy: %d in %s, cooldown %s", threshold, window, cooldown)
+		}
+		store.invalid.threshold = threshold
+		store.invalid.window = window
+		store.invalid.cooldown = cooldown
+		return nil
+	}
+}
+
+type invalidPeer struct {
+	count      uint64
+	lastCalled time.Time
+
+	// invalid messages since windowStart, see WithBlacklist
+	windowStart time.Time
+	windowCount int
+
+	// element of the invalidPeers' lru
+	elem *list.Element
+}
+
+// invalidPeers counts the invalid messages each peer sent, and blacklists
+// the peers sending too many.
+type invalidPeers struct {
+	// blacklist policy, threshold is 0 if disabled
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mx    sync.Mutex
+	peers map[peer.ID]*invalidPeer
+	// the peers, most recently sending an invalid message first
+	lru *list.List
+	// end of the cooldown of the blacklisted peers
+	bans map[peer.ID]time.Time
+}
+
+// record counts an invalid message from the peer, returning whether its
+// handler should be called.
+func (ip *invalidPeers) record(from peer.ID, now time.Time) bool {
+	ip.mx.Lock()
+	defer ip.mx.Unlock()
+
+	if ip.peers == nil {
+		ip.peers = make(map[peer.ID]*invalidPeer)
+		ip.lru = list.New()
+	}
+	c, ok := ip.peers[from]
+	if ok {
+		ip.lru.MoveToFront(c.elem)
+	} else {
+		if len(ip.peers) >= maxInvalidPeers {
+			oldest := ip.lru.Back()
+			ip.lru.Remove(oldest)
+			delete(ip.peers, oldest.Value.(peer.ID))
+		}
+		c = &invalidPeer{elem: ip.lru.PushFront(from)}
+		ip.peers[from] = c
+	}
+	c.count++
+	if ip.threshold > 0 {
+		if now.Sub(c.windowStart) >= ip.window {
+			c.windowStart = now
+			c.windowCount = 0
+		}
+		c.windowCount++
+		if c.windowCount >= ip.threshold {
+			ip.ban(from, now)
+			c.windowCount = 0
+		}
+	}
+	if now.Sub(c.lastCalled) < invalidPeerInterval {
+		return false
+	}
+	c.lastCalled = now
+	return true
+}
+
+// ban blacklists the peer until the end of the cooldown, if there is room.
+// Must be called with ip.mx held.
+func (ip *invalidPeers) ban(from peer.ID, now time.Time) {
+	if ip.bans == nil {
+		ip.bans = make(map[peer.ID]time.Time)
+	}
+	if _, ok := ip.bans[from]; !ok && len(ip.bans) >= maxBlacklisted {
+		for pid, until := range ip.bans {
+			if !now.Before(until) {
+				delete(ip.bans, pid)
+			}
+		}
+		if len(ip.bans) >= maxBlacklisted {
+			return
+		}
+	}
+	ip.bans[from] = now.Add(ip.cooldown)
+}
+
+// banned reports whether the peer is blacklisted.
+func (ip *invalidPeers) banned(from peer.ID, now time.Time) bool {
+	ip.mx.Lock()
+	defer ip.mx.Unlock()
+	until, ok := ip.bans[from]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(ip.bans, from)
+		return false
+	}
+	return true
+}
+
+// BlacklistedPeers returns the peers currently blacklisted, see WithBlacklist.
+func (p *PubsubValueStore) BlacklistedPeers() []peer.ID {
+	now := time.Now()
+	p.invalid.mx.Lock()
+	defer p.invalid.mx.Unlock()
+	var peers []peer.ID
+	for pid, until := range p.invalid.bans {
+		if now.Before(until) {
+			peers = append(peers, pid)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return peers
+}
+
+// ClearBlacklist lifts the blacklisting of all peers, and resets their
+// windows.
+func (p *PubsubValueStore) ClearBlacklist() {
+	p.invalid.mx.Lock()
+	defer p.invalid.mx.Unlock()
+	p.invalid.bans = nil
+	for _, c := range p.invalid.peers {
+		c.windowCount = 0
+	}
+}
+
+// blacklisted reports whether the peer the message was received from is
+// blacklisted, counting the rejection if so.
+func (p *PubsubValueStore) blacklisted(from peer.ID) bool {
+	if p.invalid.threshold == 0 || !p.invalid.banned(from, time.Now()) {
+		return false
+	}
+	p.count(&p.stats.blacklistRejected, MetricBlacklisted)
+	return true
+}
+
+// InvalidRecords returns the number of messages with an invalid record or
+// signature received from each peer. Only the 1024 peers that sent one most
+// recently are tracked.
+func (p *PubsubValueStore) InvalidRecords() map[peer.ID]uint64 {
+	p.invalid.mx.Lock()
+	defer p.invalid.mx.Unlock()
+	counts := make(map[peer.ID]uint64, len(p.invalid.peers))
+	for from, c := range p.invalid.peers {
+		counts[from] = c.count
+	}
+	return counts
+}
+
+// invalidMessage counts the invalid message received from the peer, and
+// calls the InvalidPeerFunc if due.
+func (p *PubsubValueStore) invalidMessage(key string, from peer.ID, err error) {
+	// local publishes get the error back
+	if from == p.host().ID() {
+		return
+	}
+	p.count(&p.stats.invalidRecords, MetricInvalidRecords)
+	p.notifyKeyError(StoreError{Key: key, Op: OpValidate, Err: err})
+	p.warnf(key, logInvalid, "PubsubValidate: rejecting message for %s from %s: %s", logKey(key), from, err)
+	if !p.invalid.record(from, time.Now()) || p.onInvalidPeer == nil {
+		return
+	}
+	go p.onInvalidPeer(key, from, err)
+}
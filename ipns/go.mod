@@ -0,0 +1,18 @@
+module github.com/libp2p/go-libp2p-pubsub-router/ipns
+
+go 1.16
+
+require (
+	github.com/gogo/protobuf v1.3.2
+	github.com/ipfs/go-ipns v0.1.2
+	github.com/libp2p/go-libp2p-blankhost v0.2.0
+	github.com/libp2p/go-libp2p-core v0.11.0
+	github.com/libp2p/go-libp2p-pubsub v0.6.0
+	github.com/libp2p/go-libp2p-pubsub-router v0.6.0
+	github.com/libp2p/go-libp2p-swarm v0.8.0
+)
+
+// v0.6.0 is the first release with the store APIs this module uses. The
+// replace only builds both modules from this repository's tree, and doesn't
+// apply to the modules requiring this one.
+replace github.com/libp2p/go-libp2p-pubsub-router => ../
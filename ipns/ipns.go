@@ -0,0 +1,125 @@
+// Package ipns publishes and resolves IPNS records through a PubsubValueStore.
+// It lives in its own module, so that the store doesn't depend on go-ipns.
+package ipns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	goipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+)
+
+// DefaultRecordLifetime is how long the records published by Publish are
+// valid for.
+const DefaultRecordLifetime = 24 * time.Hour
+
+// DefaultRecordTTL is how long resolvers may cache the records published by
+// Publish before looking for a newer one.
+const DefaultRecordTTL = time.Hour
+
+// NewIpnsPubsub returns a PubsubValueStore for IPNS records. The public keys
+// not embedded in the records or their names are looked up in the host's
+// peerstore.
+func NewIpnsPubsub(ctx context.Context, host host.Host, ps namesys.Pubsub, opts ...namesys.Option) (*namesys.PubsubValueStore, error) {
+	return namesys.NewPubsubValueStore(ctx, host, ps, goipns.Validator{KeyBook: host.Peerstore()}, opts...)
+}
+
+// Publisher publishes IPNS records to a value store.
+type Publisher struct {
+	vs routing.ValueStore
+}
+
+// NewPublisher returns a Publisher putting the records in vs, usually a store
+// returned by NewIpnsPubsub.
+func NewPublisher(vs routing.ValueStore) *Publisher {
+	return &Publisher{vs: vs}
+}
+
+// Publish publishes value, usually an IPFS path, under the name of sk for
+// DefaultRecordLifetime.
+func (p *Publisher) Publish(ctx context.Context, sk crypto.PrivKey, value []byte) error {
+	return p.PublishWithEOL(ctx, sk, value, time.Now().Add(DefaultRecordLifetime))
+}
+
+// PublishWithEOL is like Publish, with a record valid until eol. The record's
+// sequence number follows the one of the current record, if any.
+func (p *Publisher) PublishWithEOL(ctx context.Context, sk crypto.PrivKey, value []byte, eol time.Time) error {
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	key := goipns.RecordKey(pid)
+
+	var seq uint64
+	cur, err := p.vs.GetValue(ctx, key, routing.Offline)
+	switch err {
+	case nil:
+		if entry, err := unmarshalEntry(cur); err == nil {
+			seq = entry.GetSequence() + 1
+		}
+	case routing.ErrNotFound:
+	default:
+		return err
+	}
+
+	entry, err := goipns.Create(sk, value, seq, eol, DefaultRecordTTL)
+	if err != nil {
+		return err
+	}
+	// a no-op for the keys inlined in their peer ID
+	if err := goipns.EmbedPublicKey(sk.GetPublic(), entry); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return p.vs.PutValue(ctx, key, data)
+}
+
+// Resolver resolves IPNS names from a value store.
+type Resolver struct {
+	vs routing.ValueStore
+}
+
+// NewResolver returns a Resolver getting the records from vs, usually a store
+// returned by NewIpnsPubsub.
+func NewResolver(vs routing.ValueStore) *Resolver {
+	return &Resolver{vs: vs}
+}
+
+// Resolve returns the value of the name's record. The name is a peer ID,
+// optionally prefixed with /ipns/.
+func (r *Resolver) Resolve(ctx context.Context, name string) ([]byte, error) {
+	pid, err := peer.Decode(strings.TrimPrefix(name, "/ipns/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPNS name %q: %w", name, err)
+	}
+	data, err := r.vs.GetValue(ctx, goipns.RecordKey(pid))
+	if err != nil {
+		return nil, err
+	}
+	entry, err := unmarshalEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	return entry.GetValue(), nil
+}
+
+func unmarshalEntry(data []byte) (*ipnspb.IpnsEntry, error) {
+	entry := new(ipnspb.IpnsEntry)
+	if err := proto.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
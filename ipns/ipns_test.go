@@ -0,0 +1,118 @@
+package ipns
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+)
+
+func newNetHost(t *testing.T) host.Host {
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t))
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil {
+			panic(err)
+		}
+	})
+	return h
+}
+
+func TestPublishResolve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := []host.Host{newNetHost(t), newNetHost(t)}
+	vss := make([]*namesys.PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewIpnsPubsub(ctx, h, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "/ipns/" + pid.String()
+
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	key := "/ipns/" + string(pid)
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pub := NewPublisher(vss[0])
+	res := NewResolver(vss[1])
+	for _, path := range []string{"/ipfs/first", "/ipfs/second"} {
+		if err := pub.Publish(ctx, sk, []byte(path)); err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			val, err := res.Resolve(wctx, name)
+			if err == nil && string(val) == path {
+				break
+			}
+			select {
+			case <-wctx.Done():
+				t.Fatalf("didn't resolve %s to %s: %q, %v", name, path, val, err)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	data, err := vss[1].GetValue(ctx, key, routing.Offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := unmarshalEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Duration(entry.GetTtl()); ttl != DefaultRecordTTL {
+		t.Fatalf("expected the record's TTL to be %s, got %s", DefaultRecordTTL, ttl)
+	}
+
+	// expired records are refused
+	if err := pub.PublishWithEOL(ctx, sk, []byte("/ipfs/expired"), time.Now().Add(-time.Minute)); !errors.Is(err, namesys.ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord, got %v", err)
+	}
+	if val, err := NewResolver(vss[0]).Resolve(ctx, name); err != nil || string(val) != "/ipfs/second" {
+		t.Fatalf("expected the expired record to be dropped, got %q, %v", val, err)
+	}
+	if _, err := res.Resolve(ctx, "not a name"); err == nil {
+		t.Fatal("expected an invalid name to fail")
+	}
+}
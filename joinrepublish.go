@@ -0,0 +1,61 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// MetricJoinRepublishes counts the records republished because a peer
+// joined their topic.
+const MetricJoinRepublishes = "join_republishes"
+
+// WithJoinRepublish returns an option that republishes the stored record of
+// a key right away when a peer joins its topic, so that late joiners don't
+// wait for the next rebroadcast. A key is republished at most once per
+// minInterval however many peers join, and the rebroadcast skips the keys
+// republished since its previous round. Disabled by default, as it increases
+// the publish traffic.
+func WithJoinRepublish(minInterval time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if minInterval <= 0 {
+			return fmt.Errorf("invalid join republish interval: %s", minInterval)
+		}
+		store.joinRepublish = minInterval
+		return nil
+	}
+}
+
+// republishOnJoin republishes the key's stored record for a peer that joined
+// its topic, unless it was republished less than the minimum interval ago.
+func (p *PubsubValueStore) republishOnJoin(ctx context.Context, ti *topicInfo, key string) {
+	now := time.Now()
+	last := atomic.LoadInt64(&ti.joinRepublished)
+	if last != 0 && now.Sub(time.Unix(0, last)) < p.joinRepublish {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&ti.joinRepublished, last, now.UnixNano()) {
+		// republished by a concurrent join
+		return
+	}
+
+	val, err := p.getLocal(ctx, key)
+	if err != nil {
+		return
+	}
+	p.count(&p.stats.joinRepublishes, MetricJoinRepublishes)
+	go func() {
+		if err := <-p.psPublishChannel(ctx, ti.topic, val); err != nil && ctx.Err() == nil {
+			p.log.Debugf("PubsubPeerJoin: error republishing %s: %s", logKey(key), err)
+			p.reportError(key, OpPublish, err)
+		}
+	}()
+}
+
+// joinRepublishedSince reports whether the key was republished for a joining
+// peer since t.
+func (ti *topicInfo) joinRepublishedSince(t time.Time) bool {
+	last := atomic.LoadInt64(&ti.joinRepublished)
+	return last != 0 && !time.Unix(0, last).Before(t)
+}
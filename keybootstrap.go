@@ -0,0 +1,109 @@
+package namesys
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+type noBootstrapKey struct{}
+
+type bootstrapPeersKey struct{}
+
+// WithoutBootstrap is a Subscribe, GetValue and SearchValue option that keeps
+// the store from looking for peers of the key: its remembered peers aren't
+// dialed when it gets subscribed to, and Bootstrap skips it. Its records then
+// only travel through the peers connected otherwise, e.g. statically. It takes
+// precedence over WithBootstrapPeers.
+//
+// The bootstrap options are remembered for the key, for its later
+// subscriptions and bootstraps, until another call sets others.
+func WithoutBootstrap() routing.Option {
+	return func(opts *routing.Options) error {
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[noBootstrapKey{}] = true
+		return nil
+	}
+}
+
+// WithBootstrapPeers is a Subscribe, GetValue and SearchValue option that
+// dials the given peers to bootstrap the key, instead of its remembered ones,
+// see WithRememberedPeers. They are dialed whether or not peers are
+// remembered, and never forgotten.
+func WithBootstrapPeers(peers []peer.AddrInfo) routing.Option {
+	return func(opts *routing.Options) error {
+		if len(peers) == 0 {
+			return errors.New("invalid bootstrap peers: none")
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[bootstrapPeersKey{}] = append([]peer.AddrInfo(nil), peers...)
+		return nil
+	}
+}
+
+// WithSubscribeDefaults returns an option setting the bootstrap options,
+// WithoutBootstrap or WithBootstrapPeers, of the keys subscribed to without
+// any, e.g. implicitly by GetValue, SearchValue or PutValue.
+func WithSubscribeDefaults(opts ...routing.Option) Option {
+	return func(store *PubsubValueStore) error {
+		options, err := parseOptions(opts, false, noBootstrapKey{}, bootstrapPeersKey{})
+		if err != nil {
+			return err
+		}
+		store.bootstraps.defaults, _ = bootstrapOf(options)
+		return nil
+	}
+}
+
+// bootstrapConfig is how a key gets bootstrapped.
+type bootstrapConfig struct {
+	skip bool
+	// dialed instead of the remembered peers if set
+	peers []peer.AddrInfo
+}
+
+// bootstrapOf returns the bootstrap options set by the options, if any.
+func bootstrapOf(options routing.Options) (bootstrapConfig, bool) {
+	skip, _ := options.Other[noBootstrapKey{}].(bool)
+	peers, _ := options.Other[bootstrapPeersKey{}].([]peer.AddrInfo)
+	return bootstrapConfig{skip: skip, peers: peers}, skip || peers != nil
+}
+
+// keyBootstraps holds the bootstrap options of the keys subscribed to with
+// some, and the defaults of the others.
+type keyBootstraps struct {
+	mx       sync.Mutex
+	defaults bootstrapConfig
+	perKey   map[string]bootstrapConfig
+}
+
+// rememberBootstrap records the bootstrap options of the key, if the options
+// set any.
+func (p *PubsubValueStore) rememberBootstrap(key string, options routing.Options) {
+	cfg, ok := bootstrapOf(options)
+	if !ok {
+		return
+	}
+	p.bootstraps.mx.Lock()
+	defer p.bootstraps.mx.Unlock()
+	if p.bootstraps.perKey == nil {
+		p.bootstraps.perKey = make(map[string]bootstrapConfig)
+	}
+	p.bootstraps.perKey[key] = cfg
+}
+
+// bootstrapFor returns how the key gets bootstrapped.
+func (p *PubsubValueStore) bootstrapFor(key string) bootstrapConfig {
+	p.bootstraps.mx.Lock()
+	defer p.bootstraps.mx.Unlock()
+	if cfg, ok := p.bootstraps.perKey[key]; ok {
+		return cfg
+	}
+	return p.bootstraps.defaults
+}
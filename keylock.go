@@ -0,0 +1,68 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+)
+
+// keyLocks serializes the operations on a key, such as subscribing, without
+// blocking the operations on other keys.
+type keyLocks struct {
+	mx    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mx sync.Mutex
+	// number of goroutines holding or waiting for mx, guarded by keyLocks.mx
+	refs int
+}
+
+// lock locks the key and returns the function unlocking it.
+func (l *keyLocks) lock(key string) func() {
+	l.mx.Lock()
+	kl, ok := l.locks[key]
+	if !ok {
+		if l.locks == nil {
+			l.locks = make(map[string]*keyLock)
+		}
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mx.Unlock()
+
+	kl.mx.Lock()
+	return func() {
+		kl.mx.Unlock()
+
+		l.mx.Lock()
+		if kl.refs--; kl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mx.Unlock()
+	}
+}
+
+// lockContext locks mx, unless ctx ends first, in which case it returns the
+// context's error and mx is unlocked once acquired.
+func lockContext(ctx context.Context, mx *sync.Mutex) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	locked := make(chan struct{})
+	go func() {
+		mx.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			mx.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
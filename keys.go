@@ -0,0 +1,63 @@
+package namesys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxKeySize is the default maximum size of a key, see WithMaxKeySize.
+const DefaultMaxKeySize = 1 << 10
+
+// ErrInvalidKey matches the InvalidKeyError returned for keys the store can't
+// operate on, with errors.Is.
+var ErrInvalidKey = errors.New("invalid key")
+
+// InvalidKeyError is returned by the operations on a key the store can't
+// operate on, e.g. an empty one, before touching pubsub or the datastore.
+type InvalidKeyError struct {
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key: %s", e.Reason)
+}
+
+// Is reports whether target is ErrInvalidKey.
+func (e *InvalidKeyError) Is(target error) bool {
+	return target == ErrInvalidKey
+}
+
+// WithMaxKeySize returns an option that sets the maximum size, in bytes, of
+// the keys the store operates on, instead of DefaultMaxKeySize. Zero means
+// unlimited.
+func WithMaxKeySize(size int) Option {
+	return func(store *PubsubValueStore) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max key size: %d", size)
+		}
+		store.maxKeySize = size
+		return nil
+	}
+}
+
+// validateKey returns an InvalidKeyError if the key is empty or too large.
+// Keys are arbitrary binary: any of them maps to a topic, base64url encoded,
+// and to a datastore key, base32 encoded, so only their size is limited.
+func (p *PubsubValueStore) validateKey(key string) error {
+	if key == "" {
+		return &InvalidKeyError{Reason: "empty"}
+	}
+	if p.maxKeySize > 0 && len(key) > p.maxKeySize {
+		return &InvalidKeyError{Reason: fmt.Sprintf("%d bytes, over the maximum of %d", len(key), p.maxKeySize)}
+	}
+	return nil
+}
+
+// checkKey returns an error if the store can't operate on the key, see
+// validateKey and checkNamespace.
+func (p *PubsubValueStore) checkKey(key string) error {
+	if err := p.validateKey(key); err != nil {
+		return err
+	}
+	return p.checkNamespace(key)
+}
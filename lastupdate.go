@@ -0,0 +1,46 @@
+package namesys
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// lastUpdates is when a key last got an update accepted and rejected.
+type lastUpdates struct {
+	mx       sync.Mutex
+	accepted time.Time
+	rejected time.Time
+	// peer of the latest of the two
+	from peer.ID
+}
+
+func (l *lastUpdates) record(accepted bool, from peer.ID) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if accepted {
+		l.accepted = time.Now()
+	} else {
+		l.rejected = time.Now()
+	}
+	l.from = from
+}
+
+// LastUpdate returns when the subscribed key last had a record accepted, by
+// PutValue or from the network, and a message rejected by the topic validator,
+// along with the peer the latest of the two came from. Either time is zero if
+// there was none since the key was subscribed; ok is false if it isn't.
+//
+// This is kept in memory only, and forgotten on Cancel.
+func (p *PubsubValueStore) LastUpdate(key string) (accepted, rejected time.Time, from peer.ID, ok bool) {
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		return time.Time{}, time.Time{}, "", false
+	}
+	ti.last.mx.Lock()
+	defer ti.last.mx.Unlock()
+	return ti.last.accepted, ti.last.rejected, ti.last.from, true
+}
@@ -0,0 +1,102 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// ErrSubscriptionEnded is the Err of a Search ended because the lifetime of
+// the key's subscription ended, see WithLifetime.
+var ErrSubscriptionEnded = errors.New("subscription lifetime ended")
+
+type lifetimeKey struct{}
+
+// WithLifetime is a Subscribe, GetValue and SearchValue option that ties the
+// key's subscription to ctx: once ctx ends, the subscription is canceled as
+// with Cancel, whether or not the call created it. Unlike Cancel, watchers
+// don't keep it alive; they are ended instead, searches with
+// ErrSubscriptionEnded. A later subscription of the key isn't affected.
+//
+// Without it subscriptions last as long as the store, subject to the usual
+// expiry.
+func WithLifetime(ctx context.Context) routing.Option {
+	return func(opts *routing.Options) error {
+		if ctx == nil {
+			return errors.New("invalid lifetime: nil context")
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[lifetimeKey{}] = ctx
+		return nil
+	}
+}
+
+// subscribeWith is subscribe honoring the WithLifetime and bootstrap options.
+func (p *PubsubValueStore) subscribeWith(ctx context.Context, key string, hold bool, options routing.Options) error {
+	// before remembering its options
+	if err := p.checkKey(key); err != nil {
+		return err
+	}
+	p.rememberBootstrap(key, options)
+	if err := p.subscribe(ctx, key, hold); err != nil {
+		return err
+	}
+	lifetime, ok := options.Other[lifetimeKey{}].(context.Context)
+	if !ok {
+		return nil
+	}
+
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		return nil
+	}
+	go func() {
+		select {
+		case <-lifetime.Done():
+			p.endSubscription(key, ti)
+		case <-ti.finished:
+		}
+	}()
+	return nil
+}
+
+// endSubscription cancels the key's subscription ti, if still current, and
+// ends its watchers.
+func (p *PubsubValueStore) endSubscription(key string, ti *topicInfo) {
+	unlock := p.keyLocks.lock(key)
+	defer unlock()
+
+	p.mx.Lock()
+	if cur, ok := p.topics[key]; !ok || cur != ti {
+		p.mx.Unlock()
+		return
+	}
+	p.closeTopic(key, ti)
+	p.watchLk.Lock()
+	p.endWatchersLocked(key)
+	p.watchLk.Unlock()
+	p.mx.Unlock()
+
+	<-ti.finished
+	p.flushPending(p.ctx, key)
+}
+
+// endWatchersLocked ends all the watchers of the key: the searches end, and
+// the callbacks are unregistered.
+// Must be called with p.watchLk held.
+func (p *PubsubValueStore) endWatchersLocked(key string) {
+	wg, ok := p.watching[key]
+	if !ok {
+		return
+	}
+	delete(p.watching, key)
+	close(wg.ended)
+	for cb := range wg.callbacks {
+		cb.once.Do(func() { close(cb.done) })
+	}
+}
@@ -0,0 +1,48 @@
+package namesys
+
+import (
+	"errors"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("pubsub-valuestore")
+
+// Logger is what a PubsubValueStore logs to, see WithLogger. The loggers of
+// go-log implement it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WithLogger returns an option that sets the logger of the store. Defaults to
+// the "pubsub-valuestore" go-log logger, whose lines are tagged with the
+// store's peer ID; other loggers are used as is, so that they can carry their
+// own context.
+func WithLogger(l Logger) Option {
+	return func(store *PubsubValueStore) error {
+		if l == nil {
+			return errors.New("invalid logger: nil")
+		}
+		store.log = l
+		return nil
+	}
+}
+
+// storeLogger returns the logger of the store with the given peer ID.
+func storeLogger(l Logger, id peer.ID) Logger {
+	if zl, ok := l.(*logging.ZapEventLogger); ok {
+		return zl.With("store", id.String())
+	}
+	return l
+}
+
+// logKey formats a key for the logs, only if the line is logged.
+type logKey string
+
+func (k logKey) String() string {
+	return formatKey(string(k))
+}
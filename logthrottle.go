@@ -0,0 +1,113 @@
+package namesys
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// MetricLogsSuppressed counts the warnings logged at debug level only,
+	// because a similar one was logged recently.
+	MetricLogsSuppressed = "logs_suppressed"
+
+	// logThrottleInterval is the minimum interval between two similar
+	// warnings about a key.
+	logThrottleInterval = time.Minute
+	// maxThrottledLogs is the maximum number of keys and classes tracked for
+	// throttling; beyond that, the warnings of new ones are suppressed.
+	maxThrottledLogs = 1024
+)
+
+// Classes of the throttled warnings.
+const (
+	logInvalid      = "invalid"
+	logStore        = "store"
+	logSubscription = "subscription"
+)
+
+type throttleKey struct {
+	key   string
+	class string
+}
+
+type throttledLog struct {
+	last       time.Time
+	suppressed int
+}
+
+// logThrottle throttles the warnings of each class about each key, so that
+// e.g. a peer repeatedly sending an invalid record doesn't flood the logs.
+type logThrottle struct {
+	mx   sync.Mutex
+	logs map[throttleKey]*throttledLog
+}
+
+// allow reports whether a warning of the class about the key should be logged
+// now, and if so, how many similar ones were suppressed since the last one.
+func (t *logThrottle) allow(key, class string, now time.Time) (bool, int) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.logs == nil {
+		t.logs = make(map[throttleKey]*throttledLog)
+	}
+	tk := throttleKey{key: key, class: class}
+	l, ok := t.logs[tk]
+	if !ok {
+		if len(t.logs) >= maxThrottledLogs {
+			t.sweep(now)
+			if len(t.logs) >= maxThrottledLogs {
+				return false, 0
+			}
+		}
+		t.logs[tk] = &throttledLog{last: now}
+		return true, 0
+	}
+	if now.Sub(l.last) < logThrottleInterval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last = now
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// sweep drops the state of the warnings that can be logged again. Must be
+// called with t.mx held.
+func (t *logThrottle) sweep(now time.Time) {
+	for tk, l := range t.logs {
+		if now.Sub(l.last) >= logThrottleInterval {
+			delete(t.logs, tk)
+		}
+	}
+}
+
+// forget drops the state of the warnings about the key.
+func (t *logThrottle) forget(key string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for tk := range t.logs {
+		if tk.key == key {
+			delete(t.logs, tk)
+		}
+	}
+}
+
+// warnf logs a warning of the class about the key, unless a similar one was
+// logged less than a minute ago, in which case it's logged at debug level and
+// counted in Stats.LogsSuppressed. The next warning logged tells how many
+// were suppressed.
+func (p *PubsubValueStore) warnf(key, class, format string, args ...interface{}) {
+	ok, suppressed := p.throttle.allow(key, class, time.Now())
+	if !ok {
+		p.count(&p.stats.logsSuppressed, MetricLogsSuppressed)
+		p.log.Debugf(format, args...)
+		return
+	}
+	if suppressed > 0 {
+		p.log.Warnf(format+" (suppressed %d similar)", append(args, suppressed)...)
+		return
+	}
+	p.log.Warnf(format, args...)
+}
@@ -0,0 +1,62 @@
+package namesys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MetricEvictions counts the subscriptions canceled to make room for new ones,
+// see WithMaxSubscriptions.
+const MetricEvictions = "evictions"
+
+// ErrTooManySubscriptions is returned when subscribing to a key would exceed
+// the limit set with WithMaxSubscriptions, and no subscription can be evicted.
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// WithMaxSubscriptions returns an option that limits the number of keys
+// subscribed to at the same time. Once the limit is reached, subscribing to
+// another key cancels the least recently used subscription that is neither
+// held through Subscribe nor watched, or fails with ErrTooManySubscriptions if
+// there is none. Zero, the default, means unlimited.
+func WithMaxSubscriptions(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max subscriptions: %d", n)
+		}
+		store.maxSubscriptions = n
+		return nil
+	}
+}
+
+// makeRoomLocked evicts a subscription if the limit is reached, and reports
+// whether there is room for a new one.
+// Must be called with p.mx held.
+func (p *PubsubValueStore) makeRoomLocked() bool {
+	if p.maxSubscriptions == 0 || len(p.topics) < p.maxSubscriptions {
+		return true
+	}
+
+	if key, ti := p.evictableLocked(); ti != nil {
+		p.log.Debugf("PubsubResolve: evicting %s", logKey(key))
+		p.closeTopic(key, ti)
+		p.count(&p.stats.evictions, MetricEvictions)
+		return true
+	}
+	return false
+}
+
+// evictableLocked returns the least recently used subscription that is
+// neither held nor watched, if any.
+// Must be called with p.mx held.
+func (p *PubsubValueStore) evictableLocked() (string, *topicInfo) {
+	p.watchLk.Lock()
+	defer p.watchLk.Unlock()
+	for e := p.lru.Back(); e != nil; e = e.Prev() {
+		key := e.Value.(string)
+		ti := p.topics[key]
+		if _, watched := p.watching[key]; !watched && ti.refs == 0 {
+			return key, ti
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,88 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// Merger merges concurrent records of a key, e.g. the sets published by
+// different peers into their union, instead of choosing one of them with the
+// validator's Select. Merge must be deterministic, commutative and idempotent
+// for peers to converge on the same record.
+type Merger interface {
+	// Merge returns the record combining the stored record, old, and a newly
+	// received one. The result must be valid.
+	Merge(key string, old, new []byte) ([]byte, error)
+}
+
+// WithMerger returns an option that merges the received and put records into
+// the stored ones with m. Merged records differing from the received ones are
+// published, so that their publishers catch up.
+func WithMerger(m Merger) Option {
+	return func(store *PubsubValueStore) error {
+		if m == nil {
+			return errors.New("invalid merger: nil")
+		}
+		store.merger = m
+		return nil
+	}
+}
+
+// mergeLocal returns the record to store in place of value: value merged into
+// the stored record if there is a merger, or value itself.
+// Must be called with the key's ti.dbWriteMx held, if it is subscribed to.
+func (p *PubsubValueStore) mergeLocal(ctx context.Context, key string, value []byte) ([]byte, error) {
+	if p.merger == nil {
+		return value, nil
+	}
+	validator := p.GetValidator()
+	if validator.Validate(key, value) != nil {
+		// dropped by putLocal
+		return value, nil
+	}
+	old, err := p.getLocalWith(ctx, validator, key)
+	if err != nil {
+		// no valid record to merge into
+		return value, nil
+	}
+	return p.merge(validator, key, old, value)
+}
+
+func (p *PubsubValueStore) merge(validator record.Validator, key string, old, value []byte) ([]byte, error) {
+	merged, err := p.merger.Merge(key, old, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := validator.Validate(key, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeCompare is compareRecords with a merger: val is better than old if it
+// brings something to it.
+func (p *PubsubValueStore) mergeCompare(validator record.Validator, key string, val, old []byte) int {
+	if bytes.Equal(old, val) {
+		return 0
+	}
+	merged, err := p.merge(validator, key, old, val)
+	if err != nil || bytes.Equal(merged, old) {
+		return -1
+	}
+	return 1
+}
+
+// publishMerged publishes the record merged from a received one in the
+// background. Peers that already have it ignore it as a duplicate, which ends
+// the exchange.
+func (p *PubsubValueStore) publishMerged(ctx context.Context, ti *topicInfo, key string, merged []byte) {
+	done := p.psPublishChannel(ctx, ti.topic, merged)
+	go func() {
+		if err := <-done; err != nil && ctx.Err() == nil {
+			p.reportError(key, OpPublish, err)
+		}
+	}()
+}
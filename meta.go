@@ -0,0 +1,139 @@
+package namesys
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RecordMeta describes how the locally stored record of a key was obtained.
+type RecordMeta struct {
+	// From is the peer that delivered the record: the publisher of the pubsub
+	// message, the peer it was fetched from, or our own host for records put
+	// locally. It is empty for imported records.
+	From peer.ID `json:"from,omitempty"`
+	// Seqno is the sequence number of the pubsub message that carried the
+	// record, if any.
+	Seqno []byte `json:"seqno,omitempty"`
+	// Received is the time the record was stored locally.
+	Received time.Time `json:"received"`
+}
+
+var metaPrefix = ds.NewKey("/meta")
+
+// metaKey returns the datastore key of the metadata stored alongside the
+// key's record. It never collides with record keys, which are bare base32.
+func metaKey(key string) ds.Key {
+	return metaPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+func (p *PubsubValueStore) putMeta(ctx context.Context, key string, meta RecordMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return p.ds.Put(ctx, metaKey(key), b)
+}
+
+// getMeta returns the key's record metadata, or zero values if there is none,
+// e.g. because the record was stored by an older version.
+func (p *PubsubValueStore) getMeta(ctx context.Context, key string) (RecordMeta, error) {
+	var meta RecordMeta
+	b, err := p.ds.Get(ctx, metaKey(key))
+	if err != nil {
+		if err == ds.ErrNotFound {
+			err = nil
+		}
+		return meta, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		p.log.Debugf("discarding corrupt metadata for %s: %s", logKey(key), err)
+		return RecordMeta{}, nil
+	}
+	return meta, nil
+}
+
+// GetValueWithMeta is like GetValue, but also returns the metadata of the
+// record. Records stored before metadata was recorded come with zero values.
+func (p *PubsubValueStore) GetValueWithMeta(ctx context.Context, key string) ([]byte, RecordMeta, error) {
+	if err := p.subscribe(ctx, key, false); err != nil {
+		return nil, RecordMeta{}, err
+	}
+
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		return nil, RecordMeta{}, ErrNotSubscribed
+	}
+
+	// don't read the metadata of another record
+	ti.dbWriteMx.Lock()
+	defer ti.dbWriteMx.Unlock()
+
+	val, err := p.getLocal(ctx, key)
+	if err != nil {
+		return nil, RecordMeta{}, err
+	}
+	meta, err := p.getMeta(ctx, key)
+	if err != nil {
+		return nil, RecordMeta{}, err
+	}
+	return p.open(val), meta, nil
+}
+
+// ValueUpdate is a record delivered by SearchValueWithMeta, along with its
+// provenance.
+type ValueUpdate struct {
+	Value []byte
+	RecordMeta
+}
+
+// SearchValueWithMeta watches the key's record until ctx ends or the store is
+// closed, sending the stored record if any and then every better record, along
+// with the peer that delivered it. Records put locally come from our own host.
+// As with SearchValue, the channel holds the latest update until it is read.
+func (p *PubsubValueStore) SearchValueWithMeta(ctx context.Context, key string) (<-chan ValueUpdate, error) {
+	if err := p.subscribe(ctx, key, false); err != nil {
+		return nil, err
+	}
+
+	// as in Search, the records accepted after the read are notified
+	p.watchLk.Lock()
+	defer p.watchLk.Unlock()
+
+	out := make(chan ValueUpdate, 1)
+	var last []byte
+	if val, err := p.getLocal(ctx, key); err == nil {
+		meta, err := p.getMeta(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		out <- ValueUpdate{Value: p.open(val), RecordMeta: meta}
+		last = val
+	}
+
+	wg := p.watchGroupLocked(key)
+	wg.metaListeners[out] = last
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.ctx.Done():
+		case <-wg.ended:
+		}
+
+		p.watchLk.Lock()
+		delete(wg.metaListeners, out)
+		p.dropWatchGroupLocked(key, wg)
+		p.watchLk.Unlock()
+
+		close(out)
+	}()
+
+	return out, nil
+}
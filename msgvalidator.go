@@ -0,0 +1,42 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrRejected is returned by PutValue when the message validator rejected the
+// record.
+var ErrRejected = errors.New("record rejected by the message validator")
+
+// MessageValidator is an application-level check of incoming and published
+// records, run after the record passed the store's own validation. It
+// returns false to reject the record.
+type MessageValidator func(ctx context.Context, key string, value []byte, from peer.ID) bool
+
+// WithMessageValidator returns an option that runs v on every record the
+// store is about to accept: records received through pubsub, records fetched
+// from peers joining a topic, and records published with PutValue. A
+// panicking validator rejects the record.
+func WithMessageValidator(v MessageValidator) Option {
+	return func(store *PubsubValueStore) error {
+		store.msgValidator = v
+		return nil
+	}
+}
+
+// messageAllowed runs the message validator, if any.
+func (p *PubsubValueStore) messageAllowed(ctx context.Context, key string, value []byte, from peer.ID) (ok bool) {
+	if p.msgValidator == nil {
+		return true
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.log.Errorf("message validator panicked on %s: %v", logKey(key), r)
+			ok = false
+		}
+	}()
+	return p.msgValidator(ctx, key, p.open(value), from)
+}
@@ -0,0 +1,213 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+)
+
+// KeyedValue is an update of a MultiSearch: the record of Key, or Err if
+// watching the key failed, e.g. with ErrUnsupportedNamespace.
+type KeyedValue struct {
+	Key   string
+	Value []byte
+	Err   error
+}
+
+// MultiSearch watches several keys through a single channel, see
+// SearchValues.
+type MultiSearch struct {
+	p *PubsubValueStore
+
+	out  chan KeyedValue
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mx sync.Mutex
+	// unregister functions of the watched keys
+	keys map[string]func()
+	// updates not received yet, at most one per key, sent in order
+	pending map[string]queuedValue
+	queue   []string
+	seq     uint64
+	ended   bool
+}
+
+type queuedValue struct {
+	KeyedValue
+	seq uint64
+}
+
+// SearchValues watches the keys until ctx ends, the store is closed or the
+// search is canceled, sending the stored record of each key if any and then
+// every better record on the Values channel. Only the latest record of a key
+// is kept until the channel is read: a key updated in the meantime is sent
+// once, with its newest record. Watching a key that fails is reported on the
+// channel too, without affecting the other keys.
+//
+// Keys can be added and removed while searching. As with
+// RegisterOnValueChanged, a key stops being watched if it's canceled.
+func (p *PubsubValueStore) SearchValues(ctx context.Context, keys []string) (*MultiSearch, error) {
+	if p.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	m := &MultiSearch{
+		p:       p,
+		out:     make(chan KeyedValue),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		keys:    make(map[string]func()),
+		pending: make(map[string]queuedValue),
+	}
+	m.Add(keys...)
+	go m.run(ctx)
+	return m, nil
+}
+
+// Values returns the channel of the search, closed once it ends.
+func (m *MultiSearch) Values() <-chan KeyedValue {
+	return m.out
+}
+
+// Add starts watching the keys not watched yet.
+func (m *MultiSearch) Add(keys ...string) {
+	for _, key := range keys {
+		m.mx.Lock()
+		_, ok := m.keys[key]
+		if ok || m.ended {
+			m.mx.Unlock()
+			continue
+		}
+		// reserve the key, registering may take a while
+		m.keys[key] = func() {}
+		m.mx.Unlock()
+
+		unregister, err := m.p.registerCallback(key, func(key string, val []byte) {
+			m.push(KeyedValue{Key: key, Value: val}, false)
+		}, true)
+
+		m.mx.Lock()
+		_, ok = m.keys[key]
+		switch {
+		case err != nil:
+			delete(m.keys, key)
+			m.mx.Unlock()
+			m.push(KeyedValue{Key: key, Err: err}, true)
+			continue
+		case !ok || m.ended:
+			// removed meanwhile
+			m.mx.Unlock()
+			unregister()
+			continue
+		}
+		m.keys[key] = unregister
+		m.mx.Unlock()
+	}
+}
+
+// Remove stops watching the keys, dropping their updates not received yet.
+func (m *MultiSearch) Remove(keys ...string) {
+	for _, key := range keys {
+		m.mx.Lock()
+		unregister, ok := m.keys[key]
+		delete(m.keys, key)
+		m.dropLocked(key)
+		m.mx.Unlock()
+		if ok {
+			unregister()
+		}
+	}
+}
+
+// Cancel ends the search. It returns once the Values channel is closed, and
+// may be called multiple times.
+func (m *MultiSearch) Cancel() {
+	m.once.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+// push queues the update, replacing the one of the key not received yet.
+// Updates of keys not watched anymore are dropped, unless failed is set.
+func (m *MultiSearch) push(kv KeyedValue, failed bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if _, ok := m.keys[kv.Key]; m.ended || !ok && !failed {
+		return
+	}
+	if _, ok := m.pending[kv.Key]; !ok {
+		m.queue = append(m.queue, kv.Key)
+	}
+	m.seq++
+	m.pending[kv.Key] = queuedValue{KeyedValue: kv, seq: m.seq}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dropLocked drops the update of the key not received yet. Must be called
+// with m.mx held.
+func (m *MultiSearch) dropLocked(key string) {
+	if _, ok := m.pending[key]; !ok {
+		return
+	}
+	delete(m.pending, key)
+	for i, k := range m.queue {
+		if k == key {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *MultiSearch) run(ctx context.Context) {
+	defer close(m.done)
+	for {
+		m.mx.Lock()
+		var next queuedValue
+		var out chan KeyedValue
+		if len(m.queue) > 0 {
+			next = m.pending[m.queue[0]]
+			out = m.out
+		}
+		m.mx.Unlock()
+
+		select {
+		case out <- next.KeyedValue:
+			m.mx.Lock()
+			// unless replaced by a newer update meanwhile
+			if cur, ok := m.pending[next.Key]; ok && cur.seq == next.seq {
+				m.dropLocked(next.Key)
+			}
+			m.mx.Unlock()
+		case <-m.wake:
+		case <-ctx.Done():
+			m.end()
+			return
+		case <-m.p.ctx.Done():
+			m.end()
+			return
+		case <-m.stop:
+			m.end()
+			return
+		}
+	}
+}
+
+// end unregisters all the keys, and closes the Values channel.
+func (m *MultiSearch) end() {
+	m.mx.Lock()
+	m.ended = true
+	keys := m.keys
+	m.keys = nil
+	m.pending = nil
+	m.queue = nil
+	m.mx.Unlock()
+
+	for _, unregister := range keys {
+		unregister()
+	}
+	close(m.out)
+}
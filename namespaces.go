@@ -0,0 +1,41 @@
+package namesys
+
+import (
+	"errors"
+	"fmt"
+
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// ErrUnsupportedNamespace is returned for keys outside of the namespaces
+// configured with WithNamespaces.
+var ErrUnsupportedNamespace = errors.New("unsupported key namespace")
+
+// WithNamespaces returns an option that restricts the store to keys in the
+// given namespaces, e.g. "ipns". Operations on any other key fail with
+// ErrUnsupportedNamespace without touching pubsub.
+func WithNamespaces(namespaces ...string) Option {
+	return func(store *PubsubValueStore) error {
+		store.namespaces = make(map[string]struct{}, len(namespaces))
+		for _, ns := range namespaces {
+			store.namespaces[ns] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// checkNamespace returns ErrUnsupportedNamespace if the key is outside of the
+// configured namespaces.
+func (p *PubsubValueStore) checkNamespace(key string) error {
+	if len(p.namespaces) == 0 {
+		return nil
+	}
+	ns, _, err := record.SplitKey(key)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedNamespace, err)
+	}
+	if _, ok := p.namespaces[ns]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedNamespace, ns)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package namesys_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+	"github.com/libp2p/go-libp2p-pubsub-router/pstoretest"
+)
+
+func TestPutValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vss := pstoretest.NewTestNetwork(t, 5)
+	pub := vss[0]
+
+	kvs := map[string][]byte{
+		"/namespace/key1": []byte("valid for key1"),
+		"/namespace/key2": []byte("valid for key2"),
+		"/namespace/key3": []byte("valid for key3"),
+		"/namespace/key4": []byte("valid for key4 invalid"),
+	}
+
+	// subscribe before publishing
+	for _, vs := range vss[1:] {
+		for key := range kvs {
+			if err := vs.Subscribe(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err := pub.PutValues(ctx, kvs)
+	var perr namesys.PutValuesError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected PutValuesError, got %v", err)
+	}
+	if len(perr) != 1 || !errors.Is(perr["/namespace/key4"], namesys.ErrInvalidRecord) {
+		t.Fatalf("unexpected errors: %v", perr)
+	}
+
+	for _, key := range []string{"/namespace/key1", "/namespace/key2", "/namespace/key3"} {
+		for _, vs := range vss[1:] {
+			pstoretest.WaitForValue(t, vs, key, kvs[key], 10*time.Second)
+		}
+	}
+}
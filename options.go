@@ -0,0 +1,71 @@
+package namesys
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// UnsupportedOptionError is returned when an operation is given a routing
+// option it doesn't support, e.g. a DHT quorum, rather than ignoring it. Key
+// is the routing.Options.Other key the option set, or the name of the
+// routing.Options field.
+type UnsupportedOptionError struct {
+	Key interface{}
+}
+
+func (e *UnsupportedOptionError) Error() string {
+	if name, ok := e.Key.(string); ok {
+		return fmt.Sprintf("unsupported routing option: %s", name)
+	}
+	return fmt.Sprintf("unsupported routing option: %T", e.Key)
+}
+
+// parseOptions applies the routing options of an operation, failing with an
+// UnsupportedOptionError on the ones it doesn't support: offline is whether
+// routing.Offline is supported, and other the supported Other keys.
+func parseOptions(opts []routing.Option, offline bool, other ...interface{}) (routing.Options, error) {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		return routing.Options{}, err
+	}
+	if options.Expired {
+		return routing.Options{}, &UnsupportedOptionError{Key: "Expired"}
+	}
+	if options.Offline && !offline {
+		return routing.Options{}, &UnsupportedOptionError{Key: "Offline"}
+	}
+next:
+	for k := range options.Other {
+		for _, o := range other {
+			if k == o {
+				continue next
+			}
+		}
+		return routing.Options{}, &UnsupportedOptionError{Key: k}
+	}
+	return options, nil
+}
+
+// ownOptions returns the options as understood by a PubsubValueStore
+// operation, without the ones meant for other stores, which parseOptions
+// would reject.
+func ownOptions(opts []routing.Option) []routing.Option {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		// left for the operation to report
+		return opts
+	}
+	own := make(map[interface{}]interface{})
+	for k, v := range options.Other {
+		switch k.(type) {
+		case skipSecondaryKey, fullHistoryKey, lifetimeKey, maxResultsKey, stopWhenKey, ttlKey, noBootstrapKey, bootstrapPeersKey:
+			own[k] = v
+		}
+	}
+	return []routing.Option{func(o *routing.Options) error {
+		o.Offline = options.Offline
+		o.Other = own
+		return nil
+	}}
+}
@@ -0,0 +1,16 @@
+package namesys
+
+// WithPassThrough returns an option that makes the store keep no records, e.g.
+// for relays that only keep the topics alive. Incoming records are still
+// validated, so that invalid ones aren't forwarded, and handed to the
+// watchers, but never stored; PutValue publishes without storing.
+//
+// GetValue then returns routing.ErrNotFound, unless ctx has a deadline in
+// which case it waits for a record from the network until then. Peers fetching
+// records from us get none either.
+func WithPassThrough() Option {
+	return func(store *PubsubValueStore) error {
+		store.passThrough = true
+		return nil
+	}
+}
@@ -0,0 +1,368 @@
+package namesys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+const (
+	// maxPeerFailures is the number of failed dials in a row after which a
+	// remembered peer is forgotten.
+	maxPeerFailures = 3
+	// rememberedDialTimeout bounds dialing a remembered peer.
+	rememberedDialTimeout = 10 * time.Second
+
+	// MetricPeerDialAttempts counts the passes dialing the remembered peers
+	// of a key.
+	MetricPeerDialAttempts = "peer_dial_attempts"
+
+	peerDialMinBackoff = time.Second
+	peerDialMaxBackoff = 5 * time.Minute
+
+	// DefaultMaxConcurrentDials is the default number of remembered peers
+	// dialed at the same time, see WithMaxConcurrentDials.
+	DefaultMaxConcurrentDials = 4
+	// DefaultMaxRememberedPeers is the default maximum number of peers
+	// remembered per key, see WithPeerDialTarget.
+	DefaultMaxRememberedPeers = 32
+	// DefaultPeerDialTarget is the default number of connected remembered
+	// peers after which the others aren't dialed, see WithPeerDialTarget.
+	DefaultPeerDialTarget = 4
+)
+
+var peersPrefix = ds.NewKey("/peers")
+
+// peersKey returns the datastore key of the peers remembered for the key.
+func peersKey(key string) ds.Key {
+	return peersPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+// WithRememberedPeers returns an option that saves the peers of every
+// subscribed topic, with their addresses, to the datastore every interval.
+// When a key is subscribed to, e.g. by RestoreSubscriptions after a restart,
+// its remembered peers are dialed right away, alongside the peer discovery of
+// the pubsub, until enough of them are connected, see WithPeerDialTarget. If
+// none can be connected, they are dialed again with exponential backoff until
+// one is, the topic gets peers otherwise, or the key is canceled. Peers that
+// fail to be dialed 3 times in a row are forgotten. It is only useful along
+// with a persistent datastore.
+func WithRememberedPeers(interval time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid peer snapshot interval: %s", interval)
+		}
+		store.peerSnapshotInterval = interval
+		return nil
+	}
+}
+
+// WithMaxConcurrentDials returns an option that limits the number of
+// remembered peers dialed at the same time when subscribing to a key, see
+// WithRememberedPeers. Defaults to DefaultMaxConcurrentDials.
+func WithMaxConcurrentDials(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max concurrent dials: %d", n)
+		}
+		store.maxConcurrentDials = n
+		return nil
+	}
+}
+
+// WithPeerDialTarget returns an option that sets how many peers are
+// remembered per key, and how many of them need to be connected for the
+// others not to be dialed, see WithRememberedPeers. Defaults to
+// DefaultMaxRememberedPeers and DefaultPeerDialTarget.
+func WithPeerDialTarget(remembered, connected int) Option {
+	return func(store *PubsubValueStore) error {
+		if remembered <= 0 || connected <= 0 || connected > remembered {
+			return fmt.Errorf("invalid peer dial target: %d of %d", connected, remembered)
+		}
+		store.maxRememberedPeers = remembered
+		store.peerDialTarget = connected
+		return nil
+	}
+}
+
+// rememberedPeer is a peer of a topic, as saved in the datastore.
+type rememberedPeer struct {
+	Info peer.AddrInfo `json:"info"`
+	// failed dials in a row
+	Failures int `json:"failures,omitempty"`
+}
+
+// rememberedPeers serializes the updates of the remembered peers.
+type rememberedPeers struct {
+	mx sync.Mutex
+}
+
+func (p *PubsubValueStore) getRememberedPeers(ctx context.Context, key string) ([]rememberedPeer, error) {
+	b, err := p.ds.Get(ctx, peersKey(key))
+	if err != nil {
+		if err == ds.ErrNotFound {
+			err = nil
+		}
+		return nil, err
+	}
+	var peers []rememberedPeer
+	if err := json.Unmarshal(b, &peers); err != nil {
+		p.log.Debugf("forgetting corrupt remembered peers of %s: %s", logKey(key), err)
+		return nil, nil
+	}
+	return peers, nil
+}
+
+func (p *PubsubValueStore) putRememberedPeers(ctx context.Context, key string, peers []rememberedPeer) error {
+	if len(peers) == 0 {
+		return p.ds.Delete(ctx, peersKey(key))
+	}
+	b, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	return p.ds.Put(ctx, peersKey(key), b)
+}
+
+// snapshotPeers saves the peers of all the subscribed topics every snapshot
+// interval, until the store is closed.
+func (p *PubsubValueStore) snapshotPeers() {
+	ticker := time.NewTicker(p.peerSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+
+		p.mx.Lock()
+		topics := make(map[string]*topicInfo, len(p.topics))
+		for k, ti := range p.topics {
+			topics[k] = ti
+		}
+		p.mx.Unlock()
+
+		for key, ti := range topics {
+			if err := p.snapshotTopicPeers(key, ti.topic.ListPeers()); err != nil {
+				p.log.Debugf("PubsubPeers: error saving the peers of %s: %s", logKey(key), err)
+				p.reportError(key, OpStore, err)
+			}
+		}
+	}
+}
+
+// snapshotTopicPeers remembers the current peers of the key's topic, along
+// with the previously remembered ones still within their failure budget.
+func (p *PubsubValueStore) snapshotTopicPeers(key string, current []peer.ID) error {
+	p.remembered.mx.Lock()
+	defer p.remembered.mx.Unlock()
+
+	old, err := p.getRememberedPeers(p.ctx, key)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[peer.ID]bool)
+	var peers []rememberedPeer
+	for _, pid := range current {
+		if len(peers) == p.maxRememberedPeers {
+			break
+		}
+		addrs := p.host().Peerstore().Addrs(pid)
+		if len(addrs) == 0 {
+			continue
+		}
+		seen[pid] = true
+		peers = append(peers, rememberedPeer{Info: peer.AddrInfo{ID: pid, Addrs: addrs}})
+	}
+	for _, rp := range old {
+		if len(peers) == p.maxRememberedPeers {
+			break
+		}
+		if !seen[rp.Info.ID] {
+			peers = append(peers, rp)
+		}
+	}
+	return p.putRememberedPeers(p.ctx, key, peers)
+}
+
+// dial outcomes of the remembered peers
+const (
+	dialUntried = iota
+	dialConnected
+	dialFailed
+)
+
+// dialRemembered dials the remembered peers of the subscribed key, or its
+// bootstrap peers, retrying with exponential backoff while none of them can be
+// connected. It returns once one is, the topic has peers, no peers are
+// remembered anymore, or ctx is done. With auto-tuning, it then dials them
+// again whenever the key is due for a discovery, until ctx is done.
+func (p *PubsubValueStore) dialRemembered(ctx context.Context, ti *topicInfo, key string) {
+	p.dialRememberedUntilConnected(ctx, ti, key)
+	if ti.rediscover == nil {
+		return
+	}
+	for {
+		select {
+		case <-ti.rediscover:
+		case <-ctx.Done():
+			return
+		}
+		p.log.Debugf("PubsubPeers: %s is due for a discovery, dialing its peers again", logKey(key))
+		p.dialPass(ctx, key)
+	}
+}
+
+func (p *PubsubValueStore) dialRememberedUntilConnected(ctx context.Context, ti *topicInfo, key string) {
+	backoff := peerDialMinBackoff
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+
+		connected, left := p.dialPass(ctx, key)
+		if connected > 0 || left == 0 || len(ti.topic.ListPeers()) > 0 {
+			return
+		}
+		p.log.Debugf("PubsubPeers: no remembered peer of %s connected, retrying in %s", logKey(key), backoff)
+
+		timer.Reset(backoff)
+		if backoff *= 2; backoff > peerDialMaxBackoff {
+			backoff = peerDialMaxBackoff
+		}
+	}
+}
+
+// dialPass dials the remembered peers of the key not connected yet, a few at
+// a time, until enough of them are connected. The peers failing too often are
+// forgotten. It returns the number of connected peers, and of peers still
+// remembered. The key's bootstrap peers, if set, are dialed instead, and
+// never forgotten.
+func (p *PubsubValueStore) dialPass(pctx context.Context, key string) (int, int) {
+	cfg := p.bootstrapFor(key)
+	if cfg.skip {
+		return 0, 0
+	}
+	if cfg.peers != nil {
+		p.count(&p.stats.peerDialAttempts, MetricPeerDialAttempts)
+		_, n := p.dialPeers(pctx, key, cfg.peers)
+		return n, len(cfg.peers)
+	}
+
+	peers, err := p.getRememberedPeers(pctx, key)
+	if err != nil || len(peers) == 0 {
+		return 0, 0
+	}
+	p.count(&p.stats.peerDialAttempts, MetricPeerDialAttempts)
+
+	infos := make([]peer.AddrInfo, len(peers))
+	for i, rp := range peers {
+		infos[i] = rp.Info
+	}
+	results, n := p.dialPeers(pctx, key, infos)
+	if pctx.Err() != nil {
+		return n, 0
+	}
+
+	p.remembered.mx.Lock()
+	defer p.remembered.mx.Unlock()
+	// the peers may have been updated meanwhile
+	cur, err := p.getRememberedPeers(p.ctx, key)
+	if err != nil {
+		return n, 0
+	}
+	outcomes := make(map[peer.ID]int, len(peers))
+	for i, rp := range peers {
+		outcomes[rp.Info.ID] = results[i]
+	}
+	kept := cur[:0]
+	for _, rp := range cur {
+		switch outcomes[rp.Info.ID] {
+		case dialConnected:
+			rp.Failures = 0
+		case dialFailed:
+			if rp.Failures++; rp.Failures >= maxPeerFailures {
+				continue
+			}
+		}
+		kept = append(kept, rp)
+	}
+	if err := p.putRememberedPeers(p.ctx, key, kept); err != nil {
+		p.log.Debugf("PubsubPeers: error saving the peers of %s: %s", logKey(key), err)
+		p.reportError(key, OpStore, err)
+	}
+	return n, len(kept)
+}
+
+// dialPeers dials the peers not connected yet, a few at a time, until enough
+// of them are connected. It returns the dial outcome of each peer, and the
+// number of connected peers.
+func (p *PubsubValueStore) dialPeers(pctx context.Context, key string, peers []peer.AddrInfo) ([]int, int) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+	results := make([]int, len(peers))
+	var connected int32
+	done := func(i int) {
+		results[i] = dialConnected
+		if atomic.AddInt32(&connected, 1) >= int32(p.peerDialTarget) {
+			cancel()
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < p.maxConcurrentDials; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				info := peers[i]
+				dctx, dcancel := context.WithTimeout(ctx, rememberedDialTimeout)
+				p.host().Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+				err := p.host().Connect(dctx, info)
+				dcancel()
+				switch {
+				case err == nil:
+					done(i)
+				case ctx.Err() == nil:
+					p.log.Debugf("PubsubPeers: error dialing %s for %s: %s", info.ID, logKey(key), err)
+					results[i] = dialFailed
+				}
+			}
+		}()
+	}
+feed:
+	for i, info := range peers {
+		switch {
+		case info.ID == p.host().ID():
+			continue
+		case p.host().Network().Connectedness(info.ID) == network.Connected:
+			done(i)
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results, int(atomic.LoadInt32(&connected))
+}
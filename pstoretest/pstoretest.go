@@ -0,0 +1,122 @@
+// Package pstoretest provides helpers to test against PubsubValueStores
+// running on an in-process network.
+package pstoretest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	record "github.com/libp2p/go-libp2p-record"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+)
+
+// Validator is a record.Validator for the "namespace" namespace: the record of
+// "/namespace/<k>" is valid if it contains <k> but not "invalid", and the
+// greatest record wins.
+type Validator struct{}
+
+var _ record.Validator = Validator{}
+
+func (Validator) Validate(key string, value []byte) error {
+	ns, k, err := record.SplitKey(key)
+	if err != nil {
+		return err
+	}
+	if ns != "namespace" {
+		return record.ErrInvalidRecordType
+	}
+	if !bytes.Contains(value, []byte(k)) {
+		return record.ErrInvalidRecordType
+	}
+	if bytes.Contains(value, []byte("invalid")) {
+		return record.ErrInvalidRecordType
+	}
+	return nil
+}
+
+func (Validator) Select(key string, vals [][]byte) (int, error) {
+	if len(vals) == 0 {
+		return 0, record.ErrInvalidRecordType
+	}
+	idx := 0
+	for i, val := range vals {
+		if bytes.Compare(vals[idx], val) < 0 {
+			idx = i
+		}
+	}
+	return idx, nil
+}
+
+// NewTestNetwork returns n stores using a Validator over floodsub, each on
+// its own host. The first host is connected to all the others. The stores and
+// hosts are closed when the test ends.
+func NewTestNetwork(t *testing.T, n int, opts ...namesys.Option) []*namesys.PubsubValueStore {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hosts := make([]host.Host, n)
+	vss := make([]*namesys.PubsubValueStore, n)
+	for i := range hosts {
+		h := bhost.NewBlankHost(swarmt.GenSwarm(t))
+		t.Cleanup(func() { _ = h.Close() })
+		hosts[i] = h
+
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs, err := namesys.NewPubsubValueStore(ctx, h, fs, Validator{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = vs.Close() })
+		vss[i] = vs
+	}
+
+	for _, h := range hosts[1:] {
+		if err := h.Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return vss
+}
+
+// WaitForValue waits until the store returns want for the key, failing the
+// test if it doesn't within the timeout. It polls with exponential backoff.
+func WaitForValue(t testing.TB, vs routing.ValueStore, key string, want []byte, timeout time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	backoff := 10 * time.Millisecond
+	for {
+		val, err := vs.GetValue(ctx, key)
+		if err == nil && bytes.Equal(val, want) {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			if err != nil {
+				t.Fatalf("waiting for %q on %s: %s", want, key, err)
+			}
+			t.Fatalf("waiting for %q on %s: got %q", want, key, val)
+		}
+		if backoff *= 2; backoff > 500*time.Millisecond {
+			backoff = 500 * time.Millisecond
+		}
+	}
+}
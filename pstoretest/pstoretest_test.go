@@ -0,0 +1,25 @@
+package pstoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewTestNetwork(t *testing.T) {
+	vss := NewTestNetwork(t, 3)
+	key := "/namespace/key"
+	val := []byte("valid for key")
+
+	for _, vs := range vss[1:] {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vss[0].PutValue(context.Background(), key, val); err != nil {
+		t.Fatal(err)
+	}
+	for _, vs := range vss[1:] {
+		WaitForValue(t, vs, key, val, 10*time.Second)
+	}
+}
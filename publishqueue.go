@@ -0,0 +1,142 @@
+package namesys
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricPublishesDropped counts the queued records that failed to be
+// published before their maximum age, or were dropped by Cancel or Close.
+const MetricPublishesDropped = "publishes_dropped"
+
+// WithPublishQueue returns an option that queues the records put locally for
+// publishing, instead of publishing them before PutValue returns. Each key's
+// queued record is published in the background, retrying with exponential
+// backoff until it succeeds or maxAge has elapsed since the record was put;
+// a newer record of the key replaces the queued one.
+//
+// PutValue then only returns the errors of storing the record. Records still
+// queued when their key is canceled or the store is closed are dropped, Close
+// returning once they are. Disabled by default.
+func WithPublishQueue(maxAge time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if maxAge <= 0 {
+			return fmt.Errorf("invalid publish queue max age: %s", maxAge)
+		}
+		store.publishQueueAge = maxAge
+		return nil
+	}
+}
+
+// queuedPublish is the record queued for publishing for a key. Fields are
+// guarded by publishQueue.mx.
+type queuedPublish struct {
+	value  []byte
+	queued time.Time
+}
+
+type publishQueue struct {
+	mx   sync.Mutex
+	keys map[string]*queuedPublish
+	// running workers, waited for by Close
+	wg sync.WaitGroup
+}
+
+// queuePublish queues the record for publishing, replacing the record queued
+// for the key if any.
+func (p *PubsubValueStore) queuePublish(ti *topicInfo, key string, value []byte) {
+	p.queue.mx.Lock()
+	defer p.queue.mx.Unlock()
+	if q, ok := p.queue.keys[key]; ok {
+		q.value = value
+		q.queued = time.Now()
+		return
+	}
+	if p.queue.keys == nil {
+		p.queue.keys = make(map[string]*queuedPublish)
+	}
+	q := &queuedPublish{value: value, queued: time.Now()}
+	p.queue.keys[key] = q
+	atomic.AddInt64(&p.stats.queuedPublishes, 1)
+
+	p.queue.wg.Add(1)
+	go p.runPublishQueue(ti, key, q)
+}
+
+// runPublishQueue publishes the key's queued record until it succeeds, the
+// record gets too old, or the subscription ends.
+func (p *PubsubValueStore) runPublishQueue(ti *topicInfo, key string, q *queuedPublish) {
+	defer p.queue.wg.Done()
+
+	backoff := publishRetryMinBackoff
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			p.dropQueued(key, q)
+			return
+		}
+		if cur, ok := p.live.Load(key); !ok || cur != ti {
+			p.dropQueued(key, q)
+			return
+		}
+
+		p.queue.mx.Lock()
+		value := q.value
+		p.queue.mx.Unlock()
+		err := <-p.psPublishChannel(p.ctx, ti.topic, value)
+		if err == nil && p.publishRetry > 0 && len(ti.topic.ListPeers()) == 0 {
+			p.retryPublish(ti, key, value)
+		}
+
+		p.queue.mx.Lock()
+		switch {
+		case err == nil && bytes.Equal(q.value, value):
+			p.finishQueuedLocked(key, q)
+			p.queue.mx.Unlock()
+			return
+		case err == nil:
+			// a newer record was queued meanwhile
+			p.queue.mx.Unlock()
+			backoff = publishRetryMinBackoff
+			timer.Reset(0)
+			continue
+		case time.Since(q.queued) >= p.publishQueueAge:
+			p.finishQueuedLocked(key, q)
+			p.queue.mx.Unlock()
+			p.log.Debugf("PubsubPublish: giving up publishing %s: %s", logKey(key), err)
+			p.count(&p.stats.publishesDropped, MetricPublishesDropped)
+			p.reportError(key, OpPublish, err)
+			return
+		}
+		p.queue.mx.Unlock()
+		p.log.Debugf("PubsubPublish: error publishing %s, retrying in %s: %s", logKey(key), backoff, err)
+
+		timer.Reset(backoff)
+		if backoff *= 2; backoff > publishRetryMaxBackoff {
+			backoff = publishRetryMaxBackoff
+		}
+	}
+}
+
+// dropQueued drops the key's queued record, counting it.
+func (p *PubsubValueStore) dropQueued(key string, q *queuedPublish) {
+	p.queue.mx.Lock()
+	defer p.queue.mx.Unlock()
+	p.finishQueuedLocked(key, q)
+	p.count(&p.stats.publishesDropped, MetricPublishesDropped)
+}
+
+// finishQueuedLocked removes the key's queued record. Must be called with
+// publishQueue.mx held.
+func (p *PubsubValueStore) finishQueuedLocked(key string, q *queuedPublish) {
+	if p.queue.keys[key] == q {
+		delete(p.queue.keys, key)
+		atomic.AddInt64(&p.stats.queuedPublishes, -1)
+	}
+}
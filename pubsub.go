@@ -2,13 +2,19 @@ package namesys
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
@@ -18,15 +24,14 @@ import (
 
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
-	dshelp "github.com/ipfs/go-ipfs-ds-help"
-	logging "github.com/ipfs/go-log/v2"
 )
 
-var log = logging.Logger("pubsub-valuestore")
-
 // DefaultSubscriptionLifetime is the default lifetime for PubSub subscriptions.
 const DefaultSubscriptionLifetime = 365 * 24 * time.Hour
 
+// DefaultMaxRecordSize is the default maximum size of a record value.
+const DefaultMaxRecordSize = 64 << 10
+
 // Pubsub is the minimal subset of the pubsub interface required by the pubsub
 // value store. This way, users can wrap the underlying pubsub implementation
 // without re-exporting/implementing the entire interface.
@@ -35,205 +40,1077 @@ type Pubsub interface {
 	Join(topic string, opts ...pubsub.TopicOpt) (*pubsub.Topic, error)
 }
 
+// validatorUnregisterer is implemented by Pubsub implementations that can
+// unregister topic validators, such as *pubsub.PubSub.
+//
+// The validator of a key's topic is registered when the key gets subscribed
+// to, and unregistered when its subscription ends, however it ends. The
+// registration happens under the key lock before the subscription is added,
+// and the unregistration along with its removal, so they alternate. Pubsubs
+// that can't unregister validators keep the first one, which validates against
+// the store's current state anyway.
+type validatorUnregisterer interface {
+	UnregisterTopicValidator(topic string) error
+}
+
 type watchGroup struct {
 	// Note: this chan must be buffered, see notifyWatchers
-	listeners map[chan []byte]struct{}
+	// Maps each listener to the last value it was sent.
+	listeners     map[chan []byte][]byte
+	metaListeners map[chan ValueUpdate][]byte
+	callbacks     map[*valueCallback]struct{}
+	history       map[*historyListener]struct{}
+	// closed when the watchers are ended, see WithLifetime
+	ended chan struct{}
 }
 
-type PubsubValueStore struct {
-	ctx context.Context
-	ds  ds.Datastore
-	ps  Pubsub
+func newWatchGroup() *watchGroup {
+	return &watchGroup{
+		listeners:     map[chan []byte][]byte{},
+		metaListeners: map[chan ValueUpdate][]byte{},
+		callbacks:     map[*valueCallback]struct{}{},
+		history:       map[*historyListener]struct{}{},
+		ended:         make(chan struct{}),
+	}
+}
+
+func (wg *watchGroup) empty() bool {
+	return len(wg.listeners) == 0 && len(wg.metaListeners) == 0 && len(wg.callbacks) == 0 && len(wg.history) == 0
+}
+
+// ErrClosed is returned by operations on a closed PubsubValueStore.
+var ErrClosed = errors.New("pubsub value store closed")
+
+// ErrNotSubscribed is returned by Unsubscribe for keys not held by Subscribe,
+// and by the operations on a key whose subscription was canceled while they
+// were starting.
+var ErrNotSubscribed = errors.New("key not subscribed")
 
-	host  host.Host
-	fetch *fetchProtocol
+// ErrNoPeersFound is returned by SubscribeAndWait when no peer joined the
+// topic before the context ended.
+var ErrNoPeersFound = errors.New("no peers found for the topic")
+
+// ErrInvalidRecord matches the InvalidRecordError returned when publishing or
+// storing a record that fails validation, with errors.Is.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// InvalidRecordError is returned when publishing or storing a record that
+// fails validation, e.g. by PutValue or PutLocal. Reason is the validator's
+// error.
+type InvalidRecordError struct {
+	Reason error
+}
+
+func (e *InvalidRecordError) Error() string {
+	return fmt.Sprintf("invalid record: %s", e.Reason)
+}
+
+func (e *InvalidRecordError) Unwrap() error {
+	return e.Reason
+}
+
+// Is reports whether target is ErrInvalidRecord.
+func (e *InvalidRecordError) Is(target error) bool {
+	return target == ErrInvalidRecord
+}
+
+// ErrRecordTooLarge is returned when publishing a record larger than the
+// configured maximum record size.
+var ErrRecordTooLarge = errors.New("record too large")
+
+type PubsubValueStore struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	ds        ds.Datastore
+	records   RecordStore
+	// the *transport the store runs on, replaced by Reset
+	net atomic.Value
 
 	rebroadcastInitialDelay time.Duration
 	rebroadcastInterval     time.Duration
+	rebroadcastIntervalSet  bool
+	autoTune                bool
+	clock                   clock
 	unusedSubscriptionTTL   map[string]time.Duration
+	idleTimeout             time.Duration
+	maxRecordSize           int
+	maxKeySize              int
+	rateLimit               float64
+	rateBurst               int
+	historySize             int
+	fetchTimeout            time.Duration
+	publishRetry            time.Duration
+	publishQueueAge         time.Duration
+	joinRepublish           time.Duration
+	msgValidator            MessageValidator
+	namespaces              map[string]struct{}
+	merger                  Merger
+	envelopes               bool
+	loopback                bool
+	compression             bool
+	coalesceInterval        time.Duration
+	log                     Logger
+	onInvalidPeer           InvalidPeerFunc
+	passThrough             bool
+	dsPrefix                ds.Key
+	dsMigrate               bool
+	peerSnapshotInterval    time.Duration
+	maxConcurrentDials      int
+	maxRememberedPeers      int
+	peerDialTarget          int
+	maxSubscriptions        int
+	maxCachedRecords        int
+	topicPrefix             string
+	secondary               routing.ValueStore
+	persistSubscriptions    bool
+	// limits the concurrent fetches, nil if unlimited
+	fetchSem chan struct{}
+	// limits the concurrent validations of messages, nil if unlimited
+	validateSem chan struct{}
+	// topic validator limits, see WithValidationTimeout
+	validationTimeout          time.Duration
+	topicValidationConcurrency int
+	inlineValidation           bool
+	// fetches of the keys GetValue missed
+	missing missingFetches
+	// records waiting to be written, see WithWriteCoalescing
+	pending pendingWrites
+	// see WithRememberedPeers
+	remembered rememberedPeers
+	// invalid messages received per peer
+	invalid invalidPeers
+	// see WithMaxCachedRecords
+	cached cachedRecords
+
+	// serializes subscribing and unsubscribing per key, so that joining a
+	// topic doesn't hold mx
+	keyLocks keyLocks
+	// write locked by Reset while replacing the transport, read locked by
+	// subscribe before the key lock
+	resetMx  sync.RWMutex
+	throttle logThrottle
 
 	// Map of keys to topics
 	mx     sync.Mutex
 	topics map[string]*topicInfo
+	// keys of topics, most recently used first
+	lru *list.List
+	// live mirrors topics for the topic validator, which can't take mx: pubsub
+	// validates local publishes while holding the topic lock that closing the
+	// topic under mx waits for.
+	live sync.Map
 
 	watchLk  sync.Mutex
 	watching map[string]*watchGroup
 
+	retries publishRetries
+	queue   publishQueue
+	unsaved unsavedRecords
+	errs    storeErrors
+	keyErrs keyErrors
+
+	telemetry Telemetry
+	stats     stats
+
+	strictSigning bool
+	authorize     AuthorizeFunc
+	// the identity the pubsub signs our messages with if not the host's, see
+	// WithMessageAuthor
+	author peer.ID
+	// the identities PutValueAs signed records with
+	signers sync.Map
+
+	allowed    allowlists
+	bootstraps keyBootstraps
+
+	// Validator is the validator the store was constructed with.
+	//
+	// Deprecated: setting it races with incoming messages, use SetValidator
+	// and GetValidator instead. It is only used until SetValidator is called.
 	Validator record.Validator
+	// validator holds a validatorBox once SetValidator has been called
+	validator atomic.Value
+	// incremented by SetValidator, accessed atomically
+	validatorGen uint64
+}
+
+var (
+	_ routing.ValueStore = (*PubsubValueStore)(nil)
+	_ io.Closer          = (*PubsubValueStore)(nil)
+)
+
+// validatorBox lets validators of different dynamic types share an
+// atomic.Value.
+type validatorBox struct {
+	v record.Validator
+}
+
+// GetValidator returns the current validator.
+func (p *PubsubValueStore) GetValidator() record.Validator {
+	if b, ok := p.validator.Load().(validatorBox); ok {
+		return b.v
+	}
+	return p.Validator
+}
+
+// SetValidator replaces the validator. It is safe to call at any time: the
+// new validator applies to the records received and looked up after the call,
+// while the validations in progress complete with the previous one. v must
+// not be nil, see AcceptAllValidator.
+func (p *PubsubValueStore) SetValidator(v record.Validator) {
+	p.validator.Store(validatorBox{v})
+	atomic.AddUint64(&p.validatorGen, 1)
 }
 
 type topicInfo struct {
 	topic *pubsub.Topic
 	evts  *pubsub.TopicEventHandler
-	sub   *pubsub.Subscription
-	eol   time.Time
-
-	cancel   context.CancelFunc
+	// replaced when resubscribing, guarded by the store's mx
+	sub *pubsub.Subscription
+	// guarded by the store's mx
+	eol time.Time
+
+	cancel context.CancelFunc
+	// closed once the subscription loop and its helpers, counted by wg, are
+	// done
 	finished chan struct{}
+	wg       sync.WaitGroup
+
+	// records fetched because GetValue missed the key, see fetchMissing
+	fetched chan fetchedUpdate
+
+	// number of Subscribe calls not yet released with Unsubscribe, guarded by
+	// the store's mx
+	refs int
+
+	// nil if rate limiting is disabled
+	limiter *rateLimiter
+
+	// nil unless auto-tuning, see WithAutoTune
+	tuner *autoTuner
+	// the key's auto-tuned intervals, and the times of the last tuning,
+	// rebroadcast and discovery, owned by the rebroadcast loop
+	rebroadcastInterval time.Duration
+	discoveryInterval   time.Duration
+	lastTuned           time.Time
+	lastRebroadcast     time.Time
+	lastDiscovery       time.Time
+	// signals dialRemembered to dial the peers again, nil if it doesn't run
+	rediscover chan struct{}
+	// auto-tuned number of peers a missing record is fetched from, all of
+	// them if 0. Accessed atomically.
+	fetchFanout int32
+
+	// element of the store's lru, guarded by the store's mx
+	lru *list.Element
+
+	// see LastUpdate
+	last lastUpdates
+
+	// last republish for a joining peer, in Unix nanoseconds, accessed
+	// atomically
+	joinRepublished int64
 
 	dbWriteMx sync.Mutex
+	best      bestCache
 }
 
+// DefaultTopicPrefix is the prefix of the record topics, see WithTopicPrefix.
+const DefaultTopicPrefix = "/record/"
+
 // KeyToTopic converts a binary record key to a pubsub topic key.
 func KeyToTopic(key string) string {
+	return keyToTopic(DefaultTopicPrefix, key)
+}
+
+func keyToTopic(prefix, key string) string {
 	// Record-store keys are arbitrary binary. However, pubsub requires UTF-8 string topic IDs.
-	// Encodes to "/record/base64url(key)"
-	return "/record/" + base64.RawURLEncoding.EncodeToString([]byte(key))
+	// Encodes to "<prefix>base64url(key)"
+	return prefix + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// topicToKey converts a pubsub topic back to the binary record key.
+func topicToKey(prefix, topic string) (string, error) {
+	if !strings.HasPrefix(topic, prefix) {
+		return "", fmt.Errorf("not a record topic: %s", topic)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(topic[len(prefix):])
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
 }
 
 // Option is a function that configures a PubsubValueStore during initialization
 type Option func(*PubsubValueStore) error
 
 // NewPubsubValueStore constructs a new ValueStore that gets and receives records through pubsub.
+// The validator must not be nil, see AcceptAllValidator.
+// The records this node published before a restart over the same datastore
+// are republished, see AuthoredKeys.
 func NewPubsubValueStore(ctx context.Context, host host.Host, ps Pubsub, validator record.Validator, opts ...Option) (*PubsubValueStore, error) {
+	if validator == nil {
+		return nil, ErrNilValidator
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	psValueStore := &PubsubValueStore{
-		ctx: ctx,
+		ctx:    ctx,
+		cancel: cancel,
 
 		ds:                      dssync.MutexWrap(ds.NewMapDatastore()),
-		ps:                      ps,
-		host:                    host,
 		rebroadcastInitialDelay: 100 * time.Millisecond,
 		rebroadcastInterval:     time.Minute * 10,
+		clock:                   realClock{},
 		unusedSubscriptionTTL:   make(map[string]time.Duration),
+		maxRecordSize:           DefaultMaxRecordSize,
+		maxKeySize:              DefaultMaxKeySize,
+		rateLimit:               DefaultRateLimit,
+		rateBurst:               DefaultRateBurst,
+		fetchTimeout:            DefaultFetchTimeout,
+		topicPrefix:             DefaultTopicPrefix,
+		dsPrefix:                ds.NewKey(DefaultDatastorePrefix),
+		maxConcurrentDials:      DefaultMaxConcurrentDials,
+		maxRememberedPeers:      DefaultMaxRememberedPeers,
+		peerDialTarget:          DefaultPeerDialTarget,
 
 		topics:   make(map[string]*topicInfo),
+		lru:      list.New(),
 		watching: make(map[string]*watchGroup),
+		errs:     storeErrors{ch: make(chan StoreError, errorsBuffer)},
+
+		telemetry: NoopTelemetry{},
+		log:       log,
 
 		Validator: validator,
 	}
 
+	psValueStore.net.Store(&transport{host: host, ps: ps})
+
 	for _, opt := range opts {
 		err := opt(psValueStore)
 		if err != nil {
+			cancel()
 			return nil, err
 		}
 	}
 
-	psValueStore.fetch = newFetchProtocol(ctx, host, psValueStore.getLocal)
+	psValueStore.log = storeLogger(psValueStore.log, host.ID())
+
+	if err := psValueStore.prefixDatastore(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if psValueStore.envelopes && psValueStore.merger != nil {
+		cancel()
+		return nil, errors.New("envelopes can't be used with a merger")
+	}
+
+	if psValueStore.records == nil {
+		psValueStore.records = NewDatastoreRecordStore(psValueStore.ds)
+	}
+	if psValueStore.maxCachedRecords > 0 {
+		psValueStore.loadCachedRecords(ctx)
+	}
+
+	atomic.StoreInt64(&psValueStore.stats.rebroadcastInterval, int64(psValueStore.rebroadcastInterval))
+
+	psValueStore.net.Store(&transport{host: host, ps: ps, fetch: psValueStore.newFetchProtocol(host)})
+
+	// listed before any PutValue, whose keys aren't republished
+	authored, err := psValueStore.AuthoredKeys(ctx)
+	if err != nil {
+		psValueStore.log.Warnf("failed to list the authored keys: %s", err)
+	}
 
 	go psValueStore.rebroadcast(ctx)
+	go psValueStore.republishAuthored(ctx, authored)
+	if psValueStore.peerSnapshotInterval > 0 {
+		go psValueStore.snapshotPeers()
+	}
 
 	return psValueStore, nil
 }
 
-// PutValue publishes a record through pubsub
-func (p *PubsubValueStore) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
-	if err := p.Subscribe(key); err != nil {
+// PutValue publishes a record through pubsub, and writes it to the secondary
+// store if one was set with WithSecondaryStore. Records failing validation are
+// refused with an InvalidRecordError. Pass WithTTL for records that expire.
+//
+// PutValue returns with ctx's error once ctx ends, whether it was subscribing
+// to the key, waiting for the key's other writes, or publishing; the record
+// isn't stored if ctx ended before it could be. The bootstrap of a key it
+// subscribes to goes on in the background, as do retried or queued publishes.
+func (p *PubsubValueStore) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "PutValue")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if _, err := parseOptions(opts, false, skipSecondaryKey{}, ttlKey{}); err != nil {
+		return err
+	}
+	if err := p.checkTTL(opts); err != nil {
+		return err
+	}
+	if p.secondary != nil && !skipSecondary(opts) {
+		return p.putValueAndSecondary(ctx, key, value, opts)
+	}
+	return p.putValue(ctx, key, value, opts...)
+}
+
+func (p *PubsubValueStore) putValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	return p.putValueIf(ctx, key, nil, value, ttlOf(opts), nil)
+}
+
+// putValueIf is putValue, publishing the value only if match, when not nil,
+// accepts the key's current record, see PutValueIf. The record expires once
+// ttl is over if not 0, see WithTTL.
+func (p *PubsubValueStore) putValueIf(ctx context.Context, key string, match func(current []byte) bool, value []byte, ttl time.Duration, signKey crypto.PrivKey) error {
+	if err := p.checkKey(key); err != nil {
 		return err
 	}
 
-	log.Debugf("PubsubPublish: publish value for key %s", formatKey(key))
+	if p.oversized(value) {
+		return ErrRecordTooLarge
+	}
+
+	if err := p.GetValidator().Validate(key, value); err != nil {
+		return &InvalidRecordError{Reason: err}
+	}
+	from := p.authorID()
+	if signKey != nil {
+		var err error
+		if from, err = p.signer(signKey); err != nil {
+			return err
+		}
+	}
+	if !p.messageAllowed(ctx, key, value, from) {
+		return ErrRejected
+	}
+
+	if err := p.subscribeContext(ctx, key); err != nil {
+		return err
+	}
+
+	p.log.Debugf("PubsubPublish: publish value for key %s", logKey(key))
 
 	p.mx.Lock()
 	ti, ok := p.topics[key]
 	p.mx.Unlock()
 	if !ok {
-		return errors.New("could not find topic handle")
+		return ErrNotSubscribed
 	}
 
-	ti.dbWriteMx.Lock()
+	// held by the writes of the network too, which may take long, e.g. with
+	// a slow merger
+	if err := lockContext(ctx, &ti.dbWriteMx); err != nil {
+		return err
+	}
 	defer ti.dbWriteMx.Unlock()
-	recCmp, err := p.putLocal(ctx, ti, key, value)
+	if match != nil {
+		if err := p.checkCurrent(ctx, key, match); err != nil {
+			return err
+		}
+	}
+	value, err := p.mergeLocal(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	if p.envelopes {
+		if value, err = p.seal(ctx, key, value, ttl, from); err != nil {
+			return err
+		}
+		if p.oversized(value) {
+			return ErrRecordTooLarge
+		}
+	}
+	// not stored if the caller gave up meanwhile
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	meta := RecordMeta{From: from, Received: time.Now()}
+	recCmp, err := p.putLocal(ctx, ti, key, value, meta, nil)
 	if err != nil {
 		return err
 	}
 	if recCmp < 0 {
 		return nil
 	}
+	if recCmp > 0 {
+		ti.last.record(true, meta.From)
+		p.notifyWatchers(key, value, meta)
+	}
+	if signKey != nil {
+		return p.publishAs(ctx, ti, value, signKey)
+	}
+	p.markAuthored(ctx, key)
+	return p.publishLocal(ctx, ti, key, value)
+}
+
+// publishLocal publishes a record put locally, or queues it for publishing.
+func (p *PubsubValueStore) publishLocal(ctx context.Context, ti *topicInfo, key string, value []byte) (err error) {
+	_, span := p.telemetry.StartSpan(ctx, "Publish")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
 
+	if p.publishQueueAge > 0 {
+		span.SetAttributes(Attr("queued", "true"))
+		p.queuePublish(ti, key, value)
+		return nil
+	}
+	// stored, and rebroadcast, but not published now
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	select {
 	case err := <-p.psPublishChannel(ctx, ti.topic, value):
+		if err == nil && p.publishRetry > 0 && len(ti.topic.ListPeers()) == 0 {
+			p.retryPublish(ti, key, value)
+		}
 		return err
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 }
 
+// putValuesConcurrency is the maximum number of records PutValues publishes
+// concurrently.
+const putValuesConcurrency = 16
+
+// PutValuesError is returned by PutValues when some of the records could not
+// be published. It maps each failing key to its error.
+type PutValuesError map[string]error
+
+func (e PutValuesError) Error() string {
+	return fmt.Sprintf("failed to put %d values: %s", len(e), formatKeyErrors(e))
+}
+
+// formatKeyErrors formats per-key errors, sorted by key.
+func formatKeyErrors(errs map[string]error) string {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", formatKey(k), errs[k]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// PutValues publishes a batch of records through pubsub. Records are published
+// concurrently and independently of each other: if some of them fail, the
+// others are still published and a PutValuesError describing the failures is
+// returned.
+func (p *PubsubValueStore) PutValues(ctx context.Context, kvs map[string][]byte, opts ...routing.Option) error {
+	if _, err := parseOptions(opts, false, skipSecondaryKey{}, ttlKey{}); err != nil {
+		return err
+	}
+
+	var (
+		errsLk sync.Mutex
+		errs   = PutValuesError{}
+		wg     sync.WaitGroup
+		limit  = make(chan struct{}, putValuesConcurrency)
+	)
+
+	setErr := func(key string, err error) {
+		errsLk.Lock()
+		errs[key] = err
+		errsLk.Unlock()
+	}
+
+	for key, value := range kvs {
+		if err := p.GetValidator().Validate(key, value); err != nil {
+			setErr(key, &InvalidRecordError{Reason: err})
+			continue
+		}
+
+		select {
+		case limit <- struct{}{}:
+		case <-ctx.Done():
+			// don't start any more publishes
+			setErr(key, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(key string, value []byte) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			if err := p.PutValue(ctx, key, value, opts...); err != nil {
+				setErr(key, err)
+			}
+		}(key, value)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// verdict is the comparison of a valid record with the current one, as
+// returned by compareRecords.
+type verdict struct {
+	cmp int
+	// the current record compared with, nil if there was no valid one
+	prev []byte
+}
+
+// holds reports whether the verdict still applies with cur as the current
+// record, nil if there is none: whether it was reached against cur too.
+func (v *verdict) holds(cur []byte) bool {
+	return v != nil && bytes.Equal(cur, v.prev)
+}
+
 // compare compares the input value with the current value.
-// First return value is 0 if equal, greater than 0 if better, less than 0 if worse.
-// Second return value is true if valid.
-//
-func (p *PubsubValueStore) compare(ctx context.Context, key string, val []byte) (int, bool) {
-	if p.Validator.Validate(key, val) != nil {
-		return -1, false
+// The verdict's cmp is 0 if equal, greater than 0 if better, less than 0 if worse.
+// The error is the validation error if invalid.
+func (p *PubsubValueStore) compare(ctx context.Context, key string, val []byte) (verdict, error) {
+	validator := p.recordValidator()
+	if err := validator.Validate(key, val); err != nil {
+		return verdict{cmp: -1}, err
 	}
 
-	old, err := p.getLocal(ctx, key)
+	old, err := p.getLocalWith(ctx, validator, key)
 	if err != nil {
-		// If the old one is invalid, the new one is *always* better.
-		return 1, true
+		old = nil
+	}
+	return verdict{cmp: p.compareRecords(validator, key, val, old), prev: old}, nil
+}
+
+// compareRecords compares a valid record with the current one, nil if there is
+// no valid current record. The result is the same as compare's.
+func (p *PubsubValueStore) compareRecords(validator record.Validator, key string, val, old []byte) int {
+	// If the old one is invalid, the new one is *always* better.
+	if old == nil {
+		return 1
+	}
+	if p.merger != nil {
+		return p.mergeCompare(validator, key, val, old)
 	}
 
 	// Same record is not better
-	if old != nil && bytes.Equal(old, val) {
-		return 0, true
+	if bytes.Equal(old, val) {
+		return 0
 	}
 
-	i, err := p.Validator.Select(key, [][]byte{val, old})
-	if err == nil && i == 0 {
-		return 1, true
+	i, err := validator.Select(key, [][]byte{val, old})
+	if err != nil {
+		return -1
+	}
+	// A validator without a preference selects by position, which would
+	// keep whichever record arrived first or last depending on the node.
+	// Break the tie on the records themselves instead, the same way
+	// everywhere, unless the last writer wins.
+	j, err := validator.Select(key, [][]byte{old, val})
+	if err == nil && i == j {
+		if p.prefersCandidate() {
+			return 1
+		}
+		return bytes.Compare(val, old)
 	}
-	return -1, true
+	if i == 0 {
+		return 1
+	}
+	return -1
 }
 
-func (p *PubsubValueStore) Subscribe(key string) error {
-	p.mx.Lock()
-	defer p.mx.Unlock()
+// Subscribe subscribes to the key's topic, keeping its record up to date. The
+// subscription is reference counted: it is held until every Subscribe call has
+// been matched by an Unsubscribe call. Operations like GetValue and
+// SearchValue subscribe implicitly without holding the subscription, which is
+// then dropped once unused for the key's subscription lifetime. See
+// WithLifetime to bound the subscription to a context.
+func (p *PubsubValueStore) Subscribe(key string, opts ...routing.Option) error {
+	options, err := parseOptions(opts, false, lifetimeKey{}, noBootstrapKey{}, bootstrapPeersKey{})
+	if err != nil {
+		return err
+	}
+	return p.subscribeWith(context.Background(), key, true, options)
+}
 
-	// see if we already have a pubsub subscription; if not, subscribe
-	ti, ok := p.topics[key]
-	if ok {
-		// bump the EOL deadline
-		ttl, err := p.getTTLForKey(key)
+// subscribe subscribes to the key's topic unless already subscribed, and holds
+// the subscription if hold is set. ctx only carries the caller's trace, the
+// subscription outlives it.
+func (p *PubsubValueStore) subscribe(ctx context.Context, key string, hold bool) error {
+	p.resetMx.RLock()
+	defer p.resetMx.RUnlock()
+	return p.subscribeLocked(ctx, key, hold)
+}
+
+// subscribeLocked is subscribe, with p.resetMx held.
+func (p *PubsubValueStore) subscribeLocked(ctx context.Context, key string, hold bool) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "Subscribe")
+	defer func() {
 		if err != nil {
-			return err
+			span.RecordError(err)
 		}
-		ti.eol = time.Now().Add(ttl)
-		return nil
+		span.End()
+	}()
+
+	if err := p.checkKey(key); err != nil {
+		return err
 	}
 
-	topic := KeyToTopic(key)
+	unlock := p.keyLocks.lock(key)
+	defer unlock()
 
-	// Ignore the error. We have to check again anyways to make sure the
-	// record hasn't expired.
-	//
-	// Also, make sure to do this *before* subscribing.
-	myID := p.host.ID()
-	_ = p.ps.RegisterTopicValidator(topic, func(
-		ctx context.Context,
-		src peer.ID,
-		msg *pubsub.Message,
-	) pubsub.ValidationResult {
-		cmp, valid := p.compare(ctx, key, msg.GetData())
-		if !valid {
-			return pubsub.ValidationReject
-		}
+	// see if we already have a pubsub subscription; if not, subscribe
+	if ok, err := p.bumpSubscription(key, hold); ok || err != nil {
+		span.SetAttributes(Attr("subscription", "existing"))
+		return err
+	}
+	span.SetAttributes(Attr("subscription", "new"))
 
-		if cmp > 0 || cmp == 0 && src == myID {
-			return pubsub.ValidationAccept
-		}
-		return pubsub.ValidationIgnore
-	})
+	topic := keyToTopic(p.topicPrefix, key)
+
+	// Ignore the error, the validator is only left over from a previous
+	// subscription when the pubsub can't unregister it. We have to check
+	// again anyways to make sure the record hasn't expired.
+	//
+	// Also, make sure to do this *before* subscribing. The key lock makes
+	// this the only registration of the topic's validator.
+	_, join := p.telemetry.StartSpan(ctx, "JoinTopic")
+	_ = p.ps().RegisterTopicValidator(topic, p.validate, p.validatorOpts()...)
 
 	ti, err := p.createTopicHandler(topic, key)
 	if err != nil {
+		join.RecordError(err)
+		join.End()
+		p.unregisterValidator(key)
 		return err
 	}
+	join.End()
+	holds := 0
+	if hold {
+		holds = 1
+	}
+	p.persistSubscription(key, holds)
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	// Close doesn't wait for subscriptions in progress
+	if p.ctx.Err() != nil {
+		ti.sub.Cancel()
+		ti.evts.Cancel()
+		_ = ti.topic.Close()
+		p.unregisterValidator(key)
+		return ErrClosed
+	}
+	if !p.makeRoomLocked() {
+		ti.sub.Cancel()
+		ti.evts.Cancel()
+		_ = ti.topic.Close()
+		p.unregisterValidator(key)
+		return ErrTooManySubscriptions
+	}
 
+	if hold {
+		ti.refs++
+	}
 	p.topics[key] = ti
+	p.live.Store(key, ti)
+	ti.lru = p.lru.PushFront(key)
+	p.telemetry.SetGauge(MetricSubscriptions, float64(len(p.topics)))
 	ctx, cancel := context.WithCancel(p.ctx)
 	ti.cancel = cancel
 
 	go p.handleSubscription(ctx, ti, key)
+	if cfg := p.bootstrapFor(key); !cfg.skip && (p.peerSnapshotInterval > 0 || cfg.peers != nil) {
+		if ti.tuner != nil {
+			ti.rediscover = make(chan struct{}, 1)
+		}
+		ti.wg.Add(1)
+		go func() {
+			defer ti.wg.Done()
+			p.dialRemembered(ctx, ti, key)
+		}()
+	}
 
-	log.Debugf("PubsubResolve: subscribed to %s", formatKey(key))
+	p.log.Debugf("PubsubResolve: subscribed to %s", logKey(key))
 
 	return nil
 }
 
+// bumpSubscription bumps the EOL deadline of the key's subscription, and
+// reports whether there is one.
+func (p *PubsubValueStore) bumpSubscription(key string, hold bool) (bool, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.ctx.Err() != nil {
+		return false, ErrClosed
+	}
+
+	ti, ok := p.topics[key]
+	if !ok {
+		return false, nil
+	}
+	ttl, err := p.getTTLForKey(key)
+	if err != nil {
+		return true, err
+	}
+	ti.eol = time.Now().Add(ttl)
+	if hold {
+		ti.refs++
+		p.persistSubscription(key, ti.refs)
+	}
+	p.lru.MoveToFront(ti.lru)
+	return true, nil
+}
+
+// Unsubscribe releases a subscription held by Subscribe. Once all of them are
+// released, the subscription is torn down, unless the key is being watched in
+// which case it is dropped once unused for the key's subscription lifetime.
+func (p *PubsubValueStore) Unsubscribe(key string) error {
+	if err := p.validateKey(key); err != nil {
+		return err
+	}
+	unlock := p.keyLocks.lock(key)
+	defer unlock()
+
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	if !ok || ti.refs == 0 {
+		p.mx.Unlock()
+		return ErrNotSubscribed
+	}
+	ti.refs--
+	if ti.refs > 0 {
+		p.persistSubscription(key, ti.refs)
+		p.mx.Unlock()
+		return nil
+	}
+
+	p.watchLk.Lock()
+	_, watched := p.watching[key]
+	p.watchLk.Unlock()
+	if watched {
+		p.persistSubscription(key, 0)
+		ttl, _ := p.getTTLForKey(key)
+		ti.eol = time.Now().Add(ttl)
+		p.mx.Unlock()
+		return nil
+	}
+
+	p.closeTopic(key, ti)
+	p.mx.Unlock()
+
+	<-ti.finished
+	return nil
+}
+
+// topicEOL returns the EOL deadline of the subscription, and whether it is held
+// through Subscribe or by watchers.
+func (p *PubsubValueStore) topicEOL(ti *topicInfo, key string) (time.Time, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if ti.refs > 0 {
+		return ti.eol, true
+	}
+	p.watchLk.Lock()
+	_, watched := p.watching[key]
+	p.watchLk.Unlock()
+	return ti.eol, watched
+}
+
+// subscribeContext is like subscribe, but returns early if ctx ends while
+// joining the topic. The subscription completes in the background.
+func (p *PubsubValueStore) subscribeContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.subscribe(ctx, key, false)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SubscribeAndWait is like Subscribe, but blocks until at least one peer is
+// subscribed to the key's topic too, returning ErrNoPeersFound if none shows
+// up before ctx ends. The subscription is held in either case.
+func (p *PubsubValueStore) SubscribeAndWait(ctx context.Context, key string) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "SubscribeAndWait")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if err := p.subscribe(ctx, key, true); err != nil {
+		return err
+	}
+
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	// a new handler starts with a join event for every peer already there
+	evts, err := ti.topic.EventHandler()
+	if err != nil {
+		return err
+	}
+	defer evts.Cancel()
+
+	_, wait := p.telemetry.StartSpan(ctx, "WaitForPeers")
+	defer wait.End()
+
+	for {
+		evt, err := evts.NextPeerEvent(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ErrNoPeersFound
+			}
+			return err
+		}
+		if evt.Type == pubsub.PeerJoin {
+			return nil
+		}
+	}
+}
+
+// validate is the topic validator shared by all subscriptions. The key is
+// derived from the message topic at call time, so that registered validators
+// don't pin any per-key state after the key is canceled.
+func (p *PubsubValueStore) validate(ctx context.Context, src peer.ID, msg *pubsub.Message) (res pubsub.ValidationResult) {
+	key, err := topicToKey(p.topicPrefix, msg.GetTopic())
+	if err != nil {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	v, ok := p.live.Load(key)
+	if !ok {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+	ti := v.(*topicInfo)
+	defer func() {
+		if res == pubsub.ValidationReject {
+			ti.last.record(false, src)
+		}
+	}()
+
+	// our own message, relayed back to us; local publishes must go through
+	if src != p.host().ID() && p.echo(msg) {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "ignore"))
+		return pubsub.ValidationIgnore
+	}
+
+	if p.blacklisted(src) {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	if p.rateLimited(ti, src, publisher(src, msg)) {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	data, err := p.decompress(msg.GetData())
+	if err != nil {
+		p.invalidMessage(key, src, err)
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+	if p.oversized(data) {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	if err := p.checkSender(key, msg); err != nil {
+		p.invalidMessage(key, src, err)
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	if !p.publisherAllowed(key, msg.GetFrom()) {
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	if p.validateSem != nil {
+		select {
+		case p.validateSem <- struct{}{}:
+			defer func() { <-p.validateSem }()
+		case <-ctx.Done():
+			p.telemetry.IncCounter(MetricMessages, Attr("result", "ignore"))
+			return pubsub.ValidationIgnore
+		}
+	}
+
+	start := time.Now()
+	vd, err := p.compareWithin(ctx, key, data)
+	// an expired record may just be late, or our clocks apart
+	if err == errValidationTimeout || err == ErrRecordExpired {
+		p.log.Debugf("PubsubValidate: ignoring message for %s from %s: %s", logKey(key), src, err)
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "ignore"))
+		return pubsub.ValidationIgnore
+	}
+	if err != nil {
+		p.invalidMessage(key, src, err)
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+		return pubsub.ValidationReject
+	}
+
+	cmp := vd.cmp
+	if cmp > 0 || cmp == 0 && src == p.host().ID() {
+		if !p.messageAllowed(ctx, key, data, publisher(src, msg)) {
+			p.telemetry.IncCounter(MetricMessages, Attr("result", "reject"))
+			return pubsub.ValidationReject
+		}
+		// spare the subscription validating and comparing the record again
+		msg.ValidatorData = validRecord{data: data, verdict: vd, took: time.Since(start)}
+		p.telemetry.IncCounter(MetricMessages, Attr("result", "accept"))
+		return pubsub.ValidationAccept
+	}
+	if cmp == 0 {
+		p.count(&p.stats.duplicatesSuppressed, MetricDuplicates)
+	}
+	p.telemetry.IncCounter(MetricMessages, Attr("result", "ignore"))
+	return pubsub.ValidationIgnore
+}
+
+// validRecord is the ValidatorData of the messages whose record was found
+// valid by the topic validator, with the record decompressed and the
+// validator's verdict on it.
+type validRecord struct {
+	data    []byte
+	verdict verdict
+	// the time spent validating it
+	took time.Duration
+}
+
 // createTopicHandler creates an internal topic object. Must be called with p.mx held
 func (p *PubsubValueStore) createTopicHandler(topic string, key string) (*topicInfo, error) {
-	t, err := p.ps.Join(topic)
+	t, err := p.ps().Join(topic)
 	if err != nil {
 		return nil, err
 	}
@@ -261,6 +1138,13 @@ func (p *PubsubValueStore) createTopicHandler(topic string, key string) (*topicI
 		sub:      sub,
 		eol:      time.Now().Add(ttl),
 		finished: make(chan struct{}, 1),
+		fetched:  make(chan fetchedUpdate),
+	}
+	if p.rateLimit > 0 {
+		ti.limiter = newRateLimiter(p.rateLimit, p.rateBurst)
+	}
+	if p.autoTune {
+		ti.tuner = &autoTuner{}
 	}
 
 	return ti, nil
@@ -268,17 +1152,27 @@ func (p *PubsubValueStore) createTopicHandler(topic string, key string) (*topicI
 
 func (p *PubsubValueStore) rebroadcast(ctx context.Context) {
 	select {
-	case <-time.After(p.rebroadcastInitialDelay):
+	case <-p.clock.After(p.rebroadcastInitialDelay):
 	case <-ctx.Done():
 		return
 	}
 
-	ticker := time.NewTicker(p.rebroadcastInterval)
-	defer ticker.Stop()
+	// with auto-tuning, the keys are tuned every autoTunePeriod, and
+	// rebroadcast on their own intervals
+	tuning := p.autoTune
+	tick := p.rebroadcastInterval
+	if tuning {
+		tick = autoTunePeriod
+	}
+	ticks, stop := p.clock.NewTicker(tick)
+	defer stop()
+	lastRound := p.clock.Now()
 
 	for {
 		select {
-		case <-ticker.C:
+		case now := <-ticks:
+			since := lastRound
+			lastRound = now
 			p.mx.Lock()
 			keys := make([]string, 0, len(p.topics))
 			topics := make([]*topicInfo, 0, len(p.topics))
@@ -287,112 +1181,371 @@ func (p *PubsubValueStore) rebroadcast(ctx context.Context) {
 				topics = append(topics, ti)
 			}
 			p.mx.Unlock()
-			if len(topics) > 0 {
-				for i, k := range keys {
-					val, err := p.getLocal(ctx, k)
-					if err == nil {
-						topic := topics[i].topic
-						select {
-						case <-p.psPublishChannel(ctx, topic, val):
-						case <-ctx.Done():
-							return
+
+			shortest := p.rebroadcastInterval
+			discovery := time.Duration(0)
+			fanout := int32(0)
+			for i, k := range keys {
+				keySince := since
+				if tuning {
+					due, last := p.tuneKey(now, k, topics[i])
+					if topics[i].rebroadcastInterval < shortest || i == 0 {
+						shortest = topics[i].rebroadcastInterval
+					}
+					if topics[i].discoveryInterval < discovery || i == 0 {
+						discovery = topics[i].discoveryInterval
+					}
+					if n := atomic.LoadInt32(&topics[i].fetchFanout); n > fanout {
+						fanout = n
+					}
+					if !due {
+						continue
+					}
+					keySince = last
+				}
+				// already republished for a joining peer
+				if topics[i].joinRepublishedSince(keySince) {
+					continue
+				}
+				p.dropExpired(ctx, topics[i], k)
+				val, err := p.getLocal(ctx, k)
+				if err == nil {
+					topic := topics[i].topic
+					select {
+					case err := <-p.psPublishChannel(ctx, topic, val):
+						if err != nil && ctx.Err() == nil {
+							p.reportError(k, OpPublish, err)
 						}
+					case <-ctx.Done():
+						return
 					}
 				}
 			}
+			if tuning {
+				if !p.rebroadcastIntervalSet {
+					atomic.StoreInt64(&p.stats.rebroadcastInterval, int64(shortest))
+				}
+				atomic.StoreInt64(&p.stats.discoveryInterval, int64(discovery))
+				atomic.StoreInt64(&p.stats.fetchFanout, int64(fanout))
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (p *PubsubValueStore) psPublishChannel(ctx context.Context, topic *pubsub.Topic, value []byte) chan error {
-	done := make(chan error, 1)
-	go func() {
-		done <- topic.Publish(ctx, value)
-	}()
-	return done
+func (p *PubsubValueStore) psPublishChannel(ctx context.Context, topic *pubsub.Topic, value []byte) chan error {
+	done := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		err := topic.Publish(ctx, p.compress(value))
+		p.telemetry.ObserveHistogram(MetricPublishDuration, time.Since(start).Seconds())
+		if err != nil {
+			p.telemetry.IncCounter(MetricPublishes, Attr("result", "error"))
+		} else {
+			p.telemetry.IncCounter(MetricPublishes, Attr("result", "ok"))
+		}
+		done <- err
+	}()
+	return done
+}
+
+// putLocal tries to put the key-value pair, along with its metadata, into the
+// local datastore. The value isn't validated again if checked, the topic
+// validator's verdict on it, is set, nor compared again if the verdict still
+// holds.
+// Requires that the ti.dbWriteMx is held when called
+// Returns true if the value is better then what is currently in the datastore
+// Returns any errors from putting the data in the datastore
+func (p *PubsubValueStore) putLocal(ctx context.Context, ti *topicInfo, key string, value []byte, meta RecordMeta, checked *verdict) (int, error) {
+	if p.passThrough {
+		if checked == nil && p.recordValidator().Validate(key, value) != nil {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	if p.coalesceInterval > 0 {
+		return p.putPending(ctx, key, value, meta, checked)
+	}
+	return p.writeLocal(ctx, ti, key, value, meta, checked)
+}
+
+// writeLocal is putLocal without write coalescing. The retries of a failed
+// write keep ti.dbWriteMx, for the caller's checks to still hold when one
+// succeeds, and stop with the context.
+func (p *PubsubValueStore) writeLocal(ctx context.Context, ti *topicInfo, key string, value []byte, meta RecordMeta, checked *verdict) (int, error) {
+	validator := p.recordValidator()
+	if checked == nil && validator.Validate(key, value) != nil {
+		return -1, nil
+	}
+
+	cmp := -1
+	var unsaved []byte
+	put := func() (bool, error) {
+		return p.records.PutIfBetter(ctx, key, value, func(old, val []byte) bool {
+			// a record that failed to be written is the one to beat
+			if unsaved = p.unsaved.get(key); unsaved != nil {
+				old = unsaved
+			}
+			if checked.holds(old) {
+				cmp = checked.cmp
+				return cmp > 0
+			}
+			if unsaved == nil && old != nil && p.validateBest(validator, key, old) != nil {
+				old = nil
+			}
+			cmp = p.compareRecords(validator, key, val, old)
+			return cmp > 0
+		})
+	}
+	written := p.cacheWriting(ctx, key)
+	stored, err := put()
+	for i := 1; err != nil && cmp > 0 && i < dsPutAttempts; i++ {
+		if !backoffWrite(ctx, i) {
+			break
+		}
+		stored, err = put()
+	}
+	written(err == nil && stored)
+	if err != nil {
+		if cmp > 0 {
+			p.keepUnsaved(key, value)
+		}
+		return cmp, err
+	}
+	if !stored {
+		return cmp, nil
+	}
+	p.unsaved.drop(key, unsaved)
+	p.bestWritten(key, value)
+	if err := p.putMeta(ctx, key, meta); err != nil {
+		return cmp, err
+	}
+	return cmp, p.appendHistory(ctx, key, value)
+}
+
+func (p *PubsubValueStore) getLocal(ctx context.Context, key string) ([]byte, error) {
+	return p.getLocalWith(ctx, p.recordValidator(), key)
 }
 
-// putLocal tries to put the key-value pair into the local datastore
-// Requires that the ti.dbWriteMx is held when called
-// Returns true if the value is better then what is currently in the datastore
-// Returns any errors from putting the data in the datastore
-func (p *PubsubValueStore) putLocal(ctx context.Context, ti *topicInfo, key string, value []byte) (int, error) {
-	cmp, valid := p.compare(ctx, key, value)
-	if valid && cmp > 0 {
-		return cmp, p.ds.Put(ctx, dshelp.NewKeyFromBinary([]byte(key)), value)
+// getStored returns the key's record without validating it: the one held in
+// memory until it is written, if any, or else the one of the record store.
+func (p *PubsubValueStore) getStored(ctx context.Context, key string) ([]byte, error) {
+	if p.passThrough {
+		return nil, routing.ErrNotFound
 	}
-	return cmp, nil
+	if val := p.pending.get(key); val != nil {
+		return val, nil
+	}
+	if val := p.unsaved.get(key); val != nil {
+		return val, nil
+	}
+	return p.getBest(ctx, key)
 }
 
-func (p *PubsubValueStore) getLocal(ctx context.Context, key string) ([]byte, error) {
-	val, err := p.ds.Get(ctx, dshelp.NewKeyFromBinary([]byte(key)))
+func (p *PubsubValueStore) getLocalWith(ctx context.Context, validator record.Validator, key string) ([]byte, error) {
+	val, err := p.getStored(ctx, key)
 	if err != nil {
-		// Don't invalidate due to ds errors.
-		if err == ds.ErrNotFound {
-			err = routing.ErrNotFound
-		}
 		return nil, err
 	}
 
 	// If the old one is invalid, the new one is *always* better.
-	if err := p.Validator.Validate(key, val); err != nil {
+	if err := p.validateBest(validator, key, val); err != nil {
+		if err == ErrRecordExpired {
+			return nil, routing.ErrNotFound
+		}
 		return nil, err
 	}
+	p.touchCached(key)
 	return val, nil
 }
 
-func (p *PubsubValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
-	if err := p.Subscribe(key); err != nil {
+// GetValue returns the best known record of the key, subscribing to the key
+// so that later calls see its updates. If there is no local record, it is
+// fetched from the topic's peers, waiting for it until ctx's deadline if any.
+// With the routing.Offline option, it only looks at the local records and
+// doesn't subscribe.
+func (p *PubsubValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) (_ []byte, err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "GetValue")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	options, err := parseOptions(opts, true, lifetimeKey{}, noBootstrapKey{}, bootstrapPeersKey{})
+	if err != nil {
+		return nil, err
+	}
+	if options.Offline {
+		if err := p.checkKey(key); err != nil {
+			return nil, err
+		}
+	} else if err := p.subscribeWith(ctx, key, false, options); err != nil {
 		return nil, err
 	}
 
-	return p.getLocal(ctx, key)
+	val, err := p.getLocal(ctx, key)
+	if err == routing.ErrNotFound && !options.Offline {
+		span.SetAttributes(Attr("cache", "miss"))
+		_, wait := p.telemetry.StartSpan(ctx, "WaitForValue")
+		defer wait.End()
+		return p.solicit(ctx, key)
+	}
+	if err == nil {
+		span.SetAttributes(Attr("cache", "hit"))
+	} else {
+		span.SetAttributes(Attr("cache", "miss"))
+	}
+	return p.open(val), err
 }
 
 func (p *PubsubValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
-	if err := p.Subscribe(key); err != nil {
+	out, _, err := p.SearchValueWithCancel(ctx, key, opts...)
+	return out, err
+}
+
+// SearchValueWithCancel is like SearchValue, but also returns a function that
+// detaches this search only: its channel is closed, while other searches and
+// the subscription to the key are left untouched. The function returns once
+// the channel is closed, and may be called multiple times.
+func (p *PubsubValueStore) SearchValueWithCancel(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, func(), error) {
+	s, err := p.Search(ctx, key, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Values(), s.Cancel, nil
+}
+
+// ErrSearchCanceled is the Err of a Search ended by its Cancel method.
+var ErrSearchCanceled = errors.New("search canceled")
+
+// Search is a search started by PubsubValueStore.Search.
+type Search struct {
+	out  chan []byte
+	stop func()
+	done chan struct{}
+	// set before out is closed
+	err error
+}
+
+// Values returns the channel of the search, which receives the record of the
+// key once found and is then closed, like the one of SearchValue. With
+// WithFullHistory, it receives every accepted record until the search ends,
+// and with WithMaxResults or WithStopWhen until the limit is reached.
+func (s *Search) Values() <-chan []byte {
+	return s.out
+}
+
+// Cancel ends the search. It returns once the Values channel is closed, and
+// may be called multiple times.
+func (s *Search) Cancel() {
+	s.stop()
+	<-s.done
+}
+
+// Err returns why the search ended, once the Values channel is closed: nil if
+// the record was found or the search's limit reached, the context's error if
+// it ended first, ErrClosed if the store was closed, or ErrSearchCanceled if
+// Cancel was called.
+func (s *Search) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Search is like SearchValue, but returns a Search reporting why it ended.
+//
+// Its trace span lasts until the first record is delivered, or the search
+// ends without one; streaming searches only trace their start.
+func (p *PubsubValueStore) Search(ctx context.Context, key string, opts ...routing.Option) (_ *Search, err error) {
+	// ended here unless handed over to the search
+	spanCtx, span := p.telemetry.StartSpan(ctx, "SearchValue")
+	handedOver := false
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		if !handedOver {
+			span.End()
+		}
+	}()
+
+	options, err := parseOptions(opts, false, fullHistoryKey{}, lifetimeKey{}, maxResultsKey{}, stopWhenKey{}, noBootstrapKey{}, bootstrapPeersKey{})
+	if err != nil {
 		return nil, err
 	}
+	if err := p.subscribeWith(spanCtx, key, false, options); err != nil {
+		return nil, err
+	}
+	h, full := options.Other[fullHistoryKey{}].(fullHistory)
+	limits, limited := searchLimitsOf(options)
+	if full || limited {
+		span.SetAttributes(Attr("mode", "stream"))
+	}
+	if limited {
+		if !full {
+			h = fullHistory{buffer: 1, overflow: OverflowDropOldest}
+		}
+		inner, err := p.searchHistory(ctx, key, h)
+		if err != nil {
+			return nil, err
+		}
+		return limitSearch(inner, limits), nil
+	}
+	if full {
+		return p.searchHistory(ctx, key, h)
+	}
 
+	// hold watchLk from the read until the listener is added: the records
+	// accepted meanwhile are notified to it after that
 	p.watchLk.Lock()
 	defer p.watchLk.Unlock()
 
-	out := make(chan []byte, 1)
+	s := &Search{
+		out:  make(chan []byte, 1),
+		stop: func() {},
+		done: make(chan struct{}),
+	}
 	lv, err := p.getLocal(ctx, key)
 	if err == nil {
-		out <- lv
-		close(out)
-		return out, nil
+		span.SetAttributes(Attr("cache", "hit"))
+		s.out <- p.open(lv)
+		close(s.out)
+		close(s.done)
+		return s, nil
 	}
+	span.SetAttributes(Attr("cache", "miss"))
+	handedOver = true
 
-	wg, ok := p.watching[key]
-	if !ok {
-		wg = &watchGroup{
-			listeners: map[chan []byte]struct{}{},
-		}
-		p.watching[key] = wg
-	}
+	wg := p.watchGroupLocked(key)
 
 	proxy := make(chan []byte, 1)
+	wg.listeners[proxy] = nil
 
-	ctx, cancel := context.WithCancel(ctx)
-	wg.listeners[proxy] = struct{}{}
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	s.stop = func() {
+		stopOnce.Do(func() { close(stop) })
+	}
 
 	go func() {
+		defer close(s.done)
 		defer func() {
-			cancel()
-
 			p.watchLk.Lock()
 			delete(wg.listeners, proxy)
-
-			if _, ok := p.watching[key]; len(wg.listeners) == 0 && ok {
-				delete(p.watching, key)
-			}
+			p.dropWatchGroupLocked(key, wg)
 			p.watchLk.Unlock()
 
-			close(out)
+			close(s.out)
+			if s.err != nil {
+				span.RecordError(s.err)
+			}
+			span.End()
 		}()
 
 		for {
@@ -404,23 +1557,36 @@ func (p *PubsubValueStore) SearchValue(ctx context.Context, key string, opts ...
 
 				// outCh is buffered, so we just put the value or swap it for the newer one
 				select {
-				case out <- val:
-				case <-out:
-					out <- val
+				case s.out <- val:
+				case <-s.out:
+					s.out <- val
 				}
 
 				// 1 is good enough
 				return
 			case <-ctx.Done():
+				s.err = ctx.Err()
+				return
+			case <-p.ctx.Done():
+				s.err = ErrClosed
+				return
+			case <-wg.ended:
+				s.err = ErrSubscriptionEnded
+				return
+			case <-stop:
+				s.err = ErrSearchCanceled
 				return
 			}
 		}
 	}()
 
-	return out, nil
+	return s, nil
 }
 
-// GetSubscriptions retrieves a list of active topic subscriptions
+// GetSubscriptions retrieves a list of active topic subscriptions, sorted by
+// key. It includes both the subscriptions held through Subscribe and the
+// implicit ones, e.g. made by PutValue or GetValue; see SubscriptionsWithState
+// to tell them apart.
 func (p *PubsubValueStore) GetSubscriptions() []string {
 	p.mx.Lock()
 	defer p.mx.Unlock()
@@ -429,19 +1595,33 @@ func (p *PubsubValueStore) GetSubscriptions() []string {
 	for sub := range p.topics {
 		res = append(res, sub)
 	}
+	sort.Strings(res)
 
 	return res
 }
 
 // Cancel cancels a topic subscription; returns true if an active
 // subscription was canceled
+//
+// Cancel tears the subscription down even if it is still held through
+// Subscribe; use Unsubscribe to only release one's own hold.
+//
+// Cancel returns once the subscription's goroutines are done: no record of
+// the network is stored for the key afterwards. It must not be called from the
+// validator or the merger, which run on those goroutines. Watcher callbacks
+// run on their own, and may cancel their key once unregistered.
 func (p *PubsubValueStore) Cancel(name string) (bool, error) {
-	p.mx.Lock()
-	defer p.mx.Unlock()
+	if err := p.validateKey(name); err != nil {
+		return false, err
+	}
+	unlock := p.keyLocks.lock(name)
+	defer unlock()
 
+	p.mx.Lock()
 	p.watchLk.Lock()
 	if _, wok := p.watching[name]; wok {
 		p.watchLk.Unlock()
+		p.mx.Unlock()
 		return false, fmt.Errorf("key has active subscriptions")
 	}
 	p.watchLk.Unlock()
@@ -449,43 +1629,105 @@ func (p *PubsubValueStore) Cancel(name string) (bool, error) {
 	ti, ok := p.topics[name]
 	if ok {
 		p.closeTopic(name, ti)
-		<-ti.finished
 	}
+	p.mx.Unlock()
 
+	if ok {
+		<-ti.finished
+	}
+	p.flushPending(p.ctx, name)
 	return ok, nil
 }
 
-// closeTopic must be called under the PubSubValueStore's mutex
+// Close cancels all subscriptions and stops the store. It returns once every
+// subscription has been torn down and its topic validator unregistered; no
+// record is stored and no watcher is notified afterwards.
+func (p *PubsubValueStore) Close() error {
+	p.closeOnce.Do(func() {
+		// stop all the subscription loops at once, rather than one by one
+		p.cancel()
+		p.host().RemoveStreamHandler(FetchProtoID)
+
+		p.mx.Lock()
+		tis := make([]*topicInfo, 0, len(p.topics))
+		for k, ti := range p.topics {
+			p.closeTopic(k, ti)
+			tis = append(tis, ti)
+		}
+		p.mx.Unlock()
+
+		for _, ti := range tis {
+			<-ti.finished
+		}
+		p.queue.wg.Wait()
+		p.flushAllPending(context.Background())
+		p.closeErrors()
+	})
+	return nil
+}
+
+// closeTopic must be called under the PubSubValueStore's mutex. It's a no-op
+// if the topic was already closed, e.g. by Cancel.
 func (p *PubsubValueStore) closeTopic(key string, ti *topicInfo) {
+	if cur, ok := p.topics[key]; !ok || cur != ti {
+		return
+	}
+
 	ti.cancel()
 	ti.sub.Cancel()
 	ti.evts.Cancel()
 	_ = ti.topic.Close()
+	p.unregisterValidator(key)
 	delete(p.topics, key)
+	p.live.Delete(key)
+	p.lru.Remove(ti.lru)
+	p.forgetSubscription(key)
+	p.throttle.forget(key)
+	p.telemetry.SetGauge(MetricSubscriptions, float64(len(p.topics)))
 
-	log.Debugf("PubsubResolve: closeTopic %s", formatKey(key))
+	p.log.Debugf("PubsubResolve: closeTopic %s", logKey(key))
+}
+
+// unregisterValidator unregisters the validator of the key's topic, if the
+// pubsub supports it.
+func (p *PubsubValueStore) unregisterValidator(key string) {
+	if u, ok := p.ps().(validatorUnregisterer); ok {
+		_ = u.UnregisterTopicValidator(keyToTopic(p.topicPrefix, key))
+	}
 }
 
 func (p *PubsubValueStore) handleSubscription(ctx context.Context, ti *topicInfo, key string) {
 	defer func() {
-		close(ti.finished)
-
 		p.mx.Lock()
-		defer p.mx.Unlock()
-
 		p.closeTopic(key, ti)
+		p.mx.Unlock()
+
+		// the helpers stop with ctx, canceled by closeTopic
+		ti.wg.Wait()
+		close(ti.finished)
 	}()
 
-	newMsg := make(chan []byte)
+	ti.wg.Add(3)
+	newMsg := make(chan update)
 	go func() {
+		defer ti.wg.Done()
 		defer close(newMsg)
+		sub := ti.sub
 		for {
-			data, err := p.handleNewMsgs(ctx, ti.sub, key)
+			u, err := p.handleNewMsgs(ctx, sub, key)
 			if err != nil {
-				return
+				if ctx.Err() != nil {
+					return
+				}
+				// the subscription died under us, keep the topic and
+				// its watchers while getting a new one
+				if sub = p.resubscribe(ctx, ti, key); sub == nil {
+					return
+				}
+				continue
 			}
 			select {
-			case newMsg <- data:
+			case newMsg <- u:
 			case <-ctx.Done():
 				return
 			}
@@ -494,19 +1736,31 @@ func (p *PubsubValueStore) handleSubscription(ctx context.Context, ti *topicInfo
 
 	eol := make(chan bool)
 	go func() {
+		defer ti.wg.Done()
 		defer close(eol)
-		timer := time.NewTimer(time.Until(ti.eol))
+		deadline, _ := p.topicEOL(ti, key)
+		timer := time.NewTimer(time.Until(deadline))
 		defer timer.Stop()
 		for {
 			select {
 			case <-timer.C:
+				deadline, held := p.topicEOL(ti, key)
 				// before-or-now
-				if !ti.eol.After(time.Now()) {
-					eol <- true
-					return
+				if !deadline.After(time.Now()) {
+					if !held {
+						select {
+						case eol <- true:
+						case <-ctx.Done():
+						}
+						return
+					}
+					// held, check again in a lifetime
+					ttl, _ := p.getTTLForKey(key)
+					timer.Reset(ttl)
+					continue
 				}
 				// EOL deadline changed in the meantime
-				timer.Reset(time.Until(ti.eol))
+				timer.Reset(time.Until(deadline))
 			case <-ctx.Done():
 				return
 			}
@@ -514,15 +1768,16 @@ func (p *PubsubValueStore) handleSubscription(ctx context.Context, ti *topicInfo
 		}
 	}()
 
-	newPeerData := make(chan []byte)
+	newPeerData := make(chan update)
 	go func() {
+		defer ti.wg.Done()
 		defer close(newPeerData)
 		for {
-			data, err := p.handleNewPeer(ctx, ti.evts, key)
+			u, err := p.handleNewPeer(ctx, ti, key)
 			if err == nil {
-				if data != nil {
+				if u.data != nil {
 					select {
-					case newPeerData <- data:
+					case newPeerData <- u:
 					case <-ctx.Done():
 						return
 					}
@@ -532,110 +1787,317 @@ func (p *PubsubValueStore) handleSubscription(ctx context.Context, ti *topicInfo
 				case <-ctx.Done():
 					return
 				default:
-					log.Errorf("PubsubPeerJoin: error interacting with new peer: %s", err)
+					p.log.Debugf("PubsubPeerJoin: error interacting with new peer: %s", err)
+					p.reportError(key, OpFetch, err)
 				}
 			}
 		}
 	}()
 
 	for {
-		var data []byte
+		var u update
 		var ok bool
 		select {
-		case data, ok = <-newMsg:
+		case u, ok = <-newMsg:
 			if !ok {
 				return
 			}
-		case data, ok = <-newPeerData:
+		case u, ok = <-newPeerData:
 			if !ok {
 				return
 			}
+		case f := <-ti.fetched:
+			p.handleUpdate(ctx, ti, key, f.update)
+			close(f.processed)
+			continue
 		case <-eol:
-			log.Debugf("PubsubResolve: EOL %s", formatKey(key))
+			p.log.Debugf("PubsubResolve: EOL %s", logKey(key))
 			return
 		case <-ctx.Done():
 			return
 		}
 
-		ti.dbWriteMx.Lock()
-		recCmp, err := p.putLocal(ctx, ti, key, data)
+		p.handleUpdate(ctx, ti, key, u)
+	}
+}
+
+// handleUpdate stores a record received from the network if it's better than
+// ours, and notifies the key's watchers.
+func (p *PubsubValueStore) handleUpdate(ctx context.Context, ti *topicInfo, key string, u update) {
+	_, span := p.telemetry.StartSpan(ctx, "HandleUpdate")
+	defer span.End()
+	if u.checked != nil {
+		addTimedEvent(span, "validated", u.validation)
+	}
+
+	data := u.data
+	if p.oversized(data) {
+		return
+	}
+
+	start := time.Now()
+	ti.dbWriteMx.Lock()
+	merged, err := p.mergeLocal(ctx, key, data)
+	if err != nil {
 		ti.dbWriteMx.Unlock()
-		if recCmp > 0 {
-			if err != nil {
-				log.Warnf("PubsubResolve: error writing update for %s: %s", formatKey(key), err)
+		p.log.Debugf("PubsubResolve: error merging update for %s: %s", logKey(key), err)
+		return
+	}
+	checked := u.checked
+	if !bytes.Equal(merged, data) {
+		checked = nil
+	}
+	recCmp, err := p.putLocal(ctx, ti, key, merged, u.meta, checked)
+	ti.dbWriteMx.Unlock()
+	addTimedEvent(span, "stored", time.Since(start))
+	if err != nil {
+		span.RecordError(err)
+	}
+	if recCmp > 0 && !bytes.Equal(merged, data) {
+		p.publishMerged(ctx, ti, key, merged)
+	}
+	data = merged
+	if recCmp == 0 {
+		// identical to what we already have, don't store or notify again
+		p.count(&p.stats.duplicatesSuppressed, MetricDuplicates)
+	}
+	if recCmp > 0 {
+		if err != nil {
+			p.warnf(key, logStore, "PubsubResolve: error writing update for %s: %s", logKey(key), err)
+			p.reportError(key, OpStore, err)
+		}
+		if ti.tuner != nil {
+			ti.tuner.observeUpdate(p.clock.Now())
+		}
+		ti.last.record(true, u.meta.From)
+		p.notifyWatchers(key, data, u.meta)
+	}
+}
+
+// update is a record received from the network.
+type update struct {
+	data []byte
+	meta RecordMeta
+	// the topic validator's verdict, nil if the record wasn't validated yet
+	checked *verdict
+	// the time spent validating it, if validated
+	validation time.Duration
+}
+
+func (p *PubsubValueStore) handleNewMsgs(ctx context.Context, sub *pubsub.Subscription, key string) (update, error) {
+	var msg *pubsub.Message
+	for {
+		var err error
+		msg, err = sub.Next(ctx)
+		if err != nil {
+			if err != context.Canceled {
+				p.warnf(key, logSubscription, "PubsubResolve: subscription error in %s: %s", logKey(key), err)
+			}
+			if ctx.Err() == nil {
+				p.reportError(key, OpSubscribe, err)
 			}
-			p.notifyWatchers(key, data)
+			return update{}, err
+		}
+		if !p.echo(msg) {
+			break
 		}
 	}
+	return p.msgUpdate(msg), nil
 }
 
-func (p *PubsubValueStore) handleNewMsgs(ctx context.Context, sub *pubsub.Subscription, key string) ([]byte, error) {
-	msg, err := sub.Next(ctx)
-	if err != nil {
-		if err != context.Canceled {
-			log.Warnf("PubsubResolve: subscription error in %s: %s", formatKey(key), err.Error())
+// msgUpdate returns the update carried by a pubsub message.
+func (p *PubsubValueStore) msgUpdate(msg *pubsub.Message) update {
+	v, validated := msg.ValidatorData.(validRecord)
+	data := v.data
+	if !validated {
+		var err error
+		// a corrupt value fails validation as is
+		if data, err = p.decompress(msg.GetData()); err != nil {
+			data = msg.GetData()
+		}
+	}
+	u := update{
+		data:       data,
+		meta:       RecordMeta{From: publisher(msg.ReceivedFrom, msg), Seqno: msg.GetSeqno(), Received: time.Now()},
+		validation: v.took,
+	}
+	if validated {
+		u.checked = &v.verdict
+	}
+	return u
+}
+
+// echo reports whether msg is one of our own messages that should be skipped,
+// counting it if so.
+func (p *PubsubValueStore) echo(msg *pubsub.Message) bool {
+	if p.loopback || msg.GetFrom() != p.host().ID() {
+		return false
+	}
+	p.count(&p.stats.echoesSuppressed, MetricEchoes)
+	return true
+}
+
+// limitedFetch fetches the key's record from the peer once a fetch slot is
+// available.
+func (p *PubsubValueStore) limitedFetch(ctx context.Context, pid peer.ID, key string) ([]byte, error) {
+	if p.fetchSem != nil {
+		select {
+		case p.fetchSem <- struct{}{}:
+			defer func() { <-p.fetchSem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		return nil, err
 	}
-	return msg.GetData(), nil
+	return p.fetcher().Fetch(ctx, pid, key)
 }
 
-func (p *PubsubValueStore) handleNewPeer(ctx context.Context, peerEvtHandler *pubsub.TopicEventHandler, key string) ([]byte, error) {
+func (p *PubsubValueStore) handleNewPeer(ctx context.Context, ti *topicInfo, key string) (update, error) {
 	for ctx.Err() == nil {
-		peerEvt, err := peerEvtHandler.NextPeerEvent(ctx)
+		peerEvt, err := ti.evts.NextPeerEvent(ctx)
 		if err != nil {
 			if err != context.Canceled {
-				log.Warnf("PubsubNewPeer: subscription error in %s: %s", formatKey(key), err.Error())
+				p.warnf(key, logSubscription, "PubsubNewPeer: subscription error in %s: %s", logKey(key), err)
 			}
-			return nil, err
+			return update{}, err
 		}
 
+		if ti.tuner != nil {
+			ti.tuner.observeChurn()
+		}
 		if peerEvt.Type != pubsub.PeerJoin {
 			continue
 		}
+		if p.joinRepublish > 0 {
+			p.republishOnJoin(ctx, ti, key)
+		}
 
 		pid := peerEvt.Peer
-		value, err := p.fetch.Fetch(ctx, pid, key)
+		if !p.publisherAllowed(key, pid) {
+			continue
+		}
+		value, err := p.limitedFetch(ctx, pid, key)
+		if err == nil && value != nil && p.recordValidator().Validate(key, value) == nil && !p.messageAllowed(ctx, key, value, pid) {
+			p.log.Debugf("fetched pubsub value for key '%s' from peer '%s' rejected by the message validator", logKey(key), pid)
+			continue
+		}
 		if err == nil {
-			return value, nil
+			if value != nil {
+				p.telemetry.IncCounter(MetricFetches, Attr("result", "found"))
+			} else {
+				p.telemetry.IncCounter(MetricFetches, Attr("result", "not_found"))
+			}
+			return update{data: value, meta: RecordMeta{From: pid, Received: time.Now()}}, nil
 		}
-		log.Debugf("failed to fetch latest pubsub value for key '%s' from peer '%s': %s", formatKey(key), pid, err)
+		p.telemetry.IncCounter(MetricFetches, Attr("result", "error"))
+		p.log.Debugf("failed to fetch latest pubsub value for key '%s' from peer '%s': %s", logKey(key), pid, err)
 	}
-	return nil, ctx.Err()
+	return update{}, ctx.Err()
 }
 
-func (p *PubsubValueStore) notifyWatchers(key string, data []byte) {
+func (p *PubsubValueStore) notifyWatchers(key string, data []byte, meta RecordMeta) {
 	p.watchLk.Lock()
 	defer p.watchLk.Unlock()
 	sg, ok := p.watching[key]
 	if !ok {
 		return
 	}
+	value := p.open(data)
 
-	for watcher := range sg.listeners {
+	for watcher, last := range sg.listeners {
+		if !p.strictlyBetter(key, data, last) {
+			continue
+		}
+		sg.listeners[watcher] = data
+		select {
+		case <-watcher:
+			watcher <- value
+		case watcher <- value:
+		}
+	}
+	for watcher, last := range sg.metaListeners {
+		if !p.strictlyBetter(key, data, last) {
+			continue
+		}
+		sg.metaListeners[watcher] = data
+		u := ValueUpdate{Value: value, RecordMeta: meta}
 		select {
 		case <-watcher:
-			watcher <- data
-		case watcher <- data:
+			watcher <- u
+		case watcher <- u:
+		}
+	}
+	for cb := range sg.callbacks {
+		if !p.strictlyBetter(key, data, cb.last) {
+			continue
 		}
+		cb.last = data
+		cb.deliver(value)
+	}
+	for l := range sg.history {
+		if !p.strictlyBetter(key, data, l.last) {
+			continue
+		}
+		l.last = data
+		p.send(l, value)
+	}
+}
+
+// strictlyBetter reports whether val should be delivered to a watcher that
+// was last sent prev. Notifications may race with each other, this makes sure
+// watchers only ever see improving values.
+func (p *PubsubValueStore) strictlyBetter(key string, val, prev []byte) bool {
+	return p.compareRecords(p.recordValidator(), key, val, prev) > 0
+}
+
+// dropWatchGroupLocked removes the key's watch group once its last watcher is
+// gone. The group may already have been replaced by a new one for the same
+// key, which is left alone. Must be called with p.watchLk held.
+func (p *PubsubValueStore) dropWatchGroupLocked(key string, wg *watchGroup) {
+	if cur, ok := p.watching[key]; ok && cur == wg && wg.empty() {
+		delete(p.watching, key)
+	}
+}
+
+// watchGroupLocked returns the watch group for key, creating it if needed.
+// Must be called with p.watchLk held.
+func (p *PubsubValueStore) watchGroupLocked(key string) *watchGroup {
+	wg, ok := p.watching[key]
+	if !ok {
+		wg = newWatchGroup()
+		p.watching[key] = wg
+	}
+	return wg
+}
+
+// oversized reports, and counts, values larger than the maximum record size.
+func (p *PubsubValueStore) oversized(value []byte) bool {
+	if p.maxRecordSize > 0 && len(value) > p.maxRecordSize {
+		p.count(&p.stats.oversizedRejected, MetricOversizedRejected)
+		return true
 	}
+	return false
 }
 
 func (p *PubsubValueStore) getTTLForKey(key string) (time.Duration, error) {
+	ttl := DefaultSubscriptionLifetime
+	if p.idleTimeout > 0 {
+		ttl = p.idleTimeout
+	}
 	ns, _, err := record.SplitKey(key)
 	if err != nil {
-		return DefaultSubscriptionLifetime, err
+		return ttl, err
 	}
 	nsTTL, ok := p.unusedSubscriptionTTL[ns]
 	if ok {
 		return nsTTL, nil
 	}
-	return DefaultSubscriptionLifetime, nil
+	return ttl, nil
 }
 
 func WithRebroadcastInterval(duration time.Duration) Option {
 	return func(store *PubsubValueStore) error {
 		store.rebroadcastInterval = duration
+		store.rebroadcastIntervalSet = true
 		return nil
 	}
 }
@@ -647,7 +2109,9 @@ func WithRebroadcastInitialDelay(duration time.Duration) Option {
 	}
 }
 
-// WithDatastore returns an option that overrides the default datastore.
+// WithDatastore returns an option that overrides the default datastore. The
+// store's entries are kept under its datastore prefix, see
+// WithDatastorePrefix.
 func WithDatastore(datastore ds.Datastore) Option {
 	return func(store *PubsubValueStore) error {
 		store.ds = datastore
@@ -655,7 +2119,104 @@ func WithDatastore(datastore ds.Datastore) Option {
 	}
 }
 
-// WithDatastore returns an option that sets a TTL for a specific namespace.
+// WithTopicPrefix returns an option that sets the prefix of the record topics,
+// isolating the store from the ones using another prefix. Defaults to
+// DefaultTopicPrefix.
+func WithTopicPrefix(prefix string) Option {
+	return func(store *PubsubValueStore) error {
+		if prefix == "" {
+			return fmt.Errorf("invalid topic prefix: %q", prefix)
+		}
+		store.topicPrefix = prefix
+		return nil
+	}
+}
+
+// WithLoopback returns an option that processes our own messages when they
+// are delivered back to us, like the ones of other peers. By default they are
+// skipped, since PutValue already stored the record.
+func WithLoopback() Option {
+	return func(store *PubsubValueStore) error {
+		store.loopback = true
+		return nil
+	}
+}
+
+// WithFetchTimeout returns an option that sets the time allowed to open a
+// fetch stream to a peer that joined a topic. Defaults to DefaultFetchTimeout.
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid fetch timeout: %s", timeout)
+		}
+		store.fetchTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxConcurrentFetches returns an option that limits the number of
+// records fetched at the same time from peers joining topics, across all
+// topics. Zero, the default, means unlimited.
+func WithMaxConcurrentFetches(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max concurrent fetches: %d", n)
+		}
+		store.fetchSem = nil
+		if n > 0 {
+			store.fetchSem = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// WithValidationWorkers returns an option that limits the number of messages
+// validated at the same time, across all topics, so that an expensive
+// validator doesn't hold up pubsub. Messages wait for a worker until their
+// validation context ends, and are then ignored. Zero, the default, means
+// unlimited.
+func WithValidationWorkers(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n < 0 {
+			return fmt.Errorf("invalid validation workers: %d", n)
+		}
+		store.validateSem = nil
+		if n > 0 {
+			store.validateSem = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// WithMaxRecordSize returns an option that sets the maximum size, in bytes, of
+// the records published and accepted by the store. Zero means unlimited.
+func WithMaxRecordSize(size int) Option {
+	return func(store *PubsubValueStore) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max record size: %d", size)
+		}
+		store.maxRecordSize = size
+		return nil
+	}
+}
+
+// WithIdleTimeout returns an option that cancels the subscriptions unused for
+// d, instead of DefaultSubscriptionLifetime. A subscription is used while it
+// is held through Subscribe or watched by SearchValue, and each GetValue or
+// SearchValue restarts its timeout; the stored record is kept, and the key is
+// subscribed to again on its next use. WithUnusedSubscriptionTTL takes
+// precedence for its namespace.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid idle timeout: %s", d)
+		}
+		store.idleTimeout = d
+		return nil
+	}
+}
+
+// WithUnusedSubscriptionTTL returns an option that sets a TTL for a specific namespace.
 func WithUnusedSubscriptionTTL(ttl time.Duration, namespace string) Option {
 	return func(store *PubsubValueStore) error {
 		store.unusedSubscriptionTTL[namespace] = ttl
@@ -664,17 +2225,25 @@ func WithUnusedSubscriptionTTL(ttl time.Duration, namespace string) Option {
 }
 
 func formatKey(key string) string {
-	ns, k, err := record.SplitKey(key)
+	display, err := displayKey(key)
 	if err != nil {
 		log.Error(err)
-		return key
+	}
+	return display
+}
+
+// displayKey returns the human readable form of the key, and the error making
+// it fall back to the key as is, if any.
+func displayKey(key string) (string, error) {
+	ns, k, err := record.SplitKey(key)
+	if err != nil {
+		return key, err
 	} else if ns != "ipns" {
-		return key
+		return key, nil
 	}
 	pid, err := peer.IDFromString(k)
 	if err != nil {
-		log.Error(err)
-		return key
+		return key, err
 	}
-	return "/ipns/" + peer.Encode(pid)
+	return "/ipns/" + peer.Encode(pid), nil
 }
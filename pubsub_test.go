@@ -3,19 +3,35 @@ package namesys
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
 	bhost "github.com/libp2p/go-libp2p-blankhost"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
 	record "github.com/libp2p/go-libp2p-record"
+	swarm "github.com/libp2p/go-libp2p-swarm"
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 )
 
@@ -117,6 +133,20 @@ func setupTest(ctx context.Context, t *testing.T) (*PubsubValueStore, []*PubsubV
 	return pub, vss
 }
 
+// newTestStore returns a store running on its own, unconnected host.
+func newTestStore(ctx context.Context, t *testing.T, opts ...Option) *PubsubValueStore {
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, h, fs, testValidator{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vs
+}
+
 // tests
 func TestEarlyPublish(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -167,7 +197,7 @@ func TestPubsubPublishSubscribe(t *testing.T) {
 	defer cancel()
 
 	pub, vss := setupTest(ctx, t)
-	defer pub.host.Close()
+	defer pub.host().Close()
 
 	key := "/namespace/key"
 	key2 := "/namespace/key2"
@@ -212,8 +242,9 @@ func TestPubsubPublishSubscribe(t *testing.T) {
 	// Check validator.
 	nval = []byte("valid for key 9999 invalid")
 	err = pub.PutValue(ctx, key, nval)
-	if err != nil {
-		t.Fatal(err)
+	var ierr *InvalidRecordError
+	if !errors.Is(err, ErrInvalidRecord) || !errors.As(err, &ierr) || ierr.Reason != record.ErrInvalidRecordType {
+		t.Fatalf("expected an InvalidRecordError, got %v", err)
 	}
 
 	// let the flood propagate
@@ -277,7 +308,7 @@ func TestWatch(t *testing.T) {
 	defer cancel()
 
 	pub, vss := setupTest(ctx, t)
-	defer pub.host.Close()
+	defer pub.host().Close()
 
 	key := "/namespace/key"
 	key2 := "/namespace/key2"
@@ -328,6 +359,451 @@ func TestWatch(t *testing.T) {
 	}
 }
 
+func TestOnValueChanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pub, vss := setupTest(ctx, t)
+	defer pub.host().Close()
+
+	key := "/namespace/key"
+
+	remote := make(chan []byte, 10)
+	unregister, err := vss[0].RegisterOnValueChanged(key, func(k string, val []byte) {
+		if k != key {
+			t.Errorf("unexpected key %s", k)
+		}
+		remote <- val
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := make(chan []byte, 10)
+	var unregisterLocal func()
+	unregisterLocal, err = pub.RegisterOnValueChanged(key, func(k string, val []byte) {
+		local <- val
+		// must not deadlock
+		unregisterLocal()
+		unregisterLocal()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val := []byte("valid for key 1")
+	if err := pub.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ch := range []chan []byte{local, remote} {
+		select {
+		case v := <-ch:
+			if !bytes.Equal(v, val) {
+				t.Fatalf("got unexpected value: %s", v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("callback not called")
+		}
+	}
+
+	unregister()
+	unregister()
+
+	if err := pub.PutValue(ctx, key, []byte("valid for key 2")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	select {
+	case v := <-local:
+		t.Fatalf("unregistered local callback called with %s", v)
+	case v := <-remote:
+		t.Fatalf("unregistered remote callback called with %s", v)
+	default:
+	}
+
+	if _, err := vss[0].Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pub, vss := setupTest(ctx, t)
+	defer pub.host().Close()
+
+	key := "/namespace/key"
+	val := []byte("valid for key 1")
+
+	var notified int32
+	unregister, err := vss[0].RegisterOnValueChanged(key, func(string, []byte) {
+		atomic.AddInt32(&notified, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregister()
+
+	for i := 0; i < 50; i++ {
+		if err := pub.PutValue(ctx, key, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForPropagation(ctx, t, vss, key)
+	time.Sleep(time.Second)
+
+	if n := atomic.LoadInt32(&notified); n != 1 {
+		t.Fatalf("expected exactly one notification, got %d", n)
+	}
+	if vss[0].Stats().DuplicatesSuppressed == 0 {
+		t.Fatal("expected duplicates to be counted")
+	}
+}
+
+func TestDecommissionNamespace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+
+	const numKeys = 10000
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("/app/key%d", i)
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 0 {
+			if err := vs.ds.Put(ctx, dshelp.NewKeyFromBinary([]byte(key)), []byte("record")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// an aborted decommission reports what's left
+	abortCtx, abort := context.WithCancel(ctx)
+	abort()
+	report, err := vs.DecommissionNamespace(abortCtx, "app", true)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if report.Remaining != numKeys {
+		t.Fatalf("expected %d remaining keys, got %d", numKeys, report.Remaining)
+	}
+
+	// other namespaces keep serving while decommissioning
+	stop := make(chan struct{})
+	served := make(chan int)
+	go func() {
+		n := 0
+		defer func() { served <- n }()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("/namespace/key%d", n%10)
+			val := []byte(fmt.Sprintf("valid for key%d %06d", n%10, n))
+			if err := vs.PutValue(ctx, key, val); err != nil {
+				t.Error(err)
+				return
+			}
+			checkValue(ctx, t, 0, vs, key, val)
+			n++
+		}
+	}()
+
+	report, err = vs.DecommissionNamespace(ctx, "app", true)
+	close(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := <-served; n == 0 {
+		t.Fatal("other namespaces weren't served during decommission")
+	}
+	if report.Canceled != numKeys || report.Purged != numKeys/10 || len(report.Failed) != 0 || report.Remaining != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 10 {
+		t.Fatalf("expected the 10 other keys to stay subscribed, got %d", len(subs))
+	}
+}
+
+// failingDeleteDatastore is a datastore whose deletes fail while fail is set.
+type failingDeleteDatastore struct {
+	ds.Batching
+	fail int32
+}
+
+func (f *failingDeleteDatastore) Delete(ctx context.Context, key ds.Key) error {
+	if atomic.LoadInt32(&f.fail) != 0 {
+		return errDiskFull
+	}
+	return f.Batching.Delete(ctx, key)
+}
+
+func TestDecommissionStoredRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &failingDeleteDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	vs := newTestStore(ctx, t, WithDatastore(d), WithEnvelopes())
+
+	// published, so authored and sealed, then canceled
+	authored := "/namespace/authored"
+	if err := vs.PutValue(ctx, authored, []byte("valid for authored")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Cancel(authored); err != nil {
+		t.Fatal(err)
+	}
+	// never subscribed to
+	stored := "/namespace/stored"
+	if err := vs.PutLocal(ctx, stored, []byte("valid for stored")); err != nil {
+		t.Fatal(err)
+	}
+	other := "/app/key"
+	if err := vs.ds.Put(ctx, dshelp.NewKeyFromBinary([]byte(other)), []byte("record")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys whose purge failed are retried
+	atomic.StoreInt32(&d.fail, 1)
+	report, err := vs.DecommissionNamespace(ctx, "namespace", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Purged != 0 || len(report.Failed) != 2 {
+		t.Fatalf("expected both purges to fail, got %+v", report)
+	}
+	atomic.StoreInt32(&d.fail, 0)
+	report, err = vs.DecommissionNamespace(ctx, "namespace", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Purged != 2 || len(report.Failed) != 0 {
+		t.Fatalf("expected both records to be purged, got %+v", report)
+	}
+
+	if keys, err := vs.ListKeys(ctx, "", 0); err != nil || !reflect.DeepEqual(keys, []string{other}) {
+		t.Fatalf("expected only %s to be left, got %q, %v", other, keys, err)
+	}
+	for _, k := range []ds.Key{authoredKey(authored), seqKey(authored), metaKey(stored)} {
+		if has, err := vs.ds.Has(ctx, k); err != nil || has {
+			t.Fatalf("expected %s to be deleted, got %v, %v", k, has, err)
+		}
+	}
+	if keys, err := vs.AuthoredKeys(ctx); err != nil || len(keys) != 0 {
+		t.Fatalf("expected no authored keys left, got %q, %v", keys, err)
+	}
+}
+
+func TestCancelReleasesMemory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+
+	heapAlloc := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+
+	const numKeys = 10000
+	run := func(prefix string) {
+		for i := 0; i < numKeys; i++ {
+			if err := vs.Subscribe(fmt.Sprintf("/namespace/%s%d", prefix, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < numKeys; i++ {
+			if _, err := vs.Cancel(fmt.Sprintf("/namespace/%s%d", prefix, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// warm up, so that pools and maps are already grown
+	run("warmup")
+	baseline := heapAlloc()
+
+	run("key")
+	after := heapAlloc()
+
+	t.Logf("baseline: %d, after: %d", baseline, after)
+	if after > baseline+baseline/10 {
+		t.Fatalf("memory not released after cancel: baseline %d bytes, after %d bytes", baseline, after)
+	}
+}
+
+func TestAllowedPublishers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pub, vss := setupTest(ctx, t)
+	defer pub.host().Close()
+
+	key := "/namespace/key"
+
+	vss[1].SetAllowedPublishers(key, []peer.ID{vss[0].host().ID()})
+
+	val := []byte("valid for key 1")
+	if err := pub.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForPropagation(ctx, t, vss[:1], key)
+	time.Sleep(time.Millisecond * 500)
+	checkNotFound(ctx, t, 1, vss[1], key)
+	if vss[1].Stats().PublisherRejected == 0 {
+		t.Fatal("expected rejections to be counted")
+	}
+
+	// back to open behavior
+	vss[1].SetAllowedPublishers(key, nil)
+
+	val = []byte("valid for key 2")
+	if err := pub.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	waitForPropagation(ctx, t, vss, key)
+	for i, vs := range vss {
+		checkValue(ctx, t, i, vs, key, val)
+	}
+}
+
+func TestMaxRecordSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithMaxRecordSize(32))
+	key := "/namespace/key"
+
+	big := []byte("valid for key " + strings.Repeat("x", 32))
+	if err := vs.PutValue(ctx, key, big); err != ErrRecordTooLarge {
+		t.Fatalf("expected ErrRecordTooLarge, got %v", err)
+	}
+
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	topic := KeyToTopic(key)
+	msg := &pubsub.Message{Message: &pubsubpb.Message{Data: big, Topic: &topic}}
+	if res := vs.validate(ctx, vs.host().ID(), msg); res != pubsub.ValidationReject {
+		t.Fatalf("expected oversized message to be rejected, got %v", res)
+	}
+
+	if n := vs.Stats().OversizedRejected; n != 2 {
+		t.Fatalf("expected 2 oversized rejections, got %d", n)
+	}
+
+	small := []byte("valid for key")
+	if err := vs.PutValue(ctx, key, small); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, small)
+}
+
+func TestWatchMonotonic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	seen := make(chan []byte, 10)
+	unregister, err := vs.RegisterOnValueChanged(key, func(_ string, val []byte) {
+		seen <- val
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregister()
+
+	ch, err := vs.SearchValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// notifications racing with each other: duplicates and an outdated record
+	for _, v := range []string{"valid for key 2", "valid for key 2", "valid for key 1", "valid for key 3", "valid for key 3"} {
+		vs.notifyWatchers(key, []byte(v), RecordMeta{})
+	}
+
+	// the listener keeps the latest value until it's picked up
+	if v := string(<-ch); v != "valid for key 2" && v != "valid for key 3" {
+		t.Fatalf("got unexpected value: %s", v)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel to be closed")
+	}
+
+	var prev []byte
+	for {
+		select {
+		case v := <-seen:
+			if !vs.strictlyBetter(key, v, prev) {
+				t.Fatalf("got %s after %s", v, prev)
+			}
+			prev = v
+			continue
+		case <-time.After(time.Millisecond * 200):
+		}
+		break
+	}
+	if string(prev) != "valid for key 3" {
+		t.Fatalf("expected to end with the best value, got %s", prev)
+	}
+}
+
+func TestSearchValueCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	ch1, cancel1, err := vs.SearchValueWithCancel(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch2, cancel2, err := vs.SearchValueWithCancel(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel2()
+
+	// concurrently with notifications
+	go vs.notifyWatchers(key, []byte("valid for key 1"), RecordMeta{})
+	cancel1()
+	cancel1()
+
+	for range ch1 {
+		// may or may not have gotten the value before being detached
+	}
+
+	go vs.notifyWatchers(key, []byte("valid for key 2"), RecordMeta{})
+	select {
+	case v := <-ch2:
+		if len(v) == 0 {
+			t.Fatal("expected a value")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("other search was affected")
+	}
+
+	if subs := vs.GetSubscriptions(); len(subs) != 1 {
+		t.Fatal("subscription was canceled")
+	}
+}
+
 func TestPutMany(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -392,7 +868,7 @@ func TestGC(t *testing.T) {
 	defer cancel()
 
 	pub, _ := setupTest(ctx, t)
-	defer pub.host.Close()
+	defer pub.host().Close()
 
 	// set separate TTLs per namespace
 	pub.unusedSubscriptionTTL["namespace1"] = time.Millisecond * 50
@@ -401,13 +877,12 @@ func TestGC(t *testing.T) {
 	// subscribe to both namespaces
 	key1 := "/namespace1/key"
 	key2 := "/namespace2/key"
-	val := []byte("foo")
-	err := pub.PutValue(ctx, key1, val)
-	if err != nil {
+	_, err := pub.GetValue(ctx, key1)
+	if err != routing.ErrNotFound {
 		t.Fatal(err)
 	}
-	err = pub.PutValue(ctx, key2, val)
-	if err != nil {
+	_, err = pub.GetValue(ctx, key2)
+	if err != routing.ErrNotFound {
 		t.Fatal(err)
 	}
 
@@ -430,16 +905,4191 @@ func TestGC(t *testing.T) {
 	}
 }
 
-func checkNotFound(ctx context.Context, t *testing.T, i int, vs routing.ValueStore, key string) {
-	t.Helper()
-	_, err := vs.GetValue(ctx, key)
-	if err != routing.ErrNotFound {
-		t.Fatalf("[vssolver %d] unexpected error: %s", i, err.Error())
+func TestClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	for i := 0; i < 100; i++ {
+		if err := vs.Subscribe(fmt.Sprintf("%s%d", key, i)); err != nil {
+			t.Fatal(err)
+		}
 	}
-}
 
-func checkValue(ctx context.Context, t *testing.T, i int, vs routing.ValueStore, key string, val []byte) {
-	t.Helper()
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions after close, got %d", len(subs))
+	}
+	if err := vs.Subscribe(key); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	// closing twice is fine
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	for i := 0; i < 100; i++ {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vs.Cancel(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the goroutines of the canceled subscriptions must not tear down the
+	// new one on their way out
+	time.Sleep(100 * time.Millisecond)
+	if subs := vs.GetSubscriptions(); len(subs) != 1 {
+		t.Fatal("resubscribed key got torn down")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := newTestStore(ctx, t)
+	kvs := map[string][]byte{
+		"/namespace/key1": []byte("valid for key1"),
+		"/namespace/key2": []byte("valid for key2"),
+	}
+	if err := src.PutValues(ctx, kvs); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := src.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != len(kvs) {
+		t.Fatalf("expected %d exported records, got %d", len(kvs), len(exported))
+	}
+	for k, v := range kvs {
+		if !bytes.Equal(exported[k], v) {
+			t.Fatalf("unexpected exported value for %s: %q", k, exported[k])
+		}
+	}
+
+	dst := newTestStore(ctx, t)
+	better := []byte("valid for key2 and better")
+	if err := dst.PutValue(ctx, "/namespace/key2", better); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := dst.SearchValue(ctx, "/namespace/key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported["/namespace/key3"] = []byte("valid for key3 invalid")
+	err = dst.Import(ctx, exported)
+	var perr PutValuesError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected PutValuesError, got %v", err)
+	}
+	if len(perr) != 1 || !errors.Is(perr["/namespace/key3"], ErrInvalidRecord) {
+		t.Fatalf("unexpected errors: %v", perr)
+	}
+
+	select {
+	case v := <-ch:
+		if !bytes.Equal(v, kvs["/namespace/key1"]) {
+			t.Fatalf("unexpected notified value: %q", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher wasn't notified of the imported value")
+	}
+
+	checkValue(ctx, t, 0, dst, "/namespace/key1", kvs["/namespace/key1"])
+	checkValue(ctx, t, 0, dst, "/namespace/key2", better)
+	checkNotFound(ctx, t, 0, dst, "/namespace/key3")
+}
+
+func TestGetValueWithMeta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	pub, vss := setupTest(ctx, t)
+	defer pub.host().Close()
+
+	start := time.Now()
+	val := []byte("valid for key")
+	if err := pub.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	waitForPropagation(ctx, t, vss, key)
+
+	_, meta, err := pub.GetValueWithMeta(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.From != pub.host().ID() || meta.Received.Before(start) {
+		t.Fatalf("unexpected metadata for a local record: %+v", meta)
+	}
+
+	for i, vs := range vss {
+		xval, meta, err := vs.GetValueWithMeta(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(xval, val) {
+			t.Fatalf("vs%d: unexpected value %q", i, xval)
+		}
+		if meta.From != pub.host().ID() || len(meta.Seqno) == 0 || meta.Received.Before(start) {
+			t.Fatalf("vs%d: unexpected metadata: %+v", i, meta)
+		}
+	}
+
+	// records stored without metadata
+	legacy := "/namespace/legacy"
+	vs := vss[0]
+	if err := vs.ds.Put(ctx, dshelp.NewKeyFromBinary([]byte(legacy)), []byte("valid for legacy")); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err = vs.GetValueWithMeta(ctx, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.From != "" || meta.Seqno != nil || !meta.Received.IsZero() {
+		t.Fatalf("expected zero metadata, got %+v", meta)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithHistory(3))
+	key := "/namespace/key"
+
+	for i := 1; i <= 5; i++ {
+		if err := vs.PutValue(ctx, key, []byte(fmt.Sprintf("valid for key %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not accepted, so not recorded
+	if err := vs.PutValue(ctx, key, []byte("valid for key 0")); err != nil {
+		t.Fatal(err)
+	}
+
+	hist, err := vs.GetHistory(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("valid for key 5"), []byte("valid for key 4"), []byte("valid for key 3")}
+	if len(hist) != len(expected) {
+		t.Fatalf("expected %d records, got %q", len(expected), hist)
+	}
+	for i := range expected {
+		if !bytes.Equal(hist[i], expected[i]) {
+			t.Fatalf("unexpected history: %q", hist)
+		}
+	}
+
+	if _, err := vs.DecommissionNamespace(ctx, "namespace", true); err != nil {
+		t.Fatal(err)
+	}
+	hist, err = vs.GetHistory(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 0 {
+		t.Fatalf("expected the history to be purged, got %q", hist)
+	}
+}
+
+// rejectingValidator rejects every record.
+type rejectingValidator struct{ testValidator }
+
+func (rejectingValidator) Validate(string, []byte) error {
+	return record.ErrInvalidRecordType
+}
+
+func TestSetValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, from := newSigner(t)
+	msg := newSignedMessage(t, nil, from, key, []byte("valid for key"))
+	if res := vs.validate(ctx, from, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("expected the message to be accepted, got %v", res)
+	}
+
+	vs.SetValidator(rejectingValidator{})
+	if _, ok := vs.GetValidator().(rejectingValidator); !ok {
+		t.Fatalf("unexpected validator %T", vs.GetValidator())
+	}
+	if res := vs.validate(ctx, from, msg); res != pubsub.ValidationReject {
+		t.Fatalf("expected the message to be rejected by the new validator, got %v", res)
+	}
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord, got %v", err)
+	}
+	checkNotFound(ctx, t, 0, vs, key)
+}
+
+func TestSubscribeAndWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	alone := newTestStore(ctx, t)
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+	if err := alone.SubscribeAndWait(tctx, key); err != ErrNoPeersFound {
+		t.Fatalf("expected ErrNoPeersFound, got %v", err)
+	}
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+		defer wcancel()
+		done <- vss[0].SubscribeAndWait(wctx, key)
+	}()
+	if err := vss[1].Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxConcurrentFetches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithMaxConcurrentFetches(1), WithFetchTimeout(time.Second))
+	if vs.fetcher().timeout != time.Second {
+		t.Fatalf("unexpected fetch timeout %s", vs.fetcher().timeout)
+	}
+
+	// take the only slot
+	vs.fetchSem <- struct{}{}
+	tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	_, pid := newSigner(t)
+	if _, err := vs.limitedFetch(tctx, pid, "/namespace/key"); err != context.DeadlineExceeded {
+		t.Fatalf("expected the fetch to wait for a slot, got %v", err)
+	}
+	<-vs.fetchSem
+}
+
+// hangingPubsub blocks joining topics until released.
+type hangingPubsub struct {
+	*pubsub.PubSub
+	release chan struct{}
+}
+
+func (h *hangingPubsub) Join(topic string, opts ...pubsub.TopicOpt) (*pubsub.Topic, error) {
+	<-h.release
+	return h.PubSub.Join(topic, opts...)
+}
+
+func TestPutValueContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hps := &hangingPubsub{PubSub: fs, release: make(chan struct{})}
+	vs, err := NewPubsubValueStore(ctx, h, hps, testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+	start := time.Now()
+	if err := vs.PutValue(tctx, key, []byte("valid for key")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("PutValue took %s to honor its deadline", d)
+	}
+
+	close(hps.release)
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key"))
+}
+
+func TestPutValueContextWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	vs.mx.Lock()
+	ti := vs.topics[key]
+	vs.mx.Unlock()
+
+	// a write of the network holding the key
+	ti.dbWriteMx.Lock()
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+	start := time.Now()
+	if err := vs.PutValue(tctx, key, []byte("valid for key")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("PutValue took %s to honor its deadline", d)
+	}
+	ti.dbWriteMx.Unlock()
+	checkNotFound(ctx, t, 0, vs, key)
+
+	// the abandoned lock is released
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	if err := vs.PutValue(wctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key"))
+}
+
+func TestSubscriptionRefCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, h, fs, testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	if err := vs.Unsubscribe(key); err != ErrNotSubscribed {
+		t.Fatalf("expected ErrNotSubscribed, got %v", err)
+	}
+
+	// an implicit subscription isn't held
+	if _, err := vs.GetValue(ctx, key); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := vs.Unsubscribe(key); err != ErrNotSubscribed {
+		t.Fatalf("expected ErrNotSubscribed, got %v", err)
+	}
+
+	// keep one hold while the others come and go
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := vs.Subscribe(key); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := vs.Unsubscribe(key); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if subs := vs.GetSubscriptions(); len(subs) != 1 {
+		t.Fatalf("expected the held subscription to survive, got %v", subs)
+	}
+	if err := vs.Unsubscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", subs)
+	}
+	if topics := fs.GetTopics(); len(topics) != 0 {
+		t.Fatalf("expected no pubsub topics, got %v", topics)
+	}
+	if err := vs.Unsubscribe(key); err != ErrNotSubscribed {
+		t.Fatalf("expected ErrNotSubscribed, got %v", err)
+	}
+}
+
+func TestWatchGroupGC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+
+	const numKeys = 10000
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("/namespace/key%d", i)
+		_, stop, err := vs.SearchValueWithCancel(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unregister, err := vs.RegisterOnValueChanged(key, func(string, []byte) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stop()
+		unregister()
+	}
+
+	vs.watchLk.Lock()
+	n := len(vs.watching)
+	vs.watchLk.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no watch groups left, got %d", n)
+	}
+}
+
+// TestConcurrentWatchers is meant to be run with -race.
+func TestConcurrentWatchers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	keys := []string{"/namespace/key0", "/namespace/key1", "/namespace/key2"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				sctx, scancel := context.WithTimeout(ctx, time.Millisecond)
+				ch, err := vs.SearchValue(sctx, keys[j%len(keys)])
+				if err != nil {
+					t.Error(err)
+				} else {
+					for range ch {
+					}
+				}
+				scancel()
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := keys[j%len(keys)]
+				val := []byte(fmt.Sprintf("valid for %s %02d %03d", key, i, j))
+				// fails when canceled right after subscribing
+				_ = vs.PutValue(ctx, key, val)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				// fails while the key is watched
+				_, _ = vs.Cancel(keys[j%len(keys)])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPublishRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	noPending := func(vs *PubsubValueStore) func(context.Context) (bool, error) {
+		return func(context.Context) (bool, error) {
+			return vs.Stats().PendingPublishes == 0, nil
+		}
+	}
+
+	// nobody ever listens
+	alone := newTestStore(ctx, t, WithPublishRetry(1500*time.Millisecond))
+	for _, v := range []string{"valid for key 1", "valid for key 2"} {
+		if err := alone.PutValue(ctx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := alone.Stats().PendingPublishes; n != 1 {
+		t.Fatalf("expected the newer record to supersede the pending one, got %d pending", n)
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	if err := waitUntil(wctx, noPending(alone), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := alone.Stats().PublishesAbandoned; n != 1 {
+		t.Fatalf("expected 1 abandoned record, got %d", n)
+	}
+
+	// a peer shows up while retrying
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, WithPublishRetry(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+	if err := vss[0].PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if n := vss[0].Stats().PendingPublishes; n != 1 {
+		t.Fatalf("expected 1 pending record, got %d", n)
+	}
+	if err := vss[1].Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitUntil(wctx, noPending(vss[0]), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := vss[0].Stats().PublishesAbandoned; n != 0 {
+		t.Fatalf("expected no abandoned records, got %d", n)
+	}
+	checkValue(ctx, t, 1, vss[1], key, []byte("valid for key"))
+}
+
+func TestMessageValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, banned := newSigner(t)
+	vs := newTestStore(ctx, t, WithMessageValidator(func(ctx context.Context, key string, value []byte, from peer.ID) bool {
+		if bytes.Contains(value, []byte("panic")) {
+			panic("boom")
+		}
+		return from != banned && !bytes.Contains(value, []byte("forbidden"))
+	}))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, from := newSigner(t)
+	for _, tc := range []struct {
+		from peer.ID
+		data string
+		res  pubsub.ValidationResult
+	}{
+		{from, "valid for key", pubsub.ValidationAccept},
+		{from, "valid for key forbidden", pubsub.ValidationReject},
+		{from, "valid for key panic", pubsub.ValidationReject},
+		{banned, "valid for key", pubsub.ValidationReject},
+		// the built-in validation runs first
+		{from, "invalid", pubsub.ValidationReject},
+	} {
+		msg := newSignedMessage(t, nil, tc.from, key, []byte(tc.data))
+		if res := vs.validate(ctx, tc.from, msg); res != tc.res {
+			t.Errorf("%q from %s: expected %v, got %v", tc.data, tc.from, tc.res, res)
+		}
+	}
+
+	if err := vs.PutValue(ctx, key, []byte("valid for key forbidden")); err != ErrRejected {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+	checkNotFound(ctx, t, 0, vs, key)
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key"))
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithNamespaces("namespace"))
+
+	for _, key := range []string{"/other/key", "no-namespace"} {
+		if err := vs.Subscribe(key); !errors.Is(err, ErrUnsupportedNamespace) {
+			t.Fatalf("Subscribe(%q): expected ErrUnsupportedNamespace, got %v", key, err)
+		}
+		if err := vs.PutValue(ctx, key, []byte("valid for "+key)); !errors.Is(err, ErrUnsupportedNamespace) {
+			t.Fatalf("PutValue(%q): expected ErrUnsupportedNamespace, got %v", key, err)
+		}
+		if _, err := vs.GetValue(ctx, key); !errors.Is(err, ErrUnsupportedNamespace) {
+			t.Fatalf("GetValue(%q): expected ErrUnsupportedNamespace, got %v", key, err)
+		}
+		if _, err := vs.SearchValue(ctx, key); !errors.Is(err, ErrUnsupportedNamespace) {
+			t.Fatalf("SearchValue(%q): expected ErrUnsupportedNamespace, got %v", key, err)
+		}
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", subs)
+	}
+
+	if err := vs.PutValue(ctx, "/namespace/key", []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, "/namespace/key", []byte("valid for key"))
+}
+
+func TestGetValueOffline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	if _, err := vs.GetValue(ctx, key, routing.Offline); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", subs)
+	}
+
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	val, err := vs.GetValue(ctx, key, routing.Offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, []byte("valid for key")) {
+		t.Fatalf("unexpected value %q", val)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", subs)
+	}
+}
+
+// countingTelemetry counts the reported counters by name.
+type countingTelemetry struct {
+	NoopTelemetry
+
+	mx     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingTelemetry) IncCounter(name string, _ ...Attribute) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.counts[name]++
+}
+
+func (c *countingTelemetry) count(name string) int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.counts[name]
+}
+
+func TestPutLocal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tel := &countingTelemetry{counts: map[string]int{}}
+	vs := newTestStore(ctx, t, WithTelemetry(tel))
+	key := "/namespace/key"
+
+	ch, err := vs.SearchValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := []byte("valid for key 2")
+	if err := vs.PutLocal(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-ch; !bytes.Equal(v, val) {
+		t.Fatalf("unexpected notified value %q", v)
+	}
+
+	// worse records are ignored, invalid ones rejected
+	if err := vs.PutLocal(ctx, key, []byte("valid for key 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.PutLocal(ctx, key, []byte("invalid key")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected an invalid record to be rejected with ErrInvalidRecord, got %v", err)
+	}
+	checkValue(ctx, t, 0, vs, key, val)
+
+	if n := tel.count(MetricPublishes); n != 0 {
+		t.Fatalf("expected nothing to be published, got %d publishes", n)
+	}
+}
+
+func TestRestoreSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	vs := newTestStore(ctx, t, WithDatastore(d), WithPersistentSubscriptions())
+	for _, key := range []string{"/namespace/key", "/namespace/other", "/namespace/held", "/namespace/held", "/namespace/held"} {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := vs.Cancel("/namespace/other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Unsubscribe("/namespace/held"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// recorded before the hold counts were
+	if err := vs.ds.Put(ctx, subscriptionKey("/namespace/legacy"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	vs = newTestStore(ctx, t, WithDatastore(d), WithPersistentSubscriptions())
+	if err := vs.RestoreSubscriptions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	subs := vs.GetSubscriptions()
+	sort.Strings(subs)
+	if len(subs) != 3 || subs[0] != "/namespace/held" || subs[1] != "/namespace/key" || subs[2] != "/namespace/legacy" {
+		t.Fatalf("unexpected restored subscriptions %q", subs)
+	}
+	vs.mx.Lock()
+	holds := map[string]int{}
+	for _, key := range subs {
+		holds[key] = vs.topics[key].refs
+	}
+	vs.mx.Unlock()
+	if holds["/namespace/held"] != 2 || holds["/namespace/key"] != 1 || holds["/namespace/legacy"] != 0 {
+		t.Fatalf("unexpected restored holds %v", holds)
+	}
+
+	// the holds are released as before the restart
+	for i := 0; i < 2; i++ {
+		if err := vs.Unsubscribe("/namespace/held"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vs.Unsubscribe("/namespace/held"); err != ErrNotSubscribed {
+		t.Fatalf("expected ErrNotSubscribed once the holds are released, got %v", err)
+	}
+	if ok, err := vs.ds.Has(ctx, subscriptionKey("/namespace/held")); err != nil || ok {
+		t.Fatalf("expected the released subscription to be forgotten: %v, %v", ok, err)
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	ch, err := vss[0].SearchValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vss[1].Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// kill the subscription behind the store's back
+	vss[0].mx.Lock()
+	vss[0].topics[key].sub.Cancel()
+	vss[0].mx.Unlock()
+
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	err = waitUntil(wctx, func(context.Context) (bool, error) {
+		return vss[0].Stats().Resubscribes > 0, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs := vss[0].GetSubscriptions(); len(subs) != 1 {
+		t.Fatalf("expected the subscription to survive, got %q", subs)
+	}
+
+	vss[1].mx.Lock()
+	topic := vss[1].topics[key].topic
+	vss[1].mx.Unlock()
+	err = waitUntil(wctx, func(context.Context) (bool, error) {
+		return len(topic.ListPeers()) > 0, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := []byte("valid for key 1")
+	if err := vss[1].PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-ch:
+		if !bytes.Equal(v, val) {
+			t.Fatalf("unexpected value %q", v)
+		}
+	case <-wctx.Done():
+		t.Fatal("watcher got no update after resubscribing")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	vs.mx.Lock()
+	vs.topics[key].sub.Cancel()
+	vs.mx.Unlock()
+
+	select {
+	case err := <-vs.Errors():
+		if err.Key != key || err.Op != OpSubscribe || err.Err != pubsub.ErrSubscriptionCancelled {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the subscription error to be reported")
+	}
+
+	// unread errors are dropped, not waited for
+	for i := 0; i < errorsBuffer+1; i++ {
+		vs.reportError(key, OpStore, errors.New("disk full"))
+	}
+	if n := vs.Stats().ErrorsDropped; n != 1 {
+		t.Fatalf("expected 1 dropped error, got %d", n)
+	}
+
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range vs.Errors() {
+	}
+}
+
+func TestState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	check := func(want KeyState) {
+		t.Helper()
+		st, err := vs.State(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st != want {
+			t.Fatalf("expected state %+v, got %+v", want, st)
+		}
+	}
+
+	check(KeyState{Sub: StateNotTracked})
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := vs.SearchValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(KeyState{Sub: StateHeld, Watched: true})
+
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+	if err := vs.Unsubscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	check(KeyState{Sub: StateNotTracked, HasValue: true})
+
+	if _, err := vs.GetValue(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	check(KeyState{Sub: StateSubscribed, HasValue: true})
+}
+
+// blockingPubsub blocks joining the topic of a key until released.
+type blockingPubsub struct {
+	*pubsub.PubSub
+	topic   string
+	release chan struct{}
+}
+
+func (b *blockingPubsub) Join(topic string, opts ...pubsub.TopicOpt) (*pubsub.Topic, error) {
+	if topic == b.topic {
+		<-b.release
+	}
+	return b.PubSub.Join(topic, opts...)
+}
+
+func TestPerKeyLocking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bps := &blockingPubsub{PubSub: fs, topic: KeyToTopic("/namespace/stuck"), release: make(chan struct{})}
+	vs, err := NewPubsubValueStore(ctx, h, bps, testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stuck := make(chan error, 1)
+	go func() {
+		stuck <- vs.Subscribe("/namespace/stuck")
+	}()
+
+	// the stuck subscription doesn't hold up the other keys
+	key := "/namespace/key"
+	tctx, tcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer tcancel()
+	if err := vs.PutValue(tctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(tctx, t, 0, vs, key, []byte("valid for key"))
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("unexpected subscriptions %q", subs)
+	}
+
+	close(bps.release)
+	if err := <-stuck; err != nil {
+		t.Fatal(err)
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 1 || subs[0] != "/namespace/stuck" {
+		t.Fatalf("unexpected subscriptions %q", subs)
+	}
+}
+
+func TestTopicPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 3)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts []Option
+		if i < 2 {
+			opts = append(opts, WithTopicPrefix("/private/"))
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, h := range hosts[1:] {
+		if err := h.Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := "/namespace/key"
+	for _, vs := range vss[1:] {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	val := []byte("valid for key")
+	if err := vss[0].PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		_, err := vss[1].GetValue(ctx, key, routing.Offline)
+		return err == nil, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the default prefix keeps out of the private topics
+	if _, err := vss[2].GetValue(ctx, key, routing.Offline); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound across prefixes, got %v", err)
+	}
+	if ex := vss[0].ExplainKey(key); !strings.HasPrefix(ex.Topic, "/private/") {
+		t.Fatalf("unexpected topic %s", ex.Topic)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithIdleTimeout(200*time.Millisecond))
+	idle, watched := "/namespace/idle", "/namespace/watched"
+	val := []byte("valid for idle")
+	if err := vs.PutValue(ctx, idle, val); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := vs.SearchValue(ctx, watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if subs := vs.GetSubscriptions(); len(subs) != 1 || subs[0] != watched {
+		t.Fatalf("expected only the watched key to stay subscribed, got %q", subs)
+	}
+
+	// the record outlives the subscription
+	checkValue(ctx, t, 0, vs, idle, val)
+	if subs := vs.GetSubscriptions(); len(subs) != 2 {
+		t.Fatalf("expected GetValue to subscribe again, got %q", subs)
+	}
+
+	if err := vs.PutValue(ctx, watched, []byte("valid for watched")); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+}
+
+func TestMaxSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithMaxSubscriptions(2))
+	for _, key := range []string{"/namespace/key1", "/namespace/key2", "/namespace/key1", "/namespace/key3"} {
+		if _, err := vs.GetValue(ctx, key); err != routing.ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	subs := vs.GetSubscriptions()
+	sort.Strings(subs)
+	if len(subs) != 2 || subs[0] != "/namespace/key1" || subs[1] != "/namespace/key3" {
+		t.Fatalf("expected the least recently used key to be evicted, got %q", subs)
+	}
+	if n := vs.Stats().Evictions; n != 1 {
+		t.Fatalf("expected 1 eviction, got %d", n)
+	}
+
+	// held and watched keys stay
+	if err := vs.Subscribe("/namespace/key1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.SearchValue(ctx, "/namespace/key3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.GetValue(ctx, "/namespace/key4"); err != ErrTooManySubscriptions {
+		t.Fatalf("expected ErrTooManySubscriptions, got %v", err)
+	}
+}
+
+func TestSearchErr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	wait := func(s *Search) error {
+		for range s.Values() {
+		}
+		return s.Err()
+	}
+
+	tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	s, err := vs.Search(tctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(s); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	s, err = vs.Search(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Cancel()
+	if err := wait(s); err != ErrSearchCanceled {
+		t.Fatalf("expected ErrSearchCanceled, got %v", err)
+	}
+
+	s, err = vs.Search(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(s); err != nil {
+		t.Fatalf("expected the search to succeed, got %v", err)
+	}
+
+	s, err = vs.Search(ctx, "/namespace/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(s); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+// unionMerger merges records of the form "set:a,b" into the sorted union of
+// their elements.
+type unionMerger struct{}
+
+func (unionMerger) Merge(_ string, old, val []byte) ([]byte, error) {
+	elems := map[string]struct{}{}
+	for _, rec := range [][]byte{old, val} {
+		s := strings.TrimPrefix(string(rec), "set:")
+		for _, e := range strings.Split(s, ",") {
+			elems[e] = struct{}{}
+		}
+	}
+	union := make([]string, 0, len(elems))
+	for e := range elems {
+		union = append(union, e)
+	}
+	sort.Strings(union)
+	return []byte("set:" + strings.Join(union, ",")), nil
+}
+
+func TestMerger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, WithMerger(unionMerger{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/set"
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var eg errgroup.Group
+	for i, elems := range []string{"set:a,c", "set:b"} {
+		vs, val := vss[i], []byte(elems)
+		eg.Go(func() error {
+			return vs.PutValue(ctx, key, val)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("set:a,b,c")
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		for _, vs := range vss {
+			if val, err := vs.GetValue(ctx, key); err != nil || !bytes.Equal(val, want) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		for i, vs := range vss {
+			val, _ := vs.GetValue(ctx, key)
+			t.Logf("[ValueStore %d] %q", i, val)
+		}
+		t.Fatal("stores didn't converge on the union")
+	}
+}
+
+func TestSearchValueWithMeta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	ch, err := vss[1].SearchValueWithMeta(wctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vss[0].SubscribeAndWait(wctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(val string, from peer.ID) {
+		t.Helper()
+		u, ok := <-ch
+		if !ok {
+			t.Fatal("channel closed before the update")
+		}
+		if string(u.Value) != val || u.From != from || u.Received.IsZero() {
+			t.Fatalf("unexpected update %q from %s at %s", u.Value, u.From, u.Received)
+		}
+	}
+
+	if err := vss[0].PutValue(ctx, key, []byte("valid for key 1")); err != nil {
+		t.Fatal(err)
+	}
+	next("valid for key 1", hosts[0].ID())
+	if err := vss[1].PutValue(ctx, key, []byte("valid for key 2")); err != nil {
+		t.Fatal(err)
+	}
+	next("valid for key 2", hosts[1].ID())
+
+	wcancel()
+	for range ch {
+	}
+}
+
+func TestSuppressEchoes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	for _, loopback := range []bool{false, true} {
+		var opts []Option
+		if loopback {
+			opts = append(opts, WithLoopback())
+		}
+		vs := newTestStore(ctx, t, opts...)
+
+		var (
+			mx     sync.Mutex
+			events int
+		)
+		unregister, err := vs.RegisterOnValueChanged(key, func(string, []byte) {
+			mx.Lock()
+			events++
+			mx.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			if err := vs.PutValue(ctx, key, []byte(fmt.Sprintf("valid for key %d", i))); err != nil {
+				t.Fatal(err)
+			}
+			// let our own message come back
+			time.Sleep(200 * time.Millisecond)
+		}
+		unregister()
+
+		mx.Lock()
+		if events != 3 {
+			t.Errorf("loopback %t: expected one event per publish, got %d", loopback, events)
+		}
+		mx.Unlock()
+
+		echoes := vs.Stats().EchoesSuppressed
+		if loopback && echoes != 0 {
+			t.Errorf("expected no echo to be suppressed with loopback, got %d", echoes)
+		}
+		if !loopback && echoes < 3 {
+			t.Errorf("expected our messages to be suppressed, got %d", echoes)
+		}
+	}
+}
+
+func TestGetValueFetchesMissing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// records put locally are only found by fetching them
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, WithRebroadcastInitialDelay(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	val := []byte("valid for key 1")
+	if err := vss[0].PutLocal(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	var eg errgroup.Group
+	for i := 0; i < 5; i++ {
+		eg.Go(func() error {
+			got, err := vss[1].GetValue(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, val) {
+				return fmt.Errorf("unexpected value %q", got)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the record until the deadline
+	key = "/namespace/other-key"
+	val = []byte("valid for other-key")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		if err := vss[0].PutValue(ctx, key, val); err != nil {
+			t.Error(err)
+		}
+	}()
+	got, err := vss[1].GetValue(wctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, val) {
+		t.Fatalf("unexpected value %q", got)
+	}
+
+	dctx, dcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer dcancel()
+	if _, err := vss[1].GetValue(dctx, "/namespace/missing-key"); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound at the deadline, got %v", err)
+	}
+}
+
+func TestFullHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	if _, err := vs.SearchValue(ctx, key, WithFullHistory(0, OverflowBlock)); err == nil {
+		t.Fatal("expected an empty buffer to be refused")
+	}
+
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	full, err := vs.SearchValue(sctx, key, WithFullHistory(10, OverflowBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := vs.SearchValue(sctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropping, err := vs.SearchValue(sctx, key, WithFullHistory(2, OverflowDropOldest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := vs.PutValue(ctx, key, []byte(fmt.Sprintf("valid for key %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 5; i++ {
+		if val := <-full; string(val) != fmt.Sprintf("valid for key %d", i) {
+			t.Fatalf("expected record %d, got %q", i, val)
+		}
+	}
+	// a plain search gets a single record, whichever it picked up first
+	if val := <-latest; !strings.HasPrefix(string(val), "valid for key ") {
+		t.Fatalf("expected a record, got %q", val)
+	}
+	for _, want := range []string{"valid for key 4", "valid for key 5"} {
+		if val := <-dropping; string(val) != want {
+			t.Fatalf("expected %q, got %q", want, val)
+		}
+	}
+	if n := vs.Stats().HistoryDropped; n != 3 {
+		t.Fatalf("expected 3 dropped records, got %d", n)
+	}
+
+	scancel()
+	for _, ch := range []<-chan []byte{full, latest, dropping} {
+		for range ch {
+		}
+	}
+
+	// a full buffer holds back that search only
+	blocking, err := vs.SearchValue(ctx, key, WithFullHistory(1, OverflowBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := vs.SearchValue(ctx, key, WithFullHistory(1, OverflowBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val := <-other; string(val) != "valid for key 5" {
+		t.Fatalf("expected the stored record, got %q", val)
+	}
+	for i := 6; i <= 8; i++ {
+		val := fmt.Sprintf("valid for key %d", i)
+		put := make(chan error, 1)
+		go func() {
+			put <- vs.PutValue(ctx, key, []byte(val))
+		}()
+		select {
+		case err := <-put:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected PutValue not to wait for the consumer")
+		}
+		if got := <-other; string(got) != val {
+			t.Fatalf("expected %q, got %q", val, got)
+		}
+	}
+	for i := 5; i <= 8; i++ {
+		if val := <-blocking; string(val) != fmt.Sprintf("valid for key %d", i) {
+			t.Fatalf("expected record %d, got %q", i, val)
+		}
+	}
+}
+
+// countingValidator is a testValidator counting the validations of each
+// record.
+type countingValidator struct {
+	testValidator
+	mx     *sync.Mutex
+	counts map[string]int
+}
+
+func (v countingValidator) Validate(key string, value []byte) error {
+	v.mx.Lock()
+	v.counts[string(value)]++
+	v.mx.Unlock()
+	return v.testValidator.Validate(key, value)
+}
+
+func TestValidateOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v := countingValidator{mx: new(sync.Mutex), counts: map[string]int{}}
+	vs := newTestStore(ctx, t, WithValidationWorkers(1))
+	vs.SetValidator(v)
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	vs.mx.Lock()
+	ti := vs.topics[key]
+	vs.mx.Unlock()
+
+	src := newNetHost(ctx, t).ID()
+	topic := KeyToTopic(key)
+	for i := 1; i <= 3; i++ {
+		val := fmt.Sprintf("valid for key %d", i)
+		msg := &pubsub.Message{
+			Message:      &pubsubpb.Message{Data: []byte(val), Topic: &topic, From: []byte(src)},
+			ReceivedFrom: src,
+		}
+		if res := vs.validate(ctx, src, msg); res != pubsub.ValidationAccept {
+			t.Fatalf("expected the message to be accepted, got %v", res)
+		}
+		vs.handleUpdate(ctx, ti, key, vs.msgUpdate(msg))
+
+		v.mx.Lock()
+		n := v.counts[val]
+		prev := v.counts[fmt.Sprintf("valid for key %d", i-1)]
+		v.mx.Unlock()
+		if n != 1 {
+			t.Fatalf("expected the record to be validated once, got %d", n)
+		}
+		// when received only, the cache vouches for it as the record to beat
+		if i > 1 && prev != 1 {
+			t.Fatalf("expected the replaced record to be validated once, got %d", prev)
+		}
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 3"))
+
+	// no worker available before the message's context ends
+	vs.validateSem <- struct{}{}
+	dctx, dcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer dcancel()
+	msg := &pubsub.Message{
+		Message:      &pubsubpb.Message{Data: []byte("valid for key 4"), Topic: &topic, From: []byte(src)},
+		ReceivedFrom: src,
+	}
+	if res := vs.validate(dctx, src, msg); res != pubsub.ValidationIgnore {
+		t.Fatalf("expected the message to be ignored, got %v", res)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithPersistentSubscriptions(), WithHistory(2))
+	var want []string
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("/namespace/key%d", i)
+		if err := vs.PutLocal(ctx, key, []byte("valid for "+key)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, key)
+	}
+	// canceled, record kept
+	key := "/namespace/key4"
+	if err := vs.PutValue(ctx, key, []byte("valid for "+key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, key)
+	// subscribed, no record
+	if err := vs.Subscribe("/namespace/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := vs.ListKeys(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(all) != fmt.Sprint(want) {
+		t.Fatalf("expected %q, got %q", want, all)
+	}
+
+	var paged []string
+	after := ""
+	for {
+		page, err := vs.ListKeys(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("expected at most 2 keys, got %d", len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		after = page[len(page)-1]
+	}
+	if fmt.Sprint(paged) != fmt.Sprint(want) {
+		t.Fatalf("expected the pages to list %q, got %q", want, paged)
+	}
+
+	if _, err := vs.ListKeys(ctx, "", -1); err == nil {
+		t.Fatal("expected a negative limit to be refused")
+	}
+}
+
+// recordingLogger is a Logger keeping the lines logged.
+type recordingLogger struct {
+	mx    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) logf(level, format string, args ...interface{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+// count returns the number of lines logged at the level containing substr.
+func (l *recordingLogger) count(level, substr string) int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	n := 0
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, level+" ") && strings.Contains(line, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.logf("debug", format, args...)
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.logf("warn", format, args...)
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := new(recordingLogger)
+	vs := newTestStore(ctx, t, WithLogger(l))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	l.mx.Lock()
+	lines := strings.Join(l.lines, "\n")
+	l.mx.Unlock()
+	if !strings.Contains(lines, "subscribed to "+formatKey(key)) {
+		t.Fatalf("expected the subscription to be logged, got %q", lines)
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithLogger(nil)); err == nil {
+		t.Fatal("expected a nil logger to be refused")
+	}
+}
+
+func TestInvalidPeerHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type call struct {
+		key  string
+		from peer.ID
+	}
+	calls := make(chan call, 100)
+	vs := newTestStore(ctx, t, WithInvalidPeerHandler(func(key string, from peer.ID, err error) {
+		if err == nil {
+			t.Error("expected the validation error")
+		}
+		calls <- call{key, from}
+	}))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, spammer := newSigner(t)
+	_, honest := newSigner(t)
+	for i := 0; i < 20; i++ {
+		msg := newSignedMessage(t, nil, spammer, key, []byte(fmt.Sprintf("garbage %d", i)))
+		if res := vs.validate(ctx, spammer, msg); res != pubsub.ValidationReject {
+			t.Fatalf("invalid message %d wasn't rejected: %v", i, res)
+		}
+	}
+	msg := newSignedMessage(t, nil, honest, key, []byte("valid for key"))
+	if res := vs.validate(ctx, honest, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("valid message wasn't accepted: %v", res)
+	}
+
+	select {
+	case c := <-calls:
+		if c.key != key || c.from != spammer {
+			t.Fatalf("unexpected call %v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+	select {
+	case c := <-calls:
+		t.Fatalf("expected the handler to be rate-limited, got another call %v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	counts := vs.InvalidRecords()
+	if len(counts) != 1 || counts[spammer] != 20 {
+		t.Fatalf("unexpected invalid record counts %v", counts)
+	}
+	if n := vs.Stats().InvalidRecords; n != 20 {
+		t.Fatalf("expected 20 invalid records, got %d", n)
+	}
+
+	// the handler is due again after the interval
+	now := time.Now()
+	var ip invalidPeers
+	if !ip.record(spammer, now) || ip.record(spammer, now.Add(invalidPeerInterval/2)) {
+		t.Fatal("expected a single call within the interval")
+	}
+	if !ip.record(spammer, now.Add(invalidPeerInterval)) {
+		t.Fatal("expected a call after the interval")
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithInvalidPeerHandler(nil)); err == nil {
+		t.Fatal("expected a nil handler to be refused")
+	}
+}
+
+func TestBlacklist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithBlacklist(3, time.Minute, time.Minute))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, spammer := newSigner(t)
+	for i := 0; i < 3; i++ {
+		msg := newSignedMessage(t, nil, spammer, key, []byte(fmt.Sprintf("garbage %d", i)))
+		if res := vs.validate(ctx, spammer, msg); res != pubsub.ValidationReject {
+			t.Fatalf("invalid message %d wasn't rejected: %v", i, res)
+		}
+	}
+	if peers := vs.BlacklistedPeers(); len(peers) != 1 || peers[0] != spammer {
+		t.Fatalf("expected the spammer to be blacklisted, got %v", peers)
+	}
+
+	valid := newSignedMessage(t, nil, spammer, key, []byte("valid for key"))
+	if res := vs.validate(ctx, spammer, valid); res != pubsub.ValidationReject {
+		t.Fatalf("message from a blacklisted peer wasn't rejected: %v", res)
+	}
+	if n := vs.Stats().BlacklistRejected; n != 1 {
+		t.Fatalf("expected 1 message rejected by the blacklist, got %d", n)
+	}
+	if n := vs.Stats().InvalidRecords; n != 3 {
+		t.Fatalf("expected the blacklisted message not to be validated, got %d invalid records", n)
+	}
+
+	vs.ClearBlacklist()
+	if peers := vs.BlacklistedPeers(); len(peers) != 0 {
+		t.Fatalf("expected the blacklist to be cleared, got %v", peers)
+	}
+	if res := vs.validate(ctx, spammer, valid); res != pubsub.ValidationAccept {
+		t.Fatalf("message from a cleared peer wasn't accepted: %v", res)
+	}
+
+	// invalid messages spread over windows don't add up, and bans expire
+	ip := invalidPeers{threshold: 2, window: time.Minute, cooldown: time.Hour}
+	now := time.Now()
+	ip.record(spammer, now)
+	ip.record(spammer, now.Add(time.Minute))
+	if ip.banned(spammer, now.Add(time.Minute)) {
+		t.Fatal("expected messages in different windows not to add up")
+	}
+	ip.record(spammer, now.Add(time.Minute+time.Second))
+	if !ip.banned(spammer, now.Add(time.Minute+time.Second)) {
+		t.Fatal("expected the peer to be blacklisted")
+	}
+	if ip.banned(spammer, now.Add(time.Hour+time.Minute+time.Second)) {
+		t.Fatal("expected the blacklisting to expire")
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithBlacklist(0, time.Minute, time.Minute)); err == nil {
+		t.Fatal("expected an invalid policy to be refused")
+	}
+}
+
+func TestInvalidPeersBounded(t *testing.T) {
+	var ip invalidPeers
+	now := time.Now()
+	first := peer.ID("peer-0")
+	for i := 0; i < maxInvalidPeers; i++ {
+		ip.record(peer.ID(fmt.Sprintf("peer-%d", i)), now)
+	}
+	// the first peer sends another one, the second one is the oldest now
+	ip.record(first, now)
+	for i := maxInvalidPeers; i < 2*maxInvalidPeers; i++ {
+		ip.record(peer.ID(fmt.Sprintf("peer-%d", i)), now)
+		if len(ip.peers) > maxInvalidPeers || ip.lru.Len() != len(ip.peers) {
+			t.Fatalf("expected at most %d peers tracked, got %d (%d in the lru)", maxInvalidPeers, len(ip.peers), ip.lru.Len())
+		}
+		if i == maxInvalidPeers {
+			if _, ok := ip.peers[peer.ID("peer-1")]; ok {
+				t.Fatal("expected the oldest peer to be forgotten")
+			}
+			if c, ok := ip.peers[first]; !ok || c.count != 2 {
+				t.Fatal("expected the recent peer to be kept")
+			}
+		}
+	}
+}
+
+func TestLastUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	if _, _, _, ok := vs.LastUpdate(key); ok {
+		t.Fatal("expected no last update for a key not subscribed")
+	}
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	accepted, rejected, _, ok := vs.LastUpdate(key)
+	if !ok || !accepted.IsZero() || !rejected.IsZero() {
+		t.Fatalf("expected no update yet, got %v %v %v", accepted, rejected, ok)
+	}
+
+	before := time.Now()
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	accepted, rejected, from, _ := vs.LastUpdate(key)
+	if accepted.Before(before) || !rejected.IsZero() || from != vs.host().ID() {
+		t.Fatalf("expected the local publish to be accepted, got %v %v %s", accepted, rejected, from)
+	}
+
+	_, spammer := newSigner(t)
+	msg := newSignedMessage(t, nil, spammer, key, []byte("garbage"))
+	if res := vs.validate(ctx, spammer, msg); res != pubsub.ValidationReject {
+		t.Fatalf("invalid message wasn't rejected: %v", res)
+	}
+	accepted2, rejected, from, _ := vs.LastUpdate(key)
+	if !accepted2.Equal(accepted) || rejected.Before(accepted) || from != spammer {
+		t.Fatalf("expected the message to be rejected, got %v %v %s", accepted2, rejected, from)
+	}
+
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, ok := vs.LastUpdate(key); ok {
+		t.Fatal("expected the last update to be forgotten on Cancel")
+	}
+}
+
+func TestSubscriptionLifetime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	lctx, lcancel := context.WithCancel(ctx)
+	if err := vs.Subscribe(key, WithLifetime(lctx)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := vs.Search(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := make(chan struct{}, 1)
+	if _, err := vs.RegisterOnValueChanged(key, func(string, []byte) { called <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	lcancel()
+	select {
+	case _, ok := <-s.Values():
+		if ok {
+			t.Fatal("expected no value")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search didn't end with the subscription")
+	}
+	if s.Err() != ErrSubscriptionEnded {
+		t.Fatalf("expected ErrSubscriptionEnded, got %v", s.Err())
+	}
+	err = waitUntil(ctx, func(context.Context) (bool, error) {
+		_, _, _, ok := vs.LastUpdate(key)
+		return !ok, nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("subscription wasn't canceled")
+	}
+
+	// later subscriptions aren't bound to the ended lifetime
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key"))
+	select {
+	case <-called:
+		t.Fatal("callback called after the subscription ended")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := vs.Subscribe(key, WithLifetime(nil)); err == nil {
+		t.Fatal("expected a nil lifetime to be refused")
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithPassThrough())
+	key := "/namespace/key"
+	updates, err := vs.SearchValueWithMeta(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-updates:
+		if string(u.Value) != "valid for key" {
+			t.Fatalf("unexpected update %q", u.Value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher wasn't notified")
+	}
+
+	if _, err := vs.records.GetBest(ctx, key); err != routing.ErrNotFound {
+		t.Fatalf("expected the record not to be stored, got %v", err)
+	}
+	if _, err := vs.GetValue(ctx, key); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// with a deadline, GetValue waits for the next record
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = vs.PutValue(ctx, key, []byte("valid for key 2"))
+	}()
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	val, err := vs.GetValue(wctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "valid for key 2" {
+		t.Fatalf("unexpected value %q", val)
+	}
+}
+
+func TestDatastorePrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	recKey := dshelp.NewKeyFromBinary([]byte(key))
+	other := ds.NewKey("/other/entry")
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	if err := d.Put(ctx, other, []byte("not ours")); err != nil {
+		t.Fatal(err)
+	}
+	vs := newTestStore(ctx, t, WithDatastore(d))
+	if err := vs.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, ds.NewKey(DefaultDatastorePrefix).Child(recKey)); err != nil {
+		t.Fatalf("expected the record under the prefix: %s", err)
+	}
+	if ok, _ := d.Has(ctx, recKey); ok {
+		t.Fatal("expected no record at the root")
+	}
+	if dk := vs.ExplainKey(key).DatastoreKey; dk != ds.NewKey(DefaultDatastorePrefix).Child(recKey).String() {
+		t.Fatalf("expected the explained datastore key to be the prefixed one, got %s", dk)
+	}
+
+	// records written at the root are moved under the prefix
+	legacy := dssync.MutexWrap(ds.NewMapDatastore())
+	for k, v := range map[ds.Key]string{recKey: "valid for key", metaKey(key): "corrupt metadata", other: "not ours"} {
+		if err := legacy.Put(ctx, k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs = newTestStore(ctx, t, WithDatastore(legacy), WithDatastorePrefix("/vs"), WithDatastoreMigration())
+	val, err := vs.GetValue(ctx, key, routing.Offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "valid for key" {
+		t.Fatalf("unexpected value %q", val)
+	}
+	for k, want := range map[ds.Key]bool{recKey: false, metaKey(key): false, other: true, ds.NewKey("/vs").Child(metaKey(key)): true} {
+		if ok, _ := legacy.Has(ctx, k); ok != want {
+			t.Fatalf("expected %s to exist: %v", k, want)
+		}
+	}
+
+	// the root prefix keeps the entries where they were
+	vs = newTestStore(ctx, t, WithDatastore(legacy), WithDatastorePrefix("/"))
+	if _, err := vs.GetValue(ctx, key, routing.Offline); err != routing.ErrNotFound {
+		t.Fatalf("expected the moved record not to be found at the root, got %v", err)
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithDatastorePrefix("vs")); err == nil {
+		t.Fatal("expected a relative prefix to be refused")
+	}
+}
+
+type quorumKey struct{}
+
+// quorum stands for the DHT quorum option.
+func quorum(n int) routing.Option {
+	return func(opts *routing.Options) error {
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[quorumKey{}] = n
+		return nil
+	}
+}
+
+func TestUnsupportedOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	value := []byte("valid for key")
+
+	var uerr *UnsupportedOptionError
+	if err := vs.PutValue(ctx, key, value, quorum(1)); !errors.As(err, &uerr) || uerr.Key != (quorumKey{}) {
+		t.Fatalf("expected the quorum to be unsupported, got %v", err)
+	}
+	if err := vs.PutValues(ctx, map[string][]byte{key: value}, routing.Offline); !errors.As(err, &uerr) || uerr.Key != "Offline" {
+		t.Fatalf("expected an offline put to be unsupported, got %v", err)
+	}
+	if _, err := vs.GetValue(ctx, key, routing.Expired); !errors.As(err, &uerr) || uerr.Key != "Expired" {
+		t.Fatalf("expected expired records to be unsupported, got %v", err)
+	}
+	if _, err := vs.SearchValue(ctx, key, routing.Offline); !errors.As(err, &uerr) {
+		t.Fatalf("expected an offline search to be unsupported, got %v", err)
+	}
+	if err := vs.Subscribe(key, SkipSecondary); !errors.As(err, &uerr) {
+		t.Fatalf("expected SkipSecondary to be unsupported by Subscribe, got %v", err)
+	}
+	failing := func(*routing.Options) error { return errors.New("bad option") }
+	if _, err := vs.GetValue(ctx, key, failing); err == nil || err.Error() != "bad option" {
+		t.Fatalf("expected the option to fail, got %v", err)
+	}
+
+	if err := vs.PutValue(ctx, key, value, SkipSecondary); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, value)
+	if _, err := vs.GetValue(ctx, key, routing.Offline, WithLifetime(ctx)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRememberedPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	pss := make([]*pubsub.PubSub, len(hosts))
+	for i, h := range hosts {
+		var err error
+		if pss[i], err = pubsub.NewFloodSub(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	vsA, err := NewPubsubValueStore(ctx, hosts[0], pss[0], testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vsB, err := NewPubsubValueStore(ctx, hosts[1], pss[1], testValidator{}, WithDatastore(d), WithRememberedPeers(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	for _, vs := range []*PubsubValueStore{vsA, vsB} {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	if err := vsB.SubscribeAndWait(wctx, key); err != nil {
+		t.Fatal(err)
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		peers, err := vsB.getRememberedPeers(ctx, key)
+		return len(peers) == 1 && peers[0].Info.ID == hosts[0].ID() && len(peers[0].Info.Addrs) > 0, err
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("topic peer wasn't remembered")
+	}
+
+	// restart, disconnected
+	if err := vsB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts[1].Network().ClosePeer(hosts[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPubsubValueStore(ctx, hosts[1], pss[1], testValidator{}, WithMaxConcurrentDials(0)); err == nil {
+		t.Fatal("expected a max of 0 concurrent dials to be refused")
+	}
+	if _, err := NewPubsubValueStore(ctx, hosts[1], pss[1], testValidator{}, WithPeerDialTarget(2, 3)); err == nil {
+		t.Fatal("expected a dial target above the remembered peers to be refused")
+	}
+	// the unreachable peer comes second, and isn't dialed once the first is
+	// connected
+	vsB, err = NewPubsubValueStore(ctx, hosts[1], pss[1], testValidator{}, WithDatastore(d), WithRememberedPeers(time.Hour), WithMaxConcurrentDials(1), WithPeerDialTarget(DefaultMaxRememberedPeers, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vsB.Close()
+	peers, err := vsB.getRememberedPeers(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, gone := newSigner(t)
+	peers = append(peers, rememberedPeer{Info: peer.AddrInfo{ID: gone}, Failures: maxPeerFailures - 1})
+	if err := vsB.putRememberedPeers(ctx, key, peers); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vsB.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		peers, err := vsB.getRememberedPeers(ctx, key)
+		return len(peers) == 2 && hosts[1].Network().Connectedness(hosts[0].ID()) == network.Connected, err
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected the remembered peer to be dialed")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if peers, _ := vsB.getRememberedPeers(ctx, key); len(peers) != 2 || peers[1].Failures != maxPeerFailures-1 {
+		t.Fatalf("expected the unreachable peer not to be dialed, got %v", peers)
+	}
+
+	// unreachable peers are dialed again until forgotten
+	other := "/namespace/other"
+	if err := vsB.putRememberedPeers(ctx, other, []rememberedPeer{{Info: peer.AddrInfo{ID: gone}}}); err != nil {
+		t.Fatal(err)
+	}
+	attempts := vsB.Stats().PeerDialAttempts
+	if err := vsB.Subscribe(other); err != nil {
+		t.Fatal(err)
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		peers, err := vsB.getRememberedPeers(ctx, other)
+		return len(peers) == 0, err
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected the unreachable peer to be forgotten")
+	}
+	if n := vsB.Stats().PeerDialAttempts - attempts; n != maxPeerFailures {
+		t.Fatalf("expected %d dial attempts, got %d", maxPeerFailures, n)
+	}
+}
+
+// registeringPubsub counts the topic validator registrations.
+type registeringPubsub struct {
+	*pubsub.PubSub
+	registered int32
+}
+
+func (r *registeringPubsub) RegisterTopicValidator(topic string, validator interface{}, opts ...pubsub.ValidatorOpt) error {
+	atomic.AddInt32(&r.registered, 1)
+	return r.PubSub.RegisterTopicValidator(topic, validator, opts...)
+}
+
+func TestConcurrentSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rps := &registeringPubsub{PubSub: fs}
+	vs, err := NewPubsubValueStore(ctx, h, rps, testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	errs := make(chan error, 50)
+	start := make(chan struct{})
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			<-start
+			errs <- vs.Subscribe(key)
+		}()
+	}
+	close(start)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&rps.registered); n != 1 {
+		t.Fatalf("expected the validator to be registered once, got %d", n)
+	}
+
+	// the validator is unregistered along with the subscription
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RegisterTopicValidator(KeyToTopic(key), func(context.Context, peer.ID, *pubsub.Message) bool { return true }); err != nil {
+		t.Fatalf("expected the validator to be unregistered: %s", err)
+	}
+}
+
+// lifecyclePubsub tracks the registered topic validators, counting the
+// registrations of a validator already registered and the unregistrations of
+// one that isn't. hold, if set, blocks registrations until closed.
+type lifecyclePubsub struct {
+	*pubsub.PubSub
+	hold chan struct{}
+
+	mx         sync.Mutex
+	registered map[string]bool
+	misuses    int
+}
+
+func (l *lifecyclePubsub) RegisterTopicValidator(topic string, validator interface{}, opts ...pubsub.ValidatorOpt) error {
+	if l.hold != nil {
+		<-l.hold
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.registered[topic] {
+		l.misuses++
+	}
+	l.registered[topic] = true
+	return l.PubSub.RegisterTopicValidator(topic, validator, opts...)
+}
+
+func (l *lifecyclePubsub) UnregisterTopicValidator(topic string) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if !l.registered[topic] {
+		l.misuses++
+	}
+	delete(l.registered, topic)
+	return l.PubSub.UnregisterTopicValidator(topic)
+}
+
+func (l *lifecyclePubsub) check(t *testing.T, topic string, subscribed bool) {
+	t.Helper()
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.misuses != 0 {
+		t.Fatalf("%d registrations out of order", l.misuses)
+	}
+	if l.registered[topic] != subscribed {
+		t.Fatalf("expected the validator registered to be %t", subscribed)
+	}
+}
+
+func TestValidatorLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lps := &lifecyclePubsub{PubSub: fs, registered: make(map[string]bool)}
+	vs, err := NewPubsubValueStore(ctx, h, lps, testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "/namespace/key"
+	topic := KeyToTopic(key)
+
+	for i := 0; i < 3; i++ {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+		lps.check(t, topic, true)
+		if _, err := vs.Cancel(key); err != nil {
+			t.Fatal(err)
+		}
+		lps.check(t, topic, false)
+	}
+
+	// racing subscriptions and cancellations
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = vs.Subscribe(key)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = vs.Cancel(key)
+		}()
+	}
+	wg.Wait()
+	lps.check(t, topic, len(vs.GetSubscriptions()) == 1)
+
+	// a cancellation racing the initial registration
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	lps.hold = make(chan struct{})
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- vs.Subscribe(key) }()
+	canceled := make(chan struct{})
+	go func() {
+		defer close(canceled)
+		time.Sleep(20 * time.Millisecond)
+		_, _ = vs.Cancel(key)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(lps.hold)
+	if err := <-subscribed; err != nil {
+		t.Fatal(err)
+	}
+	<-canceled
+	lps.check(t, topic, false)
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected the cancellation to win, got %v", subs)
+	}
+}
+
+// stallingRecordStore stalls the first read after stall is set, once it
+// read the record, until released.
+type stallingRecordStore struct {
+	memRecordStore
+	stall   chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingRecordStore) GetBest(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.memRecordStore.GetBest(ctx, key)
+	s.mx.Lock()
+	stall := s.stall
+	s.stall = nil
+	s.mx.Unlock()
+	if stall != nil {
+		close(stall)
+		<-s.release
+	}
+	return val, err
+}
+
+func TestSearchInitialValueRace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, withMeta := range []bool{false, true} {
+		rs := &stallingRecordStore{memRecordStore: memRecordStore{recs: map[string][]byte{}}, release: make(chan struct{})}
+		vs := newTestStore(ctx, t, WithRecordStore(rs))
+		key := "/namespace/key"
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+		if withMeta {
+			if err := vs.PutValue(ctx, key, []byte("valid for key 1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the search reads the stored record, then a better one is put before
+		// it watches the key; the cache would spare it the read
+		vs.invalidateBest(key)
+		stalled := make(chan struct{})
+		rs.mx.Lock()
+		rs.stall = stalled
+		rs.mx.Unlock()
+		values := make(chan []byte, 2)
+		go func() {
+			if withMeta {
+				ch, err := vs.SearchValueWithMeta(ctx, key)
+				if err != nil {
+					t.Error(err)
+					close(values)
+					return
+				}
+				for u := range ch {
+					values <- u.Value
+				}
+				return
+			}
+			ch, err := vs.SearchValue(ctx, key)
+			if err != nil {
+				t.Error(err)
+				close(values)
+				return
+			}
+			for v := range ch {
+				values <- v
+			}
+		}()
+		<-stalled
+		put := make(chan error, 1)
+		go func() { put <- vs.PutValue(ctx, key, []byte("valid for key 2")) }()
+		time.Sleep(50 * time.Millisecond)
+		close(rs.release)
+		if err := <-put; err != nil {
+			t.Fatal(err)
+		}
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case v := <-values:
+				if string(v) != "valid for key 2" {
+					continue
+				}
+			case <-timeout:
+				t.Fatalf("expected the newest record to be delivered (meta: %t)", withMeta)
+			}
+			break
+		}
+	}
+}
+
+func TestLogThrottle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := new(recordingLogger)
+	vs := newTestStore(ctx, t, WithLogger(l))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, spammer := newSigner(t)
+	spam := func(n int) {
+		for i := 0; i < n; i++ {
+			msg := newSignedMessage(t, nil, spammer, key, []byte(fmt.Sprintf("garbage %d", i)))
+			if res := vs.validate(ctx, spammer, msg); res != pubsub.ValidationReject {
+				t.Fatalf("invalid message %d wasn't rejected: %v", i, res)
+			}
+		}
+	}
+	spam(10)
+	if n := l.count("warn", "rejecting message"); n != 1 {
+		t.Fatalf("expected a single warning, got %d", n)
+	}
+	if n := l.count("debug", "rejecting message"); n != 9 {
+		t.Fatalf("expected the suppressed warnings at debug level, got %d", n)
+	}
+	if n := vs.Stats().LogsSuppressed; n != 9 {
+		t.Fatalf("expected 9 suppressed warnings, got %d", n)
+	}
+
+	// canceling the key resets its throttling
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	spam(1)
+	if n := l.count("warn", "rejecting message"); n != 2 {
+		t.Fatalf("expected a warning after canceling the key, got %d", n)
+	}
+
+	// once the interval is over, the warning tells how many were suppressed
+	var lt logThrottle
+	now := time.Now()
+	if ok, _ := lt.allow(key, logInvalid, now); !ok {
+		t.Fatal("expected the first warning to be logged")
+	}
+	if ok, _ := lt.allow(key, logStore, now); !ok {
+		t.Fatal("expected the first warning of another class to be logged")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := lt.allow(key, logInvalid, now.Add(time.Second)); ok {
+			t.Fatal("expected a similar warning to be suppressed")
+		}
+	}
+	if ok, n := lt.allow(key, logInvalid, now.Add(logThrottleInterval)); !ok || n != 3 {
+		t.Fatalf("expected the warning to be logged with 3 suppressed, got %t, %d", ok, n)
+	}
+
+	// the state is bounded
+	for i := 0; i < maxThrottledLogs; i++ {
+		lt.allow(fmt.Sprintf("/namespace/key%d", i), logInvalid, now)
+	}
+	if len(lt.logs) > maxThrottledLogs {
+		t.Fatalf("expected at most %d tracked warnings, got %d", maxThrottledLogs, len(lt.logs))
+	}
+	if ok, _ := lt.allow("/namespace/other", logInvalid, now.Add(logThrottleInterval)); !ok || len(lt.logs) > 2 {
+		t.Fatalf("expected the stale warnings to be swept, got %d", len(lt.logs))
+	}
+}
+
+func TestPublishQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the message validator runs on PutValue, then on every publish
+	type putKey struct{}
+	putCtx := context.WithValue(ctx, putKey{}, true)
+	var publishes, failing int32
+	validator := func(ctx context.Context, key string, value []byte, from peer.ID) bool {
+		if ctx.Value(putKey{}) != nil {
+			return true
+		}
+		atomic.AddInt32(&publishes, 1)
+		return atomic.AddInt32(&failing, -1) < 0
+	}
+	for _, opt := range []Option{WithPublishQueue(0), WithPublishQueue(-time.Second)} {
+		if _, err := NewPubsubValueStore(ctx, newNetHost(ctx, t), nil, testValidator{}, opt); err == nil {
+			t.Fatal("expected an invalid max age to be refused")
+		}
+	}
+
+	// published once the failures are over
+	atomic.StoreInt32(&failing, 2)
+	vs := newTestStore(ctx, t, WithPublishQueue(time.Minute), WithMessageValidator(validator))
+	key := "/namespace/key"
+	if err := vs.PutValue(putCtx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if n := vs.Stats().QueuedPublishes; n != 1 {
+		t.Fatalf("expected a queued record, got %d", n)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key"))
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		return vs.Stats().QueuedPublishes == 0, nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("the queued record wasn't published")
+	}
+	if n := atomic.LoadInt32(&publishes); n != 3 {
+		t.Fatalf("expected 3 publishes, got %d", n)
+	}
+	if n := vs.Stats().PublishesDropped; n != 0 {
+		t.Fatalf("expected no dropped record, got %d", n)
+	}
+
+	// dropped once too old
+	atomic.StoreInt32(&failing, 1000)
+	vs = newTestStore(ctx, t, WithPublishQueue(100*time.Millisecond), WithMessageValidator(validator))
+	if err := vs.PutValue(putCtx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		s := vs.Stats()
+		return s.QueuedPublishes == 0 && s.PublishesDropped == 1, nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("the stale record wasn't dropped: %+v", vs.Stats())
+	}
+
+	// newer records replace the queued one, which Close drops
+	vs = newTestStore(ctx, t, WithPublishQueue(time.Minute), WithMessageValidator(validator))
+	for _, v := range []string{"valid for key 1", "valid for key 2"} {
+		if err := vs.PutValue(putCtx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := vs.Stats().QueuedPublishes; n != 1 {
+		t.Fatalf("expected a single queued record, got %d", n)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := vs.Stats(); s.QueuedPublishes != 0 || s.PublishesDropped != 1 {
+		t.Fatalf("expected the queued record to be dropped on close: %+v", s)
+	}
+}
+
+func TestJoinRepublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewPubsubValueStore(ctx, newNetHost(ctx, t), nil, testValidator{}, WithJoinRepublish(0)); err == nil {
+		t.Fatal("expected an invalid interval to be refused")
+	}
+
+	hosts := newNetHosts(ctx, t, 3)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, WithJoinRepublish(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := "/namespace/key"
+	if err := vss[0].PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for i, vs := range vss[1:] {
+		if err := hosts[i+1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+			t.Fatal(err)
+		}
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+		err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+			val, err := vs.GetValue(ctx, key, routing.Offline)
+			return err == nil && string(val) == "valid for key", nil
+		}, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("store %d didn't get the record", i+1)
+		}
+	}
+
+	// the second join is within the interval
+	if n := vss[0].Stats().JoinRepublishes; n != 1 {
+		t.Fatalf("expected a single republish, got %d", n)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts []Option
+		if i == 0 {
+			opts = append(opts, WithCompression())
+		} else {
+			opts = append(opts, WithMaxRecordSize(0))
+		}
+		vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "/namespace/key"
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	large := []byte("valid for key 1 " + strings.Repeat("a", 4096))
+	wire := vss[0].compress(large)
+	if !bytes.HasPrefix(wire, compressedMagic) || len(wire) >= len(large) {
+		t.Fatalf("expected the value to be compressed, got %d bytes", len(wire))
+	}
+	if small := []byte("valid for key"); !bytes.Equal(vss[0].compress(small), small) {
+		t.Fatal("expected a value that doesn't shrink to be published as is")
+	}
+
+	// both ways between a compressing store and a plain one
+	for i, val := range [][]byte{large, []byte("valid for key 2 " + strings.Repeat("b", 4096))} {
+		if err := vss[i].PutValue(ctx, key, val); err != nil {
+			t.Fatal(err)
+		}
+		for j, vs := range vss {
+			err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+				got, err := vs.GetValue(ctx, key, routing.Offline)
+				return err == nil && bytes.Equal(got, val), nil
+			}, 10*time.Millisecond)
+			if err != nil {
+				t.Fatalf("store %d didn't get the decompressed record %d", j, i)
+			}
+		}
+	}
+
+	_, from := newSigner(t)
+	corrupt := append(append([]byte{}, compressedMagic...), "valid for key 3"...)
+	if res := vss[1].validate(ctx, from, newSignedMessage(t, nil, from, key, corrupt)); res != pubsub.ValidationReject {
+		t.Fatalf("expected a corrupt compressed value to be rejected, got %v", res)
+	}
+
+	// without a maximum record size, decompression still stops at a ceiling
+	bomb := vss[0].compress(make([]byte, maxDecompressedSize+1))
+	if _, err := vss[1].decompress(bomb); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("expected a value decompressing past the ceiling to be rejected, got %v", err)
+	}
+	if res := vss[1].validate(ctx, from, newSignedMessage(t, nil, from, key, bomb)); res != pubsub.ValidationReject {
+		t.Fatalf("expected a value decompressing past the ceiling to be rejected, got %v", res)
+	}
+}
+
+func TestSearchValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithNamespaces("namespace"))
+	key1, key2, key3 := "/namespace/key1", "/namespace/key2", "/namespace/key3"
+	if err := vs.PutValue(ctx, key1, []byte("valid for key1")); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := vs.SearchValues(ctx, []string{key1, key2, "/other/key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() KeyedValue {
+		t.Helper()
+		select {
+		case kv, ok := <-ms.Values():
+			if !ok {
+				t.Fatal("search ended")
+			}
+			return kv
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update")
+		}
+		return KeyedValue{}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case kv := <-ms.Values():
+			t.Fatalf("unexpected update %v", kv)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	got := map[string]KeyedValue{}
+	for i := 0; i < 2; i++ {
+		kv := next()
+		got[kv.Key] = kv
+	}
+	if kv := got[key1]; kv.Err != nil || string(kv.Value) != "valid for key1" {
+		t.Fatalf("expected the stored record of %s, got %v", key1, kv)
+	}
+	if kv := got["/other/key"]; !errors.Is(kv.Err, ErrUnsupportedNamespace) {
+		t.Fatalf("expected the unsupported key to fail, got %v", kv)
+	}
+
+	// only the latest record of a key is sent
+	for _, v := range []string{"valid for key2 1", "valid for key2 2"} {
+		if err := vs.PutValue(ctx, key2, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if kv := next(); kv.Key != key2 || string(kv.Value) != "valid for key2 2" {
+		t.Fatalf("expected the latest record of %s, got %v", key2, kv)
+	}
+	expectNone()
+
+	// removed keys aren't watched anymore, added ones start with their record
+	ms.Remove(key1)
+	if err := vs.PutValue(ctx, key1, []byte("valid for key1 2")); err != nil {
+		t.Fatal(err)
+	}
+	expectNone()
+	if err := vs.PutValue(ctx, key3, []byte("valid for key3")); err != nil {
+		t.Fatal(err)
+	}
+	ms.Add(key3, key2)
+	if kv := next(); kv.Key != key3 || string(kv.Value) != "valid for key3" {
+		t.Fatalf("expected the stored record of %s, got %v", key3, kv)
+	}
+	expectNone()
+
+	ms.Cancel()
+	if _, ok := <-ms.Values(); ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	ms.Cancel()
+}
+
+func TestPutValueIf(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	err := vs.PutValueIf(ctx, key, []byte("valid for key 0"), []byte("valid for key 1"))
+	var cerr *CASMismatchError
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrCASMismatch) || cerr.Current != nil {
+		t.Fatalf("expected a mismatch without a current record, got %v", err)
+	}
+	if err := vs.PutValueIf(ctx, key, nil, []byte("valid for key 1")); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 1"))
+
+	// only one of the racing writers wins, the others see its record
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- vs.PutValueIf(ctx, key, []byte("valid for key 1"), []byte(fmt.Sprintf("valid for key 2 %d", i)))
+		}(i)
+	}
+	var won int
+	var mismatches [][]byte
+	for i := 0; i < writers; i++ {
+		err := <-errs
+		if err == nil {
+			won++
+			continue
+		}
+		if !errors.As(err, &cerr) {
+			t.Fatal(err)
+		}
+		mismatches = append(mismatches, cerr.Current)
+	}
+	if won != 1 {
+		t.Fatalf("expected one writer to win, %d did", won)
+	}
+	winner, err := vs.GetValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cur := range mismatches {
+		if !bytes.Equal(cur, winner) {
+			t.Fatalf("expected the losers to see %q, got %q", winner, cur)
+		}
+	}
+
+	var seen []byte
+	err = vs.PutValueIfFunc(ctx, key, func(current []byte) bool {
+		seen = current
+		return bytes.HasPrefix(current, []byte("valid for key 2"))
+	}, []byte("valid for key 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seen, winner) {
+		t.Fatalf("expected the match function to get %q, got %q", winner, seen)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 3"))
+
+	if err := vs.PutValueIf(ctx, key, []byte("valid for key 3"), []byte("invalid for key")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected an invalid record to be refused, got %v", err)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vss[i], err = NewPubsubValueStore(ctx, h, fs, testValidator{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vsA, vsB := vss[0], vss[1]
+
+	key := "/namespace/key"
+	if err := vsA.PutValue(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := vsB.Bootstrap(ctx); err != nil {
+		t.Fatalf("expected bootstrapping without subscriptions to succeed, got %v", err)
+	}
+	if err := vsB.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer is only known once the subscription started
+	info := hosts[0].Peerstore().PeerInfo(hosts[0].ID())
+	if err := vsB.putRememberedPeers(ctx, key, []rememberedPeer{{Info: info}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vsB.Bootstrap(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hosts[1].Network().Connectedness(hosts[0].ID()) != network.Connected {
+		t.Fatal("expected the remembered peer to be dialed")
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		val, err := vsB.GetValue(ctx, key, routing.Offline)
+		return err == nil && bytes.Equal(val, []byte("valid for key")), nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected the record to be fetched")
+	}
+
+	cctx, ccancel := context.WithCancel(ctx)
+	ccancel()
+	err = vsB.Bootstrap(cctx)
+	var berr BootstrapError
+	if !errors.As(err, &berr) || len(berr) != 1 || berr[key] != context.Canceled {
+		t.Fatalf("expected the key to fail with the context, got %v", err)
+	}
+
+	vsB.Close()
+	if err := vsB.Bootstrap(ctx); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestBootstrapOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 3)
+	info := func(i int) peer.AddrInfo { return hosts[i].Peerstore().PeerInfo(hosts[i].ID()) }
+	connected := func(i int) bool {
+		wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+		defer wcancel()
+		return waitUntil(wctx, func(context.Context) (bool, error) {
+			return hosts[0].Network().Connectedness(hosts[i].ID()) == network.Connected, nil
+		}, 10*time.Millisecond) == nil
+	}
+
+	fs, err := pubsub.NewFloodSub(ctx, hosts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, hosts[0], fs, testValidator{},
+		WithRememberedPeers(time.Hour), WithSubscribeDefaults(WithBootstrapPeers([]peer.AddrInfo{info(2)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := "/namespace/private"
+	if err := vs.Subscribe(private, WithoutBootstrap()); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.putRememberedPeers(ctx, private, []rememberedPeer{{Info: info(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Bootstrap(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hosts[0].Network().Connectedness(hosts[1].ID()) == network.Connected {
+		t.Fatal("expected the key not to be bootstrapped")
+	}
+	// remembered for the later subscriptions
+	if _, err := vs.Cancel(private); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.GetValue(ctx, private); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if !vs.bootstrapFor(private).skip {
+		t.Fatal("expected the key to stay without bootstrap")
+	}
+
+	if err := vs.Subscribe("/namespace/static", WithBootstrapPeers([]peer.AddrInfo{info(1)})); err != nil {
+		t.Fatal(err)
+	}
+	if !connected(1) {
+		t.Fatal("expected the bootstrap peer to be dialed")
+	}
+
+	if _, err := vs.GetValue(ctx, "/namespace/implicit"); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if !connected(2) {
+		t.Fatal("expected the default bootstrap peer to be dialed")
+	}
+
+	if err := vs.Subscribe("/namespace/key", WithBootstrapPeers(nil)); err == nil {
+		t.Fatal("expected no bootstrap peers to be refused")
+	}
+}
+
+// blockingValidator is a testValidator blocking until release is closed.
+type blockingValidator struct {
+	testValidator
+	release chan struct{}
+}
+
+func (v blockingValidator) Validate(key string, value []byte) error {
+	<-v.release
+	return v.testValidator.Validate(key, value)
+}
+
+// optsPubsub records the number of options topic validators are registered
+// with.
+type optsPubsub struct {
+	*pubsub.PubSub
+	opts int32
+}
+
+func (o *optsPubsub) RegisterTopicValidator(topic string, validator interface{}, opts ...pubsub.ValidatorOpt) error {
+	atomic.StoreInt32(&o.opts, int32(len(opts)))
+	return o.PubSub.RegisterTopicValidator(topic, validator, opts...)
+}
+
+func TestValidationTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []Option{WithValidationTimeout(0), WithTopicValidationConcurrency(0)} {
+		if _, err := NewPubsubValueStore(ctx, h, fs, testValidator{}, opt); err == nil {
+			t.Fatal("expected an invalid validator limit to be refused")
+		}
+	}
+	ops := &optsPubsub{PubSub: fs}
+	v := blockingValidator{release: make(chan struct{})}
+	vs, err := NewPubsubValueStore(ctx, h, ops, v, WithValidationTimeout(50*time.Millisecond), WithTopicValidationConcurrency(4), WithInlineValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vs.Close()
+
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&ops.opts); n != 3 {
+		t.Fatalf("expected the topic validator to be registered with 3 options, got %d", n)
+	}
+
+	src := newNetHost(ctx, t).ID()
+	topic := KeyToTopic(key)
+	msg := &pubsub.Message{
+		Message:      &pubsubpb.Message{Data: []byte("valid for key"), Topic: &topic, From: []byte(src)},
+		ReceivedFrom: src,
+	}
+	start := time.Now()
+	if res := vs.validate(ctx, src, msg); res != pubsub.ValidationIgnore {
+		t.Fatalf("expected the message to be ignored, got %v", res)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected the validation to time out, took %s", d)
+	}
+	if n := vs.InvalidRecords()[src]; n != 0 {
+		t.Fatalf("expected the peer not to be held responsible, got %d invalid records", n)
+	}
+
+	close(v.release)
+	if res := vs.validate(ctx, src, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("expected the message to be accepted, got %v", res)
+	}
+}
+
+func TestCancelFromCallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+
+	var unregister func()
+	canceled := make(chan error, 1)
+	unregister, err := vs.RegisterOnValueChanged(key, func(string, []byte) {
+		unregister()
+		_, err := vs.Cancel(key)
+		canceled <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs.mx.Lock()
+	ti := vs.topics[key]
+	vs.mx.Unlock()
+
+	if err := vs.PutLocal(ctx, key, []byte("valid for key")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-canceled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancel didn't return")
+	}
+
+	// the subscription and its helpers are done by the time Cancel returns
+	select {
+	case <-ti.finished:
+	default:
+		t.Fatal("expected the subscription to be torn down")
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", subs)
+	}
+}
+
+func TestWatcherCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key1, key2, key3 := "/namespace/key1", "/namespace/key2", "/namespace/key3"
+
+	sctx, scancel := context.WithCancel(ctx)
+	if _, err := vs.SearchValue(sctx, key1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.SearchValue(ctx, key1); err != nil {
+		t.Fatal(err)
+	}
+	unregister, err := vs.RegisterOnValueChanged(key2, func(string, []byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lctx, lcancel := context.WithCancel(ctx)
+	if _, err := vs.SearchValue(ctx, key3, WithLifetime(lctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n1, n2 := vs.WatcherCount(key1), vs.WatcherCount(key2); n1 != 2 || n2 != 1 {
+		t.Fatalf("expected 2 and 1 watchers, got %d and %d", n1, n2)
+	}
+	if n := vs.TotalWatchers(); n != 4 {
+		t.Fatalf("expected 4 watchers in total, got %d", n)
+	}
+	if keys := vs.KeysWatchedMoreThan(1); len(keys) != 1 || keys[key1] != 2 {
+		t.Fatalf("expected only %s to have more than 1 watcher, got %v", key1, keys)
+	}
+	if w := vs.Stats().Watchers; len(w) != 3 || w[key1] != 2 || w[key2] != 1 || w[key3] != 1 {
+		t.Fatalf("unexpected watcher counts %v", w)
+	}
+
+	// watchers leave as their context ends, they are unregistered, or the
+	// key's watchers are ended
+	scancel()
+	unregister()
+	lcancel()
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	err = waitUntil(wctx, func(context.Context) (bool, error) {
+		return vs.WatcherCount(key1) == 1 && vs.TotalWatchers() == 1, nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected 1 watcher left, got %v", vs.KeysWatchedMoreThan(0))
+	}
+	if n := vs.WatcherCount("/namespace/other"); n != 0 {
+		t.Fatalf("expected no watchers of an unknown key, got %d", n)
+	}
+}
+
+func TestSearchLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	if _, err := vs.SearchValue(ctx, key, WithMaxResults(0)); err == nil {
+		t.Fatal("expected a max of 0 results to be refused")
+	}
+	if err := vs.PutValue(ctx, key, []byte("valid for key 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	recv := func(ch <-chan []byte) ([]byte, bool) {
+		t.Helper()
+		select {
+		case val, ok := <-ch:
+			return val, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update")
+		}
+		return nil, false
+	}
+	put := func(val string) {
+		t.Helper()
+		if err := vs.PutValue(ctx, key, []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the stored record counts
+	limited, err := vs.Search(ctx, key, WithMaxResults(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped, err := vs.Search(ctx, key, WithStopWhen(func(val []byte) bool {
+		return bytes.HasSuffix(val, []byte("3"))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Search{limited, stopped} {
+		if val, _ := recv(s.Values()); string(val) != "valid for key 1" {
+			t.Fatalf("expected the stored record first, got %q", val)
+		}
+	}
+
+	put("valid for key 2")
+	for _, s := range []*Search{limited, stopped} {
+		if val, _ := recv(s.Values()); string(val) != "valid for key 2" {
+			t.Fatalf("expected the new record, got %q", val)
+		}
+	}
+	if _, ok := recv(limited.Values()); ok || limited.Err() != nil {
+		t.Fatalf("expected the search to end after 2 results, got %v", limited.Err())
+	}
+
+	put("valid for key 3")
+	if val, _ := recv(stopped.Values()); string(val) != "valid for key 3" {
+		t.Fatalf("expected the new record, got %q", val)
+	}
+	if _, ok := recv(stopped.Values()); ok || stopped.Err() != nil {
+		t.Fatalf("expected the search to end once good enough, got %v", stopped.Err())
+	}
+
+	// ending before the limit
+	sctx, scancel := context.WithCancel(ctx)
+	s, err := vs.Search(sctx, key, WithMaxResults(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv(s.Values())
+	scancel()
+	if _, ok := recv(s.Values()); ok || s.Err() != context.Canceled {
+		t.Fatalf("expected the search to end with its context, got %v", s.Err())
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	if err := waitUntil(wctx, func(context.Context) (bool, error) {
+		return vs.TotalWatchers() == 0, nil
+	}, 10*time.Millisecond); err != nil {
+		t.Fatalf("expected the searches to be detached, got %v", vs.KeysWatchedMoreThan(0))
+	}
+}
+
+func TestAcceptAllValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 2)
+	if _, err := NewPubsubValueStore(ctx, hosts[0], nil, nil); err != ErrNilValidator {
+		t.Fatalf("expected ErrNilValidator, got %v", err)
+	}
+	vss := make([]*PubsubValueStore, len(hosts))
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vss[i], err = NewPubsubValueStore(ctx, h, fs, AcceptAllValidator{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	// any key and record
+	key := "/app/anything"
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := vss[1].SearchValue(wctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vss[0].PutValue(ctx, key, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if val := <-ch; string(val) != "b" {
+		t.Fatalf("expected the published record, got %q", val)
+	}
+
+	// the last writer wins, even with a bytewise smaller record
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, h, fs, AcceptAllValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"c", "a"} {
+		if err := vs.PutValue(ctx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("a"))
+
+	// with envelopes, the last record written wins everywhere, whoever wrote it
+	hosts = newNetHosts(ctx, t, 2)
+	for i, h := range hosts {
+		fs, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vss[i], err = NewPubsubValueStore(ctx, h, fs, AcceptAllValidator{}, WithEnvelopes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID())); err != nil {
+		t.Fatal(err)
+	}
+	for _, vs := range vss {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, vs := range vss {
+		if err := vs.SubscribeAndWait(wctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, v := range []string{"c", "a", "b"} {
+		if err := vss[i%2].PutValue(ctx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		err = waitUntil(wctx, func(ctx context.Context) (bool, error) {
+			v0, err0 := vss[0].GetValue(ctx, key, routing.Offline)
+			v1, err1 := vss[1].GetValue(ctx, key, routing.Offline)
+			return err0 == nil && err1 == nil && string(v0) == v && string(v1) == v, nil
+		}, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("expected the stores to agree on the last record %q", v)
+		}
+	}
+}
+
+// tracingTelemetry records the ended spans.
+type tracingTelemetry struct {
+	NoopTelemetry
+
+	mx    sync.Mutex
+	ended []*recordedSpan
+}
+
+// recordedSpan is a span of a tracingTelemetry.
+type recordedSpan struct {
+	tel    *tracingTelemetry
+	name   string
+	parent string
+	attrs  map[string]string
+	events []string
+	err    error
+}
+
+type recordedSpanKey struct{}
+
+func (tt *tracingTelemetry) StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recordedSpan{tel: tt, name: name, attrs: map[string]string{}}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	s.tel.mx.Lock()
+	defer s.tel.mx.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) AddEvent(name string, _ ...Attribute) {
+	s.tel.mx.Lock()
+	defer s.tel.mx.Unlock()
+	s.events = append(s.events, name)
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tel.mx.Lock()
+	defer s.tel.mx.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tel.mx.Lock()
+	defer s.tel.mx.Unlock()
+	s.tel.ended = append(s.tel.ended, s)
+}
+
+// reset returns the spans ended so far, and forgets them.
+func (tt *tracingTelemetry) reset() []recordedSpan {
+	tt.mx.Lock()
+	defer tt.mx.Unlock()
+	spans := make([]recordedSpan, len(tt.ended))
+	for i, s := range tt.ended {
+		spans[i] = *s
+	}
+	tt.ended = nil
+	return spans
+}
+
+// findSpan returns the span of the given name and parent.
+func findSpan(t *testing.T, spans []recordedSpan, name, parent string) recordedSpan {
+	t.Helper()
+	for _, s := range spans {
+		if s.name == name && s.parent == parent {
+			return s
+		}
+	}
+	t.Fatalf("no span %s under %q in %v", name, parent, spans)
+	return recordedSpan{}
+}
+
+func TestTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tel := &tracingTelemetry{}
+	vs := newTestStore(ctx, t, WithTelemetry(tel))
+	key := "/namespace/key"
+
+	if err := vs.PutValue(ctx, key, []byte("valid for key 1")); err != nil {
+		t.Fatal(err)
+	}
+	spans := tel.reset()
+	findSpan(t, spans, "PutValue", "")
+	if s := findSpan(t, spans, "Subscribe", "PutValue"); s.attrs["subscription"] != "new" {
+		t.Fatalf("expected a new subscription, got %v", s.attrs)
+	}
+	findSpan(t, spans, "JoinTopic", "Subscribe")
+	findSpan(t, spans, "Publish", "PutValue")
+
+	if _, err := vs.GetValue(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	spans = tel.reset()
+	if s := findSpan(t, spans, "GetValue", ""); s.attrs["cache"] != "hit" || s.err != nil {
+		t.Fatalf("unexpected GetValue span %v", s)
+	}
+	if s := findSpan(t, spans, "Subscribe", "GetValue"); s.attrs["subscription"] != "existing" {
+		t.Fatalf("expected an existing subscription, got %v", s.attrs)
+	}
+
+	if _, err := vs.GetValue(ctx, "/namespace/other", routing.Offline); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if s := findSpan(t, tel.reset(), "GetValue", ""); s.attrs["cache"] != "miss" || s.err != routing.ErrNotFound {
+		t.Fatalf("unexpected GetValue span %v", s)
+	}
+
+	// the search is traced until it ends
+	sctx, scancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer scancel()
+	s, err := vs.Search(sctx, "/namespace/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range s.Values() {
+	}
+	if span := findSpan(t, tel.reset(), "SearchValue", ""); span.attrs["cache"] != "miss" || span.err != context.DeadlineExceeded {
+		t.Fatalf("unexpected SearchValue span %v", span)
+	}
+
+	// incoming messages are traced with their validation and write
+	_, from := newSigner(t)
+	msg := newSignedMessage(t, nil, from, key, []byte("valid for key 2"))
+	if res := vs.validate(ctx, from, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("expected the message to be accepted, got %v", res)
+	}
+	vs.mx.Lock()
+	ti := vs.topics[key]
+	vs.mx.Unlock()
+	vs.handleUpdate(ctx, ti, key, vs.msgUpdate(msg))
+	span := findSpan(t, tel.reset(), "HandleUpdate", "")
+	if len(span.events) != 2 || span.events[0] != "validated" || span.events[1] != "stored" {
+		t.Fatalf("unexpected HandleUpdate events %q", span.events)
+	}
+}
+
+func TestSubscriptionsWithState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	for _, key := range []string{"/namespace/c", "/namespace/a"} {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"/namespace/d", "/namespace/b"} {
+		if err := vs.PutValue(ctx, key, []byte("valid for "+key[len("/namespace/"):])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if subs := vs.GetSubscriptions(); strings.Join(subs, " ") != "/namespace/a /namespace/b /namespace/c /namespace/d" {
+		t.Fatalf("unexpected subscriptions %q", subs)
+	}
+	if held := vs.SubscriptionsWithState(StateHeld); strings.Join(held, " ") != "/namespace/a /namespace/c" {
+		t.Fatalf("unexpected held subscriptions %q", held)
+	}
+	if implicit := vs.SubscriptionsWithState(StateSubscribed); strings.Join(implicit, " ") != "/namespace/b /namespace/d" {
+		t.Fatalf("unexpected implicit subscriptions %q", implicit)
+	}
+	if keys := vs.SubscriptionsWithState(StateNotTracked); len(keys) != 0 {
+		t.Fatalf("unexpected untracked subscriptions %q", keys)
+	}
+}
+
+func TestBestCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rs := &countingRecordStore{memRecordStore: memRecordStore{recs: map[string][]byte{}}}
+	vs := newTestStore(ctx, t, WithRecordStore(rs))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	vs.mx.Lock()
+	ti := vs.topics[key]
+	vs.mx.Unlock()
+
+	src := newNetHost(ctx, t).ID()
+	receive := func(val string) {
+		t.Helper()
+		msg := newSignedMessage(t, nil, src, key, []byte(val))
+		if res := vs.validate(ctx, src, msg); res != pubsub.ValidationAccept {
+			t.Fatalf("expected the message to be accepted, got %v", res)
+		}
+		vs.handleUpdate(ctx, ti, key, vs.msgUpdate(msg))
+	}
+
+	// the first read fills the cache, the writes keep it up to date
+	receive("valid for key 1")
+	reads := atomic.LoadInt32(&rs.reads)
+	for i := 2; i <= 5; i++ {
+		receive(fmt.Sprintf("valid for key %d", i))
+	}
+	if n := atomic.LoadInt32(&rs.reads) - reads; n != 0 {
+		t.Fatalf("expected the record store not to be read again, got %d reads", n)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 5"))
+
+	// a new validator validates the cached record again
+	v := countingValidator{mx: new(sync.Mutex), counts: map[string]int{}}
+	vs.SetValidator(v)
+	if _, err := vs.GetValue(ctx, key, routing.Offline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.GetValue(ctx, key, routing.Offline); err != nil {
+		t.Fatal(err)
+	}
+	v.mx.Lock()
+	n := v.counts["valid for key 5"]
+	v.mx.Unlock()
+	if n != 1 {
+		t.Fatalf("expected the cached record to be validated once, got %d", n)
+	}
+
+	// the cache goes away with the subscription
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+	rs.mx.Lock()
+	rs.recs[key] = []byte("valid for key 6")
+	rs.mx.Unlock()
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 6"))
+}
+
+// countingPutDatastore is a datastore counting the writes of authored keys.
+type countingPutDatastore struct {
+	ds.Batching
+	authored int32
+}
+
+func (c *countingPutDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if strings.Contains(key.String(), authoredPrefix.String()+"/") {
+		atomic.AddInt32(&c.authored, 1)
+	}
+	return c.Batching.Put(ctx, key, value)
+}
+
+func TestRepublishAuthored(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &countingPutDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	vs := newTestStore(ctx, t, WithDatastore(d), WithEnvelopes())
+	key, short := "/namespace/key", "/namespace/for"
+	val := []byte("valid for key 1")
+	if err := vs.PutValue(ctx, key, []byte("valid for key 0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	// marked once
+	if n := atomic.LoadInt32(&d.authored); n != 1 {
+		t.Fatalf("expected the authored key to be written once, got %d writes", n)
+	}
+	if err := vs.PutValue(ctx, short, val, WithTTL(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// received, not authored
+	if err := vs.PutLocal(ctx, "/namespace/valid", val); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := vs.AuthoredKeys(ctx); err != nil || strings.Join(keys, ",") != short+","+key {
+		t.Fatalf("unexpected authored keys %q, %v", keys, err)
+	}
+	// the keys authored since the start aren't held
+	time.Sleep(200 * time.Millisecond)
+	if subs := vs.SubscriptionsWithState(StateHeld); len(subs) != 0 {
+		t.Fatalf("unexpected held subscriptions %q", subs)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	vs = newTestStore(ctx, t, WithDatastore(d), WithEnvelopes())
+	peer := newTestStore(ctx, t, WithEnvelopes())
+	if err := peer.host().Connect(ctx, vs.host().Peerstore().PeerInfo(vs.host().ID())); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := peer.SearchValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ch:
+		if !bytes.Equal(got, val) {
+			t.Fatalf("unexpected republished value %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not republished")
+	}
+	// subscribed to as before the restart, not held
+	if subs := vs.SubscriptionsWithState(StateSubscribed); len(subs) != 1 || subs[0] != key {
+		t.Fatalf("unexpected subscriptions %q after the restart", subs)
+	}
+	if subs := vs.SubscriptionsWithState(StateHeld); len(subs) != 0 {
+		t.Fatalf("unexpected held subscriptions %q after the restart", subs)
+	}
+	if ok, err := vs.Cancel(key); err != nil || !ok {
+		t.Fatalf("expected the republished key to be canceled, got %v, %v", ok, err)
+	}
+
+	if err := vs.ForgetAuthored(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := vs.AuthoredKeys(ctx); err != nil || len(keys) != 0 {
+		t.Fatalf("unexpected authored keys %q, %v", keys, err)
+	}
+}
+
+func TestSearchResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	key := "/namespace/key"
+	results, err := vs.SearchResults(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() ValueResult {
+		select {
+		case res := <-results:
+			return res
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a result")
+			return ValueResult{}
+		}
+	}
+
+	// transient errors don't end the stream
+	_, pid := newSigner(t)
+	msg := newSignedMessage(t, nil, pid, key, []byte("invalid for key"))
+	if res := vs.validate(ctx, pid, msg); res != pubsub.ValidationReject {
+		t.Fatalf("expected the record to be rejected, got %v", res)
+	}
+	var serr StoreError
+	if res := next(); !errors.As(res.Err, &serr) || serr.Key != key || serr.Op != OpValidate {
+		t.Fatalf("expected a validation error, got %+v", res)
+	}
+	vs.reportError(key, OpStore, errors.New("disk full"))
+	vs.reportError("/namespace/other", OpStore, errors.New("disk full"))
+	if res := next(); !errors.As(res.Err, &serr) || serr.Op != OpStore || serr.Key != key {
+		t.Fatalf("expected a store error, got %+v", res)
+	}
+
+	val := []byte("valid for key")
+	if err := vs.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	if res := next(); res.Err != nil || !bytes.Equal(res.Value, val) {
+		t.Fatalf("expected the record, got %+v", res)
+	}
+	if res, ok := <-results; ok {
+		t.Fatalf("expected the stream to end once the record was found, got %+v", res)
+	}
+
+	// the terminal error is delivered before the channel is closed
+	results, err = vs.SearchResults(ctx, "/namespace/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+	if res := next(); res.Err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %+v", res)
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("expected the stream to be closed")
+	}
+}
+
+func TestMaxCachedRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	vs := newTestStore(ctx, t, WithDatastore(d), WithMaxCachedRecords(2), WithHistory(2))
+	put := func(k string) {
+		t.Helper()
+		if err := vs.PutLocal(ctx, "/namespace/"+k, []byte("valid for "+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := func(k string) bool {
+		_, err := vs.GetValue(ctx, "/namespace/"+k, routing.Offline)
+		return err == nil
+	}
+
+	put("k1")
+	put("k2")
+	// read, so more recently used than k2
+	if !stored("k1") {
+		t.Fatal("expected k1 to be stored")
+	}
+	put("k3")
+	if stored("k2") || !stored("k1") || !stored("k3") {
+		t.Fatal("expected the least recently used record to be evicted")
+	}
+	for _, k := range []ds.Key{metaKey("/namespace/k2"), historyKey("/namespace/k2")} {
+		if has, err := vs.ds.Has(ctx, k); err != nil || has {
+			t.Fatalf("expected %s of the evicted record to be deleted, got %v, %v", k, has, err)
+		}
+	}
+	if has, err := vs.ds.Has(ctx, historyKey("/namespace/k1")); err != nil || !has {
+		t.Fatalf("expected the history of a kept record to be kept, got %v, %v", has, err)
+	}
+
+	// subscribed keys are pinned
+	if err := vs.Subscribe("/namespace/k1"); err != nil {
+		t.Fatal(err)
+	}
+	put("k3")
+	put("k4")
+	if !stored("k1") || stored("k3") || !stored("k4") {
+		t.Fatal("expected the subscribed key to be kept")
+	}
+	if n := vs.Stats().RecordsEvicted; n != 2 {
+		t.Fatalf("expected 2 evictions, got %d", n)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records stored before are accounted for
+	vs = newTestStore(ctx, t, WithDatastore(d), WithMaxCachedRecords(1))
+	if keys, err := vs.ListKeys(ctx, "", 0); err != nil || len(keys) != 1 {
+		t.Fatalf("expected a single record to be kept, got %q, %v", keys, err)
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithMaxCachedRecords(-1)); err == nil {
+		t.Fatal("expected a negative limit to be refused")
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newNetHosts(ctx, t, 3)
+	pss := make([]*pubsub.PubSub, len(hosts))
+	for i, h := range hosts {
+		var err error
+		if pss[i], err = pubsub.NewFloodSub(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// authored before a restart, and subscribed to by its republish
+	authored := "/namespace/authored"
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	prev := newTestStore(ctx, t, WithDatastore(d))
+	if err := prev.PutValue(ctx, authored, []byte("valid for authored")); err != nil {
+		t.Fatal(err)
+	}
+	if err := prev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, hosts[0], pss[0], testValidator{}, WithDatastore(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := NewPubsubValueStore(ctx, hosts[1], pss[1], testValidator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, watched := "/namespace/key", "/namespace/watched"
+	val := []byte("valid for key")
+	for i := 0; i < 2; i++ {
+		if err := vs.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vs.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := vs.SearchValue(ctx, watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hctx, hcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer hcancel()
+	err = waitUntil(hctx, func(context.Context) (bool, error) {
+		return len(vs.GetSubscriptions()) == 3, nil
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refsOf := func() map[string]int {
+		vs.mx.Lock()
+		defer vs.mx.Unlock()
+		refs := make(map[string]int)
+		for k, ti := range vs.topics {
+			refs[k] = ti.refs
+		}
+		return refs
+	}
+	before := refsOf()
+	if before[key] != 2 || before[authored] != 0 {
+		t.Fatalf("unexpected holds %v before the reset", before)
+	}
+
+	// the store moves to the third host, which is connected to the peer
+	if err := hosts[2].Connect(ctx, hosts[1].Peerstore().PeerInfo(hosts[1].ID())); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Reset(hosts[2], pss[2]); err != nil {
+		t.Fatal(err)
+	}
+	if subs := vs.GetSubscriptions(); strings.Join(subs, ",") != authored+","+key+","+watched {
+		t.Fatalf("unexpected subscriptions %q after the reset", subs)
+	}
+	if after := refsOf(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("expected the holds %v to be kept, got %v", before, after)
+	}
+	if got, err := vs.GetValue(ctx, key, routing.Offline); err != nil || !bytes.Equal(got, val) {
+		t.Fatalf("expected the record to be kept, got %q, %v", got, err)
+	}
+
+	// the search goes on over the new pubsub
+	if err := peer.Subscribe(watched); err != nil {
+		t.Fatal(err)
+	}
+	peer.mx.Lock()
+	topic := peer.topics[watched].topic
+	peer.mx.Unlock()
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	err = waitUntil(wctx, func(context.Context) (bool, error) {
+		return len(topic.ListPeers()) > 0, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.PutValue(ctx, watched, []byte("valid for watched")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ch:
+		if string(got) != "valid for watched" {
+			t.Fatalf("unexpected record %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the search didn't survive the reset")
+	}
+
+	if err := vs.Reset(nil, nil); err == nil {
+		t.Fatal("expected a nil transport to be refused")
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t, WithMaxKeySize(64))
+	long := "/namespace/" + strings.Repeat("k", 64)
+	for _, key := range []string{"", long} {
+		checks := map[string]error{
+			"PutValue":  vs.PutValue(ctx, key, []byte("valid for key")),
+			"Subscribe": vs.Subscribe(key),
+		}
+		_, checks["GetValue"] = vs.GetValue(ctx, key)
+		_, checks["GetValue offline"] = vs.GetValue(ctx, key, routing.Offline)
+		_, checks["SearchValue"] = vs.SearchValue(ctx, key)
+		_, checks["Cancel"] = vs.Cancel(key)
+		for op, err := range checks {
+			var kerr *InvalidKeyError
+			if !errors.Is(err, ErrInvalidKey) || !errors.As(err, &kerr) {
+				t.Fatalf("%s of %q: expected an InvalidKeyError, got %v", op, key, err)
+			}
+		}
+	}
+	if subs := vs.GetSubscriptions(); len(subs) != 0 {
+		t.Fatalf("expected no subscription, got %q", subs)
+	}
+
+	// binary keys are fine
+	key := "/namespace/\x00\xff\n\xc3("
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Cancel(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewPubsubValueStore(ctx, vs.host(), nil, testValidator{}, WithMaxKeySize(-1)); err == nil {
+		t.Fatal("expected a negative max key size to be refused")
+	}
+}
+
+// newBenchStore returns a store on a host without any transport.
+func newBenchStore(ctx context.Context, b *testing.B) *PubsubValueStore {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		b.Fatal(err)
+	}
+	_ = ps.AddPrivKey(pid, sk)
+	_ = ps.AddPubKey(pid, sk.GetPublic())
+	netw, err := swarm.NewSwarm(pid, ps)
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := bhost.NewBlankHost(netw)
+	b.Cleanup(func() { _ = h.Close() })
+
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		b.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, h, fs, testValidator{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return vs
+}
+
+const benchKeys = 10000
+
+func benchSubscribe(b *testing.B, vs *PubsubValueStore) {
+	for k := 0; k < benchKeys; k++ {
+		if err := vs.Subscribe(fmt.Sprintf("/namespace/key%d", k)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBulkSubscribe(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		vs := newBenchStore(ctx, b)
+		b.StartTimer()
+
+		benchSubscribe(b, vs)
+
+		b.StopTimer()
+		_ = vs.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkClose(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		vs := newBenchStore(ctx, b)
+		benchSubscribe(b, vs)
+		b.StartTimer()
+
+		_ = vs.Close()
+	}
+}
+
+// slowPubsub takes a while to join topics, like a pubsub busy with its peers.
+type slowPubsub struct {
+	*pubsub.PubSub
+}
+
+func (s slowPubsub) Join(topic string, opts ...pubsub.TopicOpt) (*pubsub.Topic, error) {
+	time.Sleep(time.Millisecond)
+	return s.PubSub.Join(topic, opts...)
+}
+
+// BenchmarkConcurrentPutValue puts records of 100 keys concurrently, each on a
+// new topic.
+func BenchmarkConcurrentPutValue(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		vs := newBenchStore(ctx, b)
+		tp := vs.transport()
+		vs.net.Store(&transport{host: tp.host, ps: slowPubsub{tp.ps.(*pubsub.PubSub)}, fetch: tp.fetch})
+		b.StartTimer()
+
+		var eg errgroup.Group
+		for k := 0; k < 100; k++ {
+			key := fmt.Sprintf("/namespace/key%d", k)
+			eg.Go(func() error {
+				return vs.PutValue(ctx, key, []byte("valid for "+key))
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		_ = vs.Close()
+		b.StartTimer()
+	}
+}
+
+func checkNotFound(ctx context.Context, t *testing.T, i int, vs routing.ValueStore, key string) {
+	t.Helper()
+	_, err := vs.GetValue(ctx, key)
+	if err != routing.ErrNotFound {
+		t.Fatalf("[vssolver %d] unexpected error: %s", i, err.Error())
+	}
+}
+
+func checkValue(ctx context.Context, t *testing.T, i int, vs routing.ValueStore, key string, val []byte) {
+	t.Helper()
 	xval, err := vs.GetValue(ctx, key)
 	if err != nil {
 		t.Fatalf("[ValueStore %d] vssolve failed: %s", i, err.Error())
@@ -495,3 +5145,118 @@ func waitUntil(ctx context.Context, condition func(context.Context) (bool, error
 		}
 	}
 }
+
+// slowValidator is a testValidator with an expensive Validate, counting its
+// calls.
+type slowValidator struct {
+	testValidator
+	calls *int64
+}
+
+func (v slowValidator) Validate(key string, value []byte) error {
+	atomic.AddInt64(v.calls, 1)
+	time.Sleep(100 * time.Microsecond)
+	return v.testValidator.Validate(key, value)
+}
+
+// BenchmarkSlowValidator validates and stores received records with a slow
+// validator, trusting the topic validator's verdict or validating and
+// comparing them again.
+func BenchmarkSlowValidator(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, trusted := range []bool{false, true} {
+		b.Run(fmt.Sprintf("verdict=%t", trusted), func(b *testing.B) {
+			vs := newBenchStore(ctx, b)
+			defer vs.Close()
+			var calls int64
+			vs.SetValidator(slowValidator{calls: &calls})
+			key := "/namespace/key"
+			if err := vs.Subscribe(key); err != nil {
+				b.Fatal(err)
+			}
+			vs.mx.Lock()
+			ti := vs.topics[key]
+			vs.mx.Unlock()
+
+			src := vs.host().ID()
+			topic := KeyToTopic(key)
+			atomic.StoreInt64(&calls, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msg := &pubsub.Message{
+					Message:      &pubsubpb.Message{Data: []byte(fmt.Sprintf("valid for key %09d", i)), Topic: &topic},
+					ReceivedFrom: src,
+				}
+				if res := vs.validate(ctx, src, msg); res != pubsub.ValidationAccept {
+					b.Fatalf("expected the message to be accepted, got %v", res)
+				}
+				if !trusted {
+					msg.ValidatorData = nil
+				}
+				vs.handleUpdate(ctx, ti, key, vs.msgUpdate(msg))
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&calls))/float64(b.N), "validations/op")
+		})
+	}
+}
+
+// discardSpan is a span tracing nothing, that unlike the no-op one gets its
+// attributes and events built.
+type discardSpan struct{}
+
+func (discardSpan) SetAttributes(...Attribute)    {}
+func (discardSpan) AddEvent(string, ...Attribute) {}
+func (discardSpan) RecordError(error)             {}
+func (discardSpan) End()                          {}
+
+type discardTelemetry struct{ NoopTelemetry }
+
+func (discardTelemetry) StartSpan(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, discardSpan{}
+}
+
+// BenchmarkTracing measures the cost of the instrumentation on the hot paths,
+// with the default no-op telemetry and with a tracer.
+func BenchmarkTracing(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		name string
+		tel  Telemetry
+	}{
+		{"noop", NoopTelemetry{}},
+		{"tracer", discardTelemetry{}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			vs := newBenchStore(ctx, b)
+			defer vs.Close()
+			vs.telemetry = tc.tel
+			key := "/namespace/key"
+			if err := vs.Subscribe(key); err != nil {
+				b.Fatal(err)
+			}
+			vs.mx.Lock()
+			ti := vs.topics[key]
+			vs.mx.Unlock()
+
+			b.Run("handleUpdate", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					u := update{data: []byte(fmt.Sprintf("valid for key %09d", i)), checked: &verdict{cmp: 1}}
+					vs.handleUpdate(ctx, ti, key, u)
+				}
+			})
+			b.Run("GetValue", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := vs.GetValue(ctx, key, routing.Offline); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package namesys
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+const (
+	// DefaultRateLimit is the default number of messages per second a peer
+	// may publish to a single topic.
+	DefaultRateLimit = 10
+	// DefaultRateBurst is the default number of messages a peer may publish
+	// to a single topic in a burst.
+	DefaultRateBurst = 100
+
+	// MetricRateLimited counts messages rejected for exceeding the per-peer
+	// rate limit.
+	MetricRateLimited = "rate_limited"
+)
+
+// Buckets are swept once the limiter tracks this many peers.
+const rateLimiterSweepSize = 1024
+
+// WithRateLimit returns an option that limits the rate at which each peer may
+// publish records to a topic, as a token bucket refilled with rate tokens per
+// second and holding up to burst tokens. Messages over the limit are rejected
+// before the record is validated. A zero rate disables rate limiting.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(store *PubsubValueStore) error {
+		if rate < 0 || rate > 0 && burst <= 0 {
+			return fmt.Errorf("invalid rate limit: %v/s, burst %d", rate, burst)
+		}
+		store.rateLimit = rate
+		store.rateBurst = burst
+		return nil
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the token buckets of the peers publishing to a topic.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mx      sync.Mutex
+	buckets map[peer.ID]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[peer.ID]*tokenBucket),
+	}
+}
+
+// allow takes a token from the peer's bucket, returning false if it's empty.
+func (rl *rateLimiter) allow(from peer.ID, now time.Time) bool {
+	rl.mx.Lock()
+	defer rl.mx.Unlock()
+
+	b, ok := rl.buckets[from]
+	if !ok {
+		if len(rl.buckets) >= rateLimiterSweepSize {
+			rl.sweep(now)
+		}
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[from] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that have refilled; they are indistinguishable
+// from new ones. Must be called with rl.mx held.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for from, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, from)
+		}
+	}
+}
+
+// publisher returns the peer that published the message, falling back to the
+// peer it was received from for unsigned messages without an author.
+func publisher(src peer.ID, msg *pubsub.Message) peer.ID {
+	if from := msg.GetFrom(); from != "" {
+		return from
+	}
+	return src
+}
+
+// rateLimited reports whether the message publisher exceeded its rate limit
+// for the topic, counting the rejection if so. Our own publishes, received from
+// our host whatever their author, aren't limited.
+func (p *PubsubValueStore) rateLimited(ti *topicInfo, src, from peer.ID) bool {
+	if ti.limiter == nil || src == p.host().ID() || from == p.host().ID() {
+		return false
+	}
+	if ti.limiter.allow(from, time.Now()) {
+		return false
+	}
+	p.count(&p.stats.rateLimited, MetricRateLimited)
+	return true
+}
@@ -0,0 +1,70 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	_, pid := newSigner(t)
+	rl := newRateLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !rl.allow(pid, now) {
+			t.Fatalf("message %d should be within the burst", i)
+		}
+	}
+	if rl.allow(pid, now) {
+		t.Fatal("expected the burst to be exhausted")
+	}
+
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if !rl.allow(pid, now) {
+			t.Fatalf("message %d should have been refilled", i)
+		}
+	}
+	if rl.allow(pid, now) {
+		t.Fatal("expected only 2 tokens to be refilled")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const burst = 5
+	vs := newTestStore(ctx, t, WithRateLimit(1, burst))
+	key := "/namespace/key"
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, spammer := newSigner(t)
+	_, honest := newSigner(t)
+
+	for i := 0; i < 50; i++ {
+		msg := newSignedMessage(t, nil, spammer, key, []byte(fmt.Sprintf("valid for key %03d", i)))
+		res := vs.validate(ctx, spammer, msg)
+		if i < burst && res != pubsub.ValidationAccept {
+			t.Fatalf("message %d within the burst wasn't accepted: %v", i, res)
+		}
+		if i >= burst+1 && res != pubsub.ValidationReject {
+			t.Fatalf("message %d from the spammer wasn't rejected: %v", i, res)
+		}
+	}
+
+	msg := newSignedMessage(t, nil, honest, key, []byte("valid for key honest"))
+	if res := vs.validate(ctx, honest, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("message from the well-behaved peer wasn't accepted: %v", res)
+	}
+
+	if n := vs.Stats().RateLimited; n < 50-burst-1 {
+		t.Fatalf("expected at least %d rate limited messages, got %d", 50-burst-1, n)
+	}
+}
@@ -0,0 +1,116 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// RecordStore is the local storage of the best known record of every key.
+// Implementations must be safe for concurrent use.
+type RecordStore interface {
+	// GetBest returns the stored record of the key, or routing.ErrNotFound.
+	GetBest(ctx context.Context, key string) ([]byte, error)
+	// PutIfBetter atomically stores the record if better returns true when
+	// called with the stored record (nil if there is none) and the new one.
+	// It reports whether the record was stored.
+	PutIfBetter(ctx context.Context, key string, value []byte, better func(old, new []byte) bool) (bool, error)
+	// Delete removes the key's record. Deleting a missing record is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// RecordLister is implemented by record stores that can enumerate their keys,
+// which Export requires.
+type RecordLister interface {
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// WithRecordStore returns an option that stores records in rs instead of the
+// datastore. Record metadata and history are still kept in the datastore.
+// The records of the subscribed keys are cached in memory, so rs must not be
+// written to by others meanwhile.
+func WithRecordStore(rs RecordStore) Option {
+	return func(store *PubsubValueStore) error {
+		store.records = rs
+		return nil
+	}
+}
+
+// datastoreRecordStore is the default RecordStore, keeping records in a
+// datastore under their dshelp-encoded key. The encoding, base32 of the raw
+// key, is reversible, see KeyFromDatastoreKey.
+type datastoreRecordStore struct {
+	ds ds.Datastore
+	// makes PutIfBetter atomic
+	mx sync.Mutex
+}
+
+// NewDatastoreRecordStore returns a RecordStore backed by the datastore.
+func NewDatastoreRecordStore(d ds.Datastore) RecordStore {
+	return &datastoreRecordStore{ds: d}
+}
+
+func (s *datastoreRecordStore) GetBest(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.ds.Get(ctx, dshelp.NewKeyFromBinary([]byte(key)))
+	if err == ds.ErrNotFound {
+		err = routing.ErrNotFound
+	}
+	return val, err
+}
+
+func (s *datastoreRecordStore) PutIfBetter(ctx context.Context, key string, value []byte, better func(old, new []byte) bool) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	old, err := s.ds.Get(ctx, dshelp.NewKeyFromBinary([]byte(key)))
+	if err != nil && err != ds.ErrNotFound {
+		return false, err
+	}
+	if !better(old, value) {
+		return false, nil
+	}
+	return true, s.ds.Put(ctx, dshelp.NewKeyFromBinary([]byte(key)), value)
+}
+
+func (s *datastoreRecordStore) Delete(ctx context.Context, key string) error {
+	return s.ds.Delete(ctx, dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+func (s *datastoreRecordStore) Keys(ctx context.Context) ([]string, error) {
+	res, err := s.ds.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var keys []string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		key, err := KeyFromDatastoreKey(ds.RawKey(r.Key))
+		if err != nil {
+			// metadata, history or someone else's
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeyFromDatastoreKey returns the key of the record stored under the
+// datastore key k by the default record store, relative to the store's
+// datastore prefix if any: the inverse of KeyExplanation.DatastoreKey. It
+// fails for the entries that aren't records, e.g. metadata or history.
+func KeyFromDatastoreKey(k ds.Key) (string, error) {
+	key, err := dshelp.BinaryFromDsKey(k)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
@@ -0,0 +1,298 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// memRecordStore is a RecordStore that can't list its records.
+type memRecordStore struct {
+	mx   sync.Mutex
+	recs map[string][]byte
+}
+
+func (m *memRecordStore) GetBest(_ context.Context, key string) ([]byte, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	val, ok := m.recs[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return val, nil
+}
+
+func (m *memRecordStore) PutIfBetter(_ context.Context, key string, value []byte, better func(old, new []byte) bool) (bool, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if !better(m.recs[key], value) {
+		return false, nil
+	}
+	m.recs[key] = value
+	return true, nil
+}
+
+func (m *memRecordStore) Delete(_ context.Context, key string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.recs, key)
+	return nil
+}
+
+func TestWithRecordStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rs := &memRecordStore{recs: map[string][]byte{}}
+	vs := newTestStore(ctx, t, WithRecordStore(rs))
+	key := "/namespace/key"
+
+	for _, v := range []string{"valid for key 2", "valid for key 1"} {
+		if err := vs.PutValue(ctx, key, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if val := rs.recs[key]; !bytes.Equal(val, []byte("valid for key 2")) {
+		t.Fatalf("unexpected record in the record store: %q", val)
+	}
+	checkValue(ctx, t, 0, vs, key, []byte("valid for key 2"))
+
+	if _, err := vs.Export(ctx); err == nil {
+		t.Fatal("expected Export to fail without a RecordLister")
+	}
+}
+
+func TestDatastoreRecordStoreAtomic(t *testing.T) {
+	ctx := context.Background()
+	rs := NewDatastoreRecordStore(dssync.MutexWrap(ds.NewMapDatastore()))
+	key := "/namespace/key"
+	better := func(old, val []byte) bool {
+		return bytes.Compare(val, old) > 0
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := rs.PutIfBetter(ctx, key, []byte(fmt.Sprintf("%03d", i)), better); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	val, err := rs.GetBest(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "099" {
+		t.Fatalf("expected the best record to win, got %q", val)
+	}
+
+	keys, err := rs.(RecordLister).Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Fatalf("unexpected keys %q", keys)
+	}
+
+	if err := rs.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.GetBest(ctx, key); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDatastoreKeyRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vs := newTestStore(ctx, t)
+	keys := []string{
+		"/namespace/key",
+		"/namespace/with/slashes/",
+		"/namespace/nul\x00byte",
+		"/namespace/\xff\xfe\x00\x01",
+	}
+	for _, key := range keys {
+		k := strings.SplitN(key, "/", 3)[2]
+		if err := vs.PutLocal(ctx, key, []byte("valid for "+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed, err := vs.ListKeys(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string{}, keys...)
+	sort.Strings(want)
+	if fmt.Sprintf("%q", listed) != fmt.Sprintf("%q", want) {
+		t.Fatalf("expected the keys %q, got %q", want, listed)
+	}
+
+	for _, key := range keys {
+		full := ds.RawKey(vs.ExplainKey(key).DatastoreKey)
+		if !vs.dsPrefix.IsAncestorOf(full) {
+			t.Fatalf("expected %s to be under the datastore prefix %s", full, vs.dsPrefix)
+		}
+		// within the prefix, as vs.ds is
+		dk := ds.NewKey(full.BaseNamespace())
+		got, err := KeyFromDatastoreKey(dk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != key {
+			t.Fatalf("expected %q to round-trip, got %q", key, got)
+		}
+		if _, err := vs.ds.Get(ctx, dk); err != nil {
+			t.Fatalf("expected the record of %q under %s: %s", key, dk, err)
+		}
+		if _, err := KeyFromDatastoreKey(metaKey(key)); err == nil {
+			t.Fatal("expected a metadata entry not to be taken for a record")
+		}
+	}
+}
+
+// failingRecordStore is a memRecordStore whose writes fail while fail is set.
+type failingRecordStore struct {
+	memRecordStore
+	fail int32
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (f *failingRecordStore) PutIfBetter(ctx context.Context, key string, value []byte, better func(old, new []byte) bool) (bool, error) {
+	if atomic.LoadInt32(&f.fail) != 0 {
+		f.mx.Lock()
+		defer f.mx.Unlock()
+		if !better(f.recs[key], value) {
+			return false, nil
+		}
+		return false, errDiskFull
+	}
+	return f.memRecordStore.PutIfBetter(ctx, key, value, better)
+}
+
+func TestUnsavedRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rs := &failingRecordStore{memRecordStore: memRecordStore{recs: map[string][]byte{}}, fail: 1}
+	vs := newTestStore(ctx, t, WithRecordStore(rs))
+	key := "/namespace/key"
+
+	val := []byte("valid for key")
+	if err := vs.PutValue(ctx, key, val); err != errDiskFull {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	// served from memory meanwhile
+	checkValue(ctx, t, 0, vs, key, val)
+	if n := vs.Stats().UnsavedRecords; n != 1 {
+		t.Fatalf("expected 1 unsaved record, got %d", n)
+	}
+
+	// the retries stop with the context
+	pctx, pcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer pcancel()
+	start := time.Now()
+	val = []byte("valid for key and better")
+	if err := vs.PutValue(pctx, key, val); err != errDiskFull {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	if d := time.Since(start); d >= 2*dsPutBackoff {
+		t.Fatalf("expected the retries to stop with the context, took %s", d)
+	}
+	checkValue(ctx, t, 0, vs, key, val)
+
+	atomic.StoreInt32(&rs.fail, 0)
+	wctx, wcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer wcancel()
+	err := waitUntil(wctx, func(context.Context) (bool, error) {
+		return vs.Stats().UnsavedRecords == 0, nil
+	}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := rs.GetBest(ctx, key); err != nil || !bytes.Equal(v, val) {
+		t.Fatalf("expected the record to be written, got %q, %v", v, err)
+	}
+}
+
+// countingRecordStore is a memRecordStore counting its reads and writes.
+type countingRecordStore struct {
+	memRecordStore
+	reads int32
+	puts  int32
+}
+
+func (c *countingRecordStore) GetBest(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt32(&c.reads, 1)
+	return c.memRecordStore.GetBest(ctx, key)
+}
+
+func (c *countingRecordStore) PutIfBetter(ctx context.Context, key string, value []byte, better func(old, new []byte) bool) (bool, error) {
+	atomic.AddInt32(&c.puts, 1)
+	return c.memRecordStore.PutIfBetter(ctx, key, value, better)
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rs := &countingRecordStore{memRecordStore: memRecordStore{recs: map[string][]byte{}}}
+	vs := newTestStore(ctx, t, WithRecordStore(rs), WithWriteCoalescing(200*time.Millisecond))
+	key := "/namespace/key"
+
+	var last []byte
+	for i := 0; i < 10; i++ {
+		last = []byte(fmt.Sprintf("valid for key %d", i))
+		if err := vs.PutLocal(ctx, key, last); err != nil {
+			t.Fatal(err)
+		}
+		// served from memory meanwhile
+		checkValue(ctx, t, 0, vs, key, last)
+	}
+	if n := atomic.LoadInt32(&rs.puts); n != 0 {
+		t.Fatalf("expected the writes to be delayed, got %d", n)
+	}
+
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	err := waitUntil(wctx, func(ctx context.Context) (bool, error) {
+		v, err := rs.GetBest(ctx, key)
+		return err == nil && bytes.Equal(v, last), nil
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&rs.puts); n != 1 {
+		t.Fatalf("expected a single write, got %d", n)
+	}
+
+	// written on Close
+	last = []byte("valid for key 99")
+	if err := vs.PutLocal(ctx, key, last); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := rs.GetBest(ctx, key); err != nil || !bytes.Equal(v, last) {
+		t.Fatalf("expected the record to be written on Close, got %q, %v", v, err)
+	}
+}
@@ -0,0 +1,125 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/host"
+)
+
+// transport is the host and pubsub the store runs on, with the fetch protocol
+// served on the host.
+type transport struct {
+	host  host.Host
+	ps    Pubsub
+	fetch *fetchProtocol
+}
+
+func (p *PubsubValueStore) transport() *transport {
+	return p.net.Load().(*transport)
+}
+
+func (p *PubsubValueStore) host() host.Host {
+	return p.transport().host
+}
+
+func (p *PubsubValueStore) ps() Pubsub {
+	return p.transport().ps
+}
+
+func (p *PubsubValueStore) fetcher() *fetchProtocol {
+	return p.transport().fetch
+}
+
+// newFetchProtocol serves the fetch protocol on the host.
+func (p *PubsubValueStore) newFetchProtocol(h host.Host) *fetchProtocol {
+	fetch := newFetchProtocol(p.ctx, h, p.getLocal)
+	fetch.timeout = p.fetchTimeout
+	fetch.log = p.log
+	return fetch
+}
+
+// ResetError is returned by Reset when some of the keys could not be
+// subscribed to again. It maps each failing key to its error.
+type ResetError map[string]error
+
+func (e ResetError) Error() string {
+	return fmt.Sprintf("failed to resubscribe to %d keys: %s", len(e), formatKeyErrors(e))
+}
+
+// Reset moves the store to a new host and pubsub, e.g. once the previous ones
+// were torn down for a transport restart. The subscriptions are canceled on
+// the previous pubsub, and made again on the new one, held as many times as
+// they were. Keys that fail are reported in a ResetError, without preventing
+// the others from being resubscribed to.
+//
+// The stored records are kept, and so are the watchers: searches and callbacks
+// receive the records accepted on the new pubsub. The subscriptions tied to a
+// context with WithLifetime are no longer tied to it. New subscriptions wait
+// for all of the keys to be resubscribed to.
+func (p *PubsubValueStore) Reset(h host.Host, ps Pubsub) error {
+	if h == nil || ps == nil {
+		return errors.New("invalid transport: nil host or pubsub")
+	}
+	if p.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	p.resetMx.Lock()
+	defer p.resetMx.Unlock()
+	p.mx.Lock()
+	refs := make(map[string]int, len(p.topics))
+	for key, ti := range p.topics {
+		refs[key] = ti.refs
+	}
+	p.mx.Unlock()
+
+	for key := range refs {
+		p.dropSubscription(key)
+	}
+	old := p.transport()
+	old.host.RemoveStreamHandler(FetchProtoID)
+	p.net.Store(&transport{host: h, ps: ps, fetch: p.newFetchProtocol(h)})
+	p.log.Infof("PubsubResolve: moved to host %s, resubscribing to %d keys", h.ID(), len(refs))
+
+	// still write locked, so that no other subscription to the keys moves
+	// their holds meanwhile
+	errs := ResetError{}
+	for key, n := range refs {
+		if err := p.subscribeLocked(context.Background(), key, false); err != nil {
+			errs[key] = err
+			continue
+		}
+		p.mx.Lock()
+		if ti, ok := p.topics[key]; ok {
+			ti.refs = n
+			p.persistSubscription(key, n)
+		}
+		p.mx.Unlock()
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// dropSubscription cancels the key's subscription as Cancel does, leaving its
+// watchers waiting for the next one.
+func (p *PubsubValueStore) dropSubscription(key string) {
+	unlock := p.keyLocks.lock(key)
+	defer unlock()
+
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	if ok {
+		p.closeTopic(key, ti)
+	}
+	p.mx.Unlock()
+
+	if ok {
+		<-ti.finished
+	}
+	p.flushPending(p.ctx, key)
+}
@@ -0,0 +1,69 @@
+package namesys
+
+import (
+	"context"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+const (
+	// MetricResubscribes counts the attempts to resubscribe to a topic after
+	// its subscription failed.
+	MetricResubscribes = "resubscribes"
+
+	resubscribeMinBackoff = time.Second
+	resubscribeMaxBackoff = 30 * time.Second
+)
+
+// resubscribe replaces the failed subscription of the topic, retrying with
+// exponential backoff. It returns nil once the subscription is canceled or the
+// store closed.
+func (p *PubsubValueStore) resubscribe(ctx context.Context, ti *topicInfo, key string) *pubsub.Subscription {
+	backoff := resubscribeMinBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		p.count(&p.stats.resubscribes, MetricResubscribes)
+		if sub := p.replaceSubscription(ctx, ti, key); sub != nil {
+			p.log.Infof("PubsubResolve: resubscribed to %s", logKey(key))
+			return sub
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if backoff *= 2; backoff > resubscribeMaxBackoff {
+			backoff = resubscribeMaxBackoff
+		}
+		timer.Reset(backoff)
+	}
+}
+
+// replaceSubscription subscribes to the topic again, unless it was closed in
+// the meantime.
+func (p *PubsubValueStore) replaceSubscription(ctx context.Context, ti *topicInfo, key string) *pubsub.Subscription {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	// closeTopic cancels ctx with mx held
+	if ctx.Err() != nil {
+		return nil
+	}
+	sub, err := ti.topic.Subscribe()
+	if err != nil {
+		p.log.Warnf("PubsubResolve: error resubscribing: %s", err)
+		p.reportError(key, OpSubscribe, err)
+		return nil
+	}
+	ti.sub.Cancel()
+	ti.sub = sub
+	return sub
+}
@@ -0,0 +1,126 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// resultErrorsBuffer is the number of errors of its key a SearchResults stream
+// holds while its consumer is busy; the others are dropped.
+const resultErrorsBuffer = 16
+
+// ValueResult is a result of SearchResults: a record of the key, or an error.
+type ValueResult struct {
+	Value []byte
+	Err   error
+}
+
+// keyErrors holds the listeners of the errors of each key.
+type keyErrors struct {
+	mx        sync.Mutex
+	listeners map[string]map[chan StoreError]struct{}
+}
+
+// watchErrors registers a listener of the key's errors, and returns it with
+// the function unregistering it.
+func (p *PubsubValueStore) watchErrors(key string) (<-chan StoreError, func()) {
+	ch := make(chan StoreError, resultErrorsBuffer)
+	p.keyErrs.mx.Lock()
+	defer p.keyErrs.mx.Unlock()
+	if p.keyErrs.listeners == nil {
+		p.keyErrs.listeners = make(map[string]map[chan StoreError]struct{})
+	}
+	ls, ok := p.keyErrs.listeners[key]
+	if !ok {
+		ls = make(map[chan StoreError]struct{})
+		p.keyErrs.listeners[key] = ls
+	}
+	ls[ch] = struct{}{}
+
+	return ch, func() {
+		p.keyErrs.mx.Lock()
+		defer p.keyErrs.mx.Unlock()
+		delete(ls, ch)
+		if len(ls) == 0 {
+			delete(p.keyErrs.listeners, key)
+		}
+	}
+}
+
+// notifyKeyError sends the error to the listeners of its key without
+// blocking.
+func (p *PubsubValueStore) notifyKeyError(err StoreError) {
+	p.keyErrs.mx.Lock()
+	defer p.keyErrs.mx.Unlock()
+	for ch := range p.keyErrs.listeners[err.Key] {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+}
+
+// SearchResults is SearchValue, with the errors of the key delivered along
+// with its records. The errors that are StoreErrors are transient, e.g. a
+// failed resubscription attempt, a failed write of a received record or the
+// rejection of an invalid one, and the search goes on; errors are dropped
+// while the consumer is busy. The search's terminal error, the Err of the
+// Search, is delivered last, before the channel is closed. If ctx ended
+// first, it is only delivered if the consumer is waiting for it.
+//
+// It takes the options of Search.
+func (p *PubsubValueStore) SearchResults(ctx context.Context, key string, opts ...routing.Option) (<-chan ValueResult, error) {
+	errs, unwatch := p.watchErrors(key)
+	s, err := p.Search(ctx, key, opts...)
+	if err != nil {
+		unwatch()
+		return nil, err
+	}
+
+	out := make(chan ValueResult)
+	go func() {
+		defer close(out)
+		defer unwatch()
+
+		values := s.Values()
+		for {
+			var res ValueResult
+			select {
+			case val, ok := <-values:
+				if !ok {
+					if err := s.Err(); err != nil {
+						p.deliverTerminal(ctx, out, err)
+					}
+					return
+				}
+				res.Value = val
+			case err := <-errs:
+				res.Err = err
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				// the search ends along, and delivers its error
+			}
+		}
+	}()
+	return out, nil
+}
+
+// deliverTerminal delivers the terminal error of a SearchResults stream,
+// unless ctx is done and the consumer isn't waiting for it.
+func (p *PubsubValueStore) deliverTerminal(ctx context.Context, out chan<- ValueResult, err error) {
+	if ctx.Err() != nil {
+		select {
+		case out <- ValueResult{Err: err}:
+		default:
+		}
+		return
+	}
+	select {
+	case out <- ValueResult{Err: err}:
+	case <-ctx.Done():
+	}
+}
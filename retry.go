@@ -0,0 +1,120 @@
+package namesys
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MetricPublishesAbandoned counts records that were retried until the
+	// retry deadline without any peer subscribing to their topic.
+	MetricPublishesAbandoned = "publishes_abandoned"
+
+	publishRetryMinBackoff = time.Second
+	publishRetryMaxBackoff = 30 * time.Second
+)
+
+// WithPublishRetry returns an option that keeps republishing records put on a
+// topic without peers, with exponential backoff, until a peer shows up or max
+// has elapsed since the last PutValue for the key. Zero, the default,
+// disables retries.
+func WithPublishRetry(max time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if max < 0 {
+			return fmt.Errorf("invalid publish retry duration: %s", max)
+		}
+		store.publishRetry = max
+		return nil
+	}
+}
+
+// publishRetry is the record being republished for a key. Fields are guarded
+// by publishRetries.mx.
+type publishRetry struct {
+	value    []byte
+	deadline time.Time
+}
+
+type publishRetries struct {
+	mx   sync.Mutex
+	keys map[string]*publishRetry
+}
+
+// retryPublish schedules the record to be republished until the topic has
+// peers, superseding any record pending for the key.
+func (p *PubsubValueStore) retryPublish(ti *topicInfo, key string, value []byte) {
+	deadline := time.Now().Add(p.publishRetry)
+
+	p.retries.mx.Lock()
+	defer p.retries.mx.Unlock()
+	if r, ok := p.retries.keys[key]; ok {
+		r.value = value
+		r.deadline = deadline
+		return
+	}
+	if p.retries.keys == nil {
+		p.retries.keys = make(map[string]*publishRetry)
+	}
+	r := &publishRetry{value: value, deadline: deadline}
+	p.retries.keys[key] = r
+	atomic.AddInt64(&p.stats.pendingPublishes, 1)
+
+	go p.runPublishRetry(ti, key, r)
+}
+
+func (p *PubsubValueStore) runPublishRetry(ti *topicInfo, key string, r *publishRetry) {
+	backoff := publishRetryMinBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			p.finishPublishRetry(key, r)
+			return
+		}
+
+		if cur, ok := p.live.Load(key); !ok || cur != ti {
+			p.finishPublishRetry(key, r)
+			return
+		}
+		found := len(ti.topic.ListPeers()) > 0
+
+		p.retries.mx.Lock()
+		value, expired := r.value, time.Now().After(r.deadline)
+		p.retries.mx.Unlock()
+
+		if !found && expired {
+			p.log.Debugf("PubsubPublish: no peers for %s, giving up republishing", logKey(key))
+			p.count(&p.stats.publishesAbandoned, MetricPublishesAbandoned)
+			p.finishPublishRetry(key, r)
+			return
+		}
+
+		if err := <-p.psPublishChannel(p.ctx, ti.topic, value); err != nil {
+			p.log.Debugf("PubsubPublish: error republishing %s: %s", logKey(key), err)
+			p.reportError(key, OpPublish, err)
+		}
+		if found {
+			p.finishPublishRetry(key, r)
+			return
+		}
+
+		if backoff *= 2; backoff > publishRetryMaxBackoff {
+			backoff = publishRetryMaxBackoff
+		}
+		timer.Reset(backoff)
+	}
+}
+
+func (p *PubsubValueStore) finishPublishRetry(key string, r *publishRetry) {
+	p.retries.mx.Lock()
+	defer p.retries.mx.Unlock()
+	if p.retries.keys[key] == r {
+		delete(p.retries.keys, key)
+		atomic.AddInt64(&p.stats.pendingPublishes, -1)
+	}
+}
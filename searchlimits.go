@@ -0,0 +1,96 @@
+package namesys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+type maxResultsKey struct{}
+
+type stopWhenKey struct{}
+
+// WithMaxResults is a SearchValue option that turns the search into a stream
+// of progressively better records of the key, starting with the stored one,
+// which ends once n records were delivered. Records accepted while the
+// consumer is busy replace the one waiting to be delivered, unless
+// WithFullHistory says otherwise. Other searches of the key are unaffected.
+func WithMaxResults(n int) routing.Option {
+	return func(opts *routing.Options) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max results: %d", n)
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[maxResultsKey{}] = n
+		return nil
+	}
+}
+
+// WithStopWhen is a SearchValue option that streams the records of the key
+// as WithMaxResults does, and ends the search once a record for which done
+// returns true was delivered, e.g. one recent enough. done is called on the
+// search's goroutine, with every record once delivered.
+func WithStopWhen(done func(value []byte) bool) routing.Option {
+	return func(opts *routing.Options) error {
+		if done == nil {
+			return errors.New("invalid stop condition: nil")
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[stopWhenKey{}] = done
+		return nil
+	}
+}
+
+// searchLimits are the conditions ending a streaming search, see
+// WithMaxResults and WithStopWhen.
+type searchLimits struct {
+	// 0 if unlimited
+	max  int
+	done func(value []byte) bool
+}
+
+// searchLimitsOf returns the search limits set by the options, if any.
+func searchLimitsOf(options routing.Options) (searchLimits, bool) {
+	var l searchLimits
+	l.max, _ = options.Other[maxResultsKey{}].(int)
+	l.done, _ = options.Other[stopWhenKey{}].(func(value []byte) bool)
+	return l, l.max > 0 || l.done != nil
+}
+
+// limitSearch forwards the records of the streaming search until the limits
+// are reached, and then cancels it. The returned search only ends
+// successfully, with a nil Err, if they are.
+func limitSearch(inner *Search, l searchLimits) *Search {
+	s := &Search{
+		out:  make(chan []byte),
+		stop: inner.stop,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		defer close(s.out)
+
+		var n int
+		for val := range inner.out {
+			select {
+			case s.out <- val:
+			case <-inner.done:
+				// ended before the consumer took the record
+				s.err = inner.err
+				return
+			}
+			n++
+			if l.max > 0 && n >= l.max || l.done != nil && l.done(val) {
+				inner.Cancel()
+				return
+			}
+		}
+		s.err = inner.err
+	}()
+	return s
+}
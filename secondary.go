@@ -0,0 +1,57 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// WithSecondaryStore returns an option that makes PutValue also write records
+// to vs, e.g. the DHT for the peers that aren't on the topic. Both writes
+// happen concurrently, and the failures of either are reported in a
+// HybridError. Pass SkipSecondary to PutValue to only publish through pubsub.
+func WithSecondaryStore(vs routing.ValueStore) Option {
+	return func(store *PubsubValueStore) error {
+		if vs == nil {
+			return errors.New("invalid secondary store: nil")
+		}
+		store.secondary = vs
+		return nil
+	}
+}
+
+type skipSecondaryKey struct{}
+
+// SkipSecondary is a PutValue option that doesn't write the record to the
+// secondary store set with WithSecondaryStore.
+var SkipSecondary routing.Option = func(opts *routing.Options) error {
+	if opts.Other == nil {
+		opts.Other = make(map[interface{}]interface{})
+	}
+	opts.Other[skipSecondaryKey{}] = true
+	return nil
+}
+
+func skipSecondary(opts []routing.Option) bool {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		return false
+	}
+	skip, _ := options.Other[skipSecondaryKey{}].(bool)
+	return skip
+}
+
+func (p *PubsubValueStore) putValueAndSecondary(ctx context.Context, key string, value []byte, opts []routing.Option) error {
+	secErr := make(chan error, 1)
+	go func() {
+		secErr <- p.secondary.PutValue(ctx, key, value, opts...)
+	}()
+
+	herr := &HybridError{Pubsub: p.putValue(ctx, key, value, opts...)}
+	herr.DHT = <-secErr
+	if herr.Pubsub == nil && herr.DHT == nil {
+		return nil
+	}
+	return herr
+}
@@ -0,0 +1,231 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+// AuthorizeFunc decides whether the peer is allowed to publish records for
+// the key.
+type AuthorizeFunc func(key string, from peer.ID) bool
+
+// WithStrictSigning returns an option that rejects every incoming message
+// that isn't signed by its sender, before the record validator runs. If
+// authorize is non-nil, it's consulted with the verified sender, and messages
+// it refuses are rejected too.
+//
+// With pubsub's default StrictSign policy, pubsub already drops unsigned and
+// badly signed messages before they reach the topic validator, and this option
+// only adds the authorization check. It's needed when the pubsub instance is
+// configured with a lax policy (LaxSign or LaxNoSign), which lets unsigned
+// messages through. It must not be combined with StrictNoSign, under which no
+// message would ever be accepted.
+//
+// The records signed by our own alternate identities, the one set with
+// WithMessageAuthor and those PutValueAs signed with, are authorized whatever
+// authorize says.
+func WithStrictSigning(authorize AuthorizeFunc) Option {
+	return func(store *PubsubValueStore) error {
+		store.strictSigning = true
+		store.authorize = authorize
+		return nil
+	}
+}
+
+// checkSender verifies the message signature and that its sender is allowed to
+// publish records for the key.
+func (p *PubsubValueStore) checkSender(key string, msg *pubsub.Message) error {
+	if !p.strictSigning {
+		return nil
+	}
+	if err := verifyMessageSignature(msg.Message); err != nil {
+		return err
+	}
+	if p.authorize != nil && !p.ownIdentity(msg.GetFrom()) && !p.authorize(key, msg.GetFrom()) {
+		return fmt.Errorf("peer %s is not authorized to publish %s", msg.GetFrom(), formatKey(key))
+	}
+	return nil
+}
+
+// ErrSigningUnsupported is returned by PutValueAs when the Pubsub doesn't
+// implement SignedPublisher.
+var ErrSigningUnsupported = errors.New("pubsub can't sign messages with another key")
+
+// SignedPublisher is implemented by Pubsub backends that can sign an outgoing
+// message with a key of the caller's choice, rather than the one they were
+// configured with. *pubsub.PubSub signs all of its messages as a single
+// author, see WithMessageAuthor.
+type SignedPublisher interface {
+	PublishSigned(ctx context.Context, topic string, data []byte, signKey crypto.PrivKey) error
+}
+
+// WithMessageAuthor returns an option telling the store that its pubsub signs
+// outgoing messages as author rather than as the host, i.e. that it was
+// constructed with pubsub.WithMessageAuthor(author). The store treats the
+// messages of author like its own: they are authorized by WithStrictSigning,
+// and our local records are attributed to author. Sequence numbers of
+// envelopes remain the host's, which keeps them.
+func WithMessageAuthor(author peer.ID) Option {
+	return func(store *PubsubValueStore) error {
+		if author == "" {
+			return errors.New("invalid message author: empty")
+		}
+		store.author = author
+		return nil
+	}
+}
+
+// PutValueAs is PutValue, publishing the record in a message signed by
+// signKey rather than by the store's author. It requires a Pubsub
+// implementing SignedPublisher, and returns ErrSigningUnsupported otherwise.
+//
+// The record is published right away, even with WithPublishQueue or
+// WithPublishRetry. Its rebroadcasts, and republishing it after a restart,
+// are signed by the store's author, as the store doesn't keep signKey; it
+// isn't among the AuthoredKeys.
+func (p *PubsubValueStore) PutValueAs(ctx context.Context, key string, value []byte, signKey crypto.PrivKey, opts ...routing.Option) (err error) {
+	ctx, span := p.telemetry.StartSpan(ctx, "PutValueAs")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if signKey == nil {
+		return errors.New("invalid signing key: nil")
+	}
+	if _, ok := p.ps().(SignedPublisher); !ok {
+		return ErrSigningUnsupported
+	}
+	if _, err := parseOptions(opts, false, skipSecondaryKey{}, ttlKey{}); err != nil {
+		return err
+	}
+	if err := p.checkTTL(opts); err != nil {
+		return err
+	}
+	if err := p.putValueIf(ctx, key, nil, value, ttlOf(opts), signKey); err != nil {
+		return err
+	}
+	if p.secondary != nil && !skipSecondary(opts) {
+		if err := p.secondary.PutValue(ctx, key, value, opts...); err != nil {
+			return &HybridError{DHT: err}
+		}
+	}
+	return nil
+}
+
+// authorID returns the identity the pubsub signs our messages with.
+func (p *PubsubValueStore) authorID() peer.ID {
+	if p.author != "" {
+		return p.author
+	}
+	return p.host().ID()
+}
+
+// signer returns the identity of signKey, remembering it as our own.
+func (p *PubsubValueStore) signer(signKey crypto.PrivKey) (peer.ID, error) {
+	id, err := peer.IDFromPrivateKey(signKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid signing key: %s", err)
+	}
+	p.signers.Store(id, struct{}{})
+	return id, nil
+}
+
+// ownIdentity reports whether id is one of the alternate identities we sign
+// messages with. The host isn't one, it is authorized as any other peer.
+func (p *PubsubValueStore) ownIdentity(id peer.ID) bool {
+	if id == p.host().ID() {
+		return false
+	}
+	if p.author != "" && id == p.author {
+		return true
+	}
+	_, ok := p.signers.Load(id)
+	return ok
+}
+
+// publishAs publishes a record put locally in a message signed by signKey.
+func (p *PubsubValueStore) publishAs(ctx context.Context, ti *topicInfo, value []byte, signKey crypto.PrivKey) (err error) {
+	_, span := p.telemetry.StartSpan(ctx, "Publish")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	start := time.Now()
+	err = p.ps().(SignedPublisher).PublishSigned(ctx, ti.topic.String(), p.compress(value), signKey)
+	p.telemetry.ObserveHistogram(MetricPublishDuration, time.Since(start).Seconds())
+	if err != nil {
+		p.telemetry.IncCounter(MetricPublishes, Attr("result", "error"))
+	} else {
+		p.telemetry.IncCounter(MetricPublishes, Attr("result", "ok"))
+	}
+	return err
+}
+
+// verifyMessageSignature mirrors pubsub's signature verification.
+func verifyMessageSignature(m *pubsubpb.Message) error {
+	if len(m.Signature) == 0 {
+		return errors.New("message is not signed")
+	}
+
+	pubk, err := messagePubKey(m)
+	if err != nil {
+		return err
+	}
+
+	xm := *m
+	xm.Signature = nil
+	xm.Key = nil
+	bytes, err := xm.Marshal()
+	if err != nil {
+		return err
+	}
+
+	valid, err := pubk.Verify(append([]byte(pubsub.SignPrefix), bytes...), m.Signature)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func messagePubKey(m *pubsubpb.Message) (crypto.PubKey, error) {
+	pid, err := peer.IDFromBytes(m.From)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Key == nil {
+		// no attached key, it must be extractable from the source ID
+		pubk, err := pid.ExtractPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot extract signing key: %s", err)
+		}
+		return pubk, nil
+	}
+
+	pubk, err := crypto.UnmarshalPublicKey(m.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal signing key: %s", err)
+	}
+	if !pid.MatchesPublicKey(pubk) {
+		return nil, fmt.Errorf("bad signing key; source ID %s doesn't match key", pid)
+	}
+	return pubk, nil
+}
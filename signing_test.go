@@ -0,0 +1,184 @@
+package namesys
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+func newSigner(t *testing.T) (crypto.PrivKey, peer.ID) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, pid
+}
+
+func newSignedMessage(t *testing.T, sk crypto.PrivKey, from peer.ID, key string, data []byte) *pubsub.Message {
+	topic := KeyToTopic(key)
+	m := &pubsubpb.Message{
+		From:  []byte(from),
+		Data:  data,
+		Seqno: []byte{1},
+		Topic: &topic,
+	}
+	if sk != nil {
+		b, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Signature, err = sk.Sign(append([]byte(pubsub.SignPrefix), b...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &pubsub.Message{Message: m, ReceivedFrom: from}
+}
+
+func TestStrictSigning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	val := []byte("valid for key 1")
+
+	author, authorID := newSigner(t)
+	forger, forgerID := newSigner(t)
+
+	vs := newTestStore(ctx, t, WithStrictSigning(func(k string, from peer.ID) bool {
+		return k == key && from == authorID
+	}))
+	if err := vs.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		msg      *pubsub.Message
+		expected pubsub.ValidationResult
+	}{
+		{"unsigned", newSignedMessage(t, nil, authorID, key, val), pubsub.ValidationReject},
+		{"forged sender", newSignedMessage(t, forger, authorID, key, val), pubsub.ValidationReject},
+		{"unauthorized", newSignedMessage(t, forger, forgerID, key, val), pubsub.ValidationReject},
+		{"authorized", newSignedMessage(t, author, authorID, key, val), pubsub.ValidationAccept},
+	} {
+		if res := vs.validate(ctx, tc.msg.ReceivedFrom, tc.msg); res != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, res)
+		}
+	}
+}
+
+func TestMessageAuthor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	val := []byte("valid for key 1")
+	author, authorID := newSigner(t)
+	refuseAll := WithStrictSigning(func(string, peer.ID) bool { return false })
+
+	h := newNetHost(ctx, t)
+	if err := h.Peerstore().AddPrivKey(authorID, author); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Peerstore().AddPubKey(authorID, author.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := pubsub.NewFloodSub(ctx, h, pubsub.WithMessageAuthor(authorID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewPubsubValueStore(ctx, h, fs, testValidator{}, refuseAll, WithMessageAuthor(authorID), WithEnvelopes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.PutValue(ctx, key, val); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err := vs.GetValueWithMeta(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.From != authorID {
+		t.Fatalf("expected the record to be from %s, got %s", authorID, meta.From)
+	}
+	raw, err := vs.records.GetBest(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, err := openRecord(raw); err != nil || peer.ID(env.Publisher) != authorID {
+		t.Fatalf("expected the envelope to be published by %s, got %v, %v", authorID, env, err)
+	}
+
+	if _, err := NewPubsubValueStore(ctx, h, fs, testValidator{}, WithMessageAuthor("")); err == nil {
+		t.Fatal("expected an empty author to be refused")
+	}
+}
+
+// signedPubsub records the messages it is asked to sign.
+type signedPubsub struct {
+	*pubsub.PubSub
+	topics []string
+	keys   []crypto.PrivKey
+}
+
+func (ps *signedPubsub) PublishSigned(_ context.Context, topic string, _ []byte, signKey crypto.PrivKey) error {
+	ps.topics = append(ps.topics, topic)
+	ps.keys = append(ps.keys, signKey)
+	return nil
+}
+
+func TestPutValueAs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := "/namespace/key"
+	signer, signerID := newSigner(t)
+	refuseAll := WithStrictSigning(func(string, peer.ID) bool { return false })
+
+	vs := newTestStore(ctx, t)
+	if err := vs.PutValueAs(ctx, key, []byte("valid for key 1"), signer); err != ErrSigningUnsupported {
+		t.Fatalf("expected ErrSigningUnsupported, got %v", err)
+	}
+
+	h := newNetHost(ctx, t)
+	fs, err := pubsub.NewFloodSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &signedPubsub{PubSub: fs}
+	vs, err = NewPubsubValueStore(ctx, h, ps, testValidator{}, refuseAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.PutValueAs(ctx, key, []byte("valid for key 1"), signer); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.topics) != 1 || ps.topics[0] != KeyToTopic(key) || ps.keys[0] != signer {
+		t.Fatalf("unexpected signed publishes to %q", ps.topics)
+	}
+	if _, meta, err := vs.GetValueWithMeta(ctx, key); err != nil || meta.From != signerID {
+		t.Fatalf("expected the record to be from %s, got %s, %v", signerID, meta.From, err)
+	}
+
+	// the identity is authorized, also for records relayed by other peers
+	msg := newSignedMessage(t, signer, signerID, key, []byte("valid for key 2"))
+	if res := vs.validate(ctx, msg.ReceivedFrom, msg); res != pubsub.ValidationAccept {
+		t.Fatalf("expected a record signed by %s to be accepted, got %v", signerID, res)
+	}
+	forger, forgerID := newSigner(t)
+	msg = newSignedMessage(t, forger, forgerID, key, []byte("valid for key 3"))
+	if res := vs.validate(ctx, msg.ReceivedFrom, msg); res != pubsub.ValidationReject {
+		t.Fatalf("expected a record signed by %s to be rejected, got %v", forgerID, res)
+	}
+}
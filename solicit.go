@@ -0,0 +1,140 @@
+package namesys
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// missingFetches tracks the keys being fetched from their topic's peers
+// because GetValue missed them, so that concurrent calls share the fetch.
+type missingFetches struct {
+	mx sync.Mutex
+	// closed once the fetch of the key is over
+	done map[string]chan struct{}
+}
+
+// solicit actively looks for the record of a key missing locally. It fetches
+// the record from the topic's peers, sharing the fetch with concurrent calls,
+// and if ctx has a deadline, keeps waiting for the record until then, e.g.
+// for peers joining the topic in the meantime. It returns routing.ErrNotFound
+// if the record still isn't found.
+func (p *PubsubValueStore) solicit(ctx context.Context, key string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		if p.passThrough {
+			return nil, routing.ErrNotFound
+		}
+		done := p.fetchMissing(key)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		val, err := p.getLocal(ctx, key)
+		return p.open(val), err
+	}
+
+	// watch before fetching, the records may not be stored
+	s, err := p.Search(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	p.fetchMissing(key)
+	if val, ok := <-s.Values(); ok {
+		return val, nil
+	}
+	if err := s.Err(); err != ctx.Err() {
+		return nil, err
+	}
+	return nil, routing.ErrNotFound
+}
+
+// fetchMissing starts fetching the subscribed key's record from the topic's
+// peers, unless a fetch is already running, and returns a channel closed once
+// the fetched records were processed.
+func (p *PubsubValueStore) fetchMissing(key string) <-chan struct{} {
+	p.missing.mx.Lock()
+	defer p.missing.mx.Unlock()
+	if done, ok := p.missing.done[key]; ok {
+		return done
+	}
+	if p.missing.done == nil {
+		p.missing.done = make(map[string]chan struct{})
+	}
+	done := make(chan struct{})
+	p.missing.done[key] = done
+
+	go func() {
+		p.fetchFromPeers(key)
+
+		p.missing.mx.Lock()
+		delete(p.missing.done, key)
+		p.missing.mx.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (p *PubsubValueStore) fetchFromPeers(key string) {
+	p.mx.Lock()
+	ti, ok := p.topics[key]
+	p.mx.Unlock()
+	if !ok {
+		return
+	}
+
+	var peers []peer.ID
+	for _, pid := range ti.topic.ListPeers() {
+		if p.publisherAllowed(key, pid) {
+			peers = append(peers, pid)
+		}
+	}
+	// with auto-tuning, only fetch from a few of them, picked at random
+	if fanout := int(atomic.LoadInt32(&ti.fetchFanout)); fanout > 0 && len(peers) > fanout {
+		rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		peers = peers[:fanout]
+	}
+
+	var wg sync.WaitGroup
+	for _, pid := range peers {
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			value, err := p.limitedFetch(p.ctx, pid, key)
+			if ti.tuner != nil && p.ctx.Err() == nil {
+				ti.tuner.observeFetch(err == nil && value != nil)
+			}
+			if err != nil {
+				p.log.Debugf("PubsubFetch: error fetching %s from %s: %s", logKey(key), pid, err)
+				return
+			}
+			if value == nil {
+				return
+			}
+			if p.recordValidator().Validate(key, value) == nil && !p.messageAllowed(p.ctx, key, value, pid) {
+				return
+			}
+
+			u := update{data: value, meta: RecordMeta{From: pid, Received: time.Now()}}
+			processed := make(chan struct{})
+			select {
+			case ti.fetched <- fetchedUpdate{update: u, processed: processed}:
+				<-processed
+			case <-ti.finished:
+			}
+		}(pid)
+	}
+	wg.Wait()
+}
+
+// fetchedUpdate is a record fetched by fetchMissing, handed over to the
+// topic's handler which closes processed once it stored the record.
+type fetchedUpdate struct {
+	update
+	processed chan struct{}
+}
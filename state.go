@@ -0,0 +1,96 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// SubState is the subscription state of a key.
+type SubState int
+
+const (
+	// StateNotTracked means the store isn't subscribed to the key.
+	StateNotTracked SubState = iota
+	// StateSubscribed means the store is subscribed to the key implicitly,
+	// e.g. by GetValue, until unused for the key's subscription lifetime.
+	StateSubscribed
+	// StateHeld means the subscription is held through Subscribe.
+	StateHeld
+)
+
+func (s SubState) String() string {
+	switch s {
+	case StateNotTracked:
+		return "not tracked"
+	case StateSubscribed:
+		return "subscribed"
+	case StateHeld:
+		return "held"
+	default:
+		return fmt.Sprintf("SubState(%d)", int(s))
+	}
+}
+
+// KeyState describes what the store knows about a key.
+type KeyState struct {
+	Sub SubState
+	// HasValue is true if a valid record of the key is stored locally.
+	HasValue bool
+	// Watched is true if the key has active SearchValue watchers.
+	Watched bool
+}
+
+// SubscriptionsWithState returns the keys whose subscription is in the given
+// state, sorted: StateHeld for the subscriptions held through Subscribe, and
+// StateSubscribed for the implicit ones, e.g. made by PutValue, which are
+// dropped once unused. There are no keys in StateNotTracked.
+func (p *PubsubValueStore) SubscriptionsWithState(state SubState) []string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	var res []string
+	for key, ti := range p.topics {
+		if subState(ti) == state {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// subState returns the state of the subscription ti.
+// Must be called with p.mx held.
+func subState(ti *topicInfo) SubState {
+	if ti.refs > 0 {
+		return StateHeld
+	}
+	return StateSubscribed
+}
+
+// State returns the state of the key, without subscribing to it.
+func (p *PubsubValueStore) State(ctx context.Context, key string) (KeyState, error) {
+	var st KeyState
+
+	p.mx.Lock()
+	if ti, ok := p.topics[key]; ok {
+		st.Sub = subState(ti)
+	}
+	p.mx.Unlock()
+
+	p.watchLk.Lock()
+	_, st.Watched = p.watching[key]
+	p.watchLk.Unlock()
+
+	val, err := p.records.GetBest(ctx, key)
+	switch {
+	case err == nil:
+		// expired records don't count
+		st.HasValue = p.recordValidator().Validate(key, val) == nil
+	case err != routing.ErrNotFound:
+		return KeyState{}, err
+	}
+	return st, nil
+}
@@ -0,0 +1,165 @@
+package namesys
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MetricDuplicates counts incoming records identical to the stored one.
+	MetricDuplicates = "duplicates_suppressed"
+	// MetricOversizedRejected counts records rejected for exceeding the
+	// maximum record size.
+	MetricOversizedRejected = "oversized_rejected"
+	// MetricEchoes counts our own messages skipped when delivered back to us.
+	MetricEchoes = "echoes_suppressed"
+)
+
+// Stats is a snapshot of the PubsubValueStore counters.
+type Stats struct {
+	// DuplicatesSuppressed is the number of incoming records that were
+	// identical to the stored record, and thus neither stored nor notified.
+	DuplicatesSuppressed uint64
+	// EchoesSuppressed is the number of our own messages that were skipped
+	// when delivered back to us, see WithLoopback.
+	EchoesSuppressed uint64
+	// PublisherRejected is the number of records rejected because their
+	// publisher wasn't allowed to publish the key.
+	PublisherRejected uint64
+	// OversizedRejected is the number of records rejected for exceeding the
+	// maximum record size.
+	OversizedRejected uint64
+	// RateLimited is the number of messages rejected because their publisher
+	// exceeded its rate limit.
+	RateLimited uint64
+	// PendingPublishes is the number of records being republished because
+	// their topic had no peers, see WithPublishRetry.
+	PendingPublishes uint64
+	// PublishesAbandoned is the number of records that were republished until
+	// the retry deadline without their topic ever getting a peer.
+	PublishesAbandoned uint64
+	// QueuedPublishes is the number of records waiting to be published, see
+	// WithPublishQueue.
+	QueuedPublishes uint64
+	// PublishesDropped is the number of queued records dropped before they
+	// could be published.
+	PublishesDropped uint64
+	// Resubscribes is the number of attempts to resubscribe to a topic after
+	// its subscription failed.
+	Resubscribes uint64
+	// ErrorsDropped is the number of background errors dropped because the
+	// Errors channel was full.
+	ErrorsDropped uint64
+	// UnsavedRecords is the number of records kept in memory because they
+	// failed to be written to the record store.
+	UnsavedRecords uint64
+	// Evictions is the number of subscriptions canceled to make room for new
+	// ones, see WithMaxSubscriptions.
+	Evictions uint64
+	// HistoryDropped is the number of records dropped from the buffer of
+	// full-history searches, see OverflowDropOldest.
+	HistoryDropped uint64
+	// InvalidRecords is the number of messages rejected for carrying an
+	// invalid record or signature, see InvalidRecords for the count per peer.
+	InvalidRecords uint64
+	// BlacklistRejected is the number of messages rejected because the peer
+	// they were received from was blacklisted, see WithBlacklist.
+	BlacklistRejected uint64
+	// PeerDialAttempts is the number of passes dialing the remembered peers
+	// of a key, including the retries, see WithRememberedPeers.
+	PeerDialAttempts uint64
+	// LogsSuppressed is the number of warnings logged at debug level only,
+	// because a similar one was logged less than a minute before.
+	LogsSuppressed uint64
+	// JoinRepublishes is the number of records republished because a peer
+	// joined their topic, see WithJoinRepublish.
+	JoinRepublishes uint64
+	// RecordsExpired is the number of records deleted because their TTL was
+	// over, see WithTTL.
+	RecordsExpired uint64
+	// RecordsEvicted is the number of records deleted to stay within the limit
+	// set with WithMaxCachedRecords.
+	RecordsEvicted uint64
+
+	// RebroadcastInterval is the effective rebroadcast interval. With
+	// WithAutoTune, it is the shortest of the keys' intervals.
+	RebroadcastInterval time.Duration
+	// DiscoveryInterval is the shortest of the keys' auto-tuned discovery
+	// intervals, see WithAutoTune. 0 without auto-tuning.
+	DiscoveryInterval time.Duration
+	// FetchFanout is the largest of the keys' auto-tuned fetch fan-outs, see
+	// WithAutoTune. 0 without auto-tuning, as the records are fetched from
+	// all of the topic's peers.
+	FetchFanout int
+
+	// Watchers maps each watched key to its number of watchers, see
+	// WatcherCount.
+	Watchers map[string]int
+}
+
+// stats holds the live counters. All fields are accessed atomically.
+type stats struct {
+	duplicatesSuppressed uint64
+	echoesSuppressed     uint64
+	publisherRejected    uint64
+	oversizedRejected    uint64
+	rateLimited          uint64
+	publishesAbandoned   uint64
+	pendingPublishes     int64
+	queuedPublishes      int64
+	publishesDropped     uint64
+	resubscribes         uint64
+	errorsDropped        uint64
+	evictions            uint64
+	historyDropped       uint64
+	invalidRecords       uint64
+	blacklistRejected    uint64
+	peerDialAttempts     uint64
+	logsSuppressed       uint64
+	joinRepublishes      uint64
+	recordsExpired       uint64
+	recordsEvicted       uint64
+
+	rebroadcastInterval int64
+	discoveryInterval   int64
+	fetchFanout         int64
+}
+
+// count increments the counter and reports it to the telemetry sink.
+func (p *PubsubValueStore) count(counter *uint64, metric string, attrs ...Attribute) {
+	atomic.AddUint64(counter, 1)
+	p.telemetry.IncCounter(metric, attrs...)
+}
+
+// Stats returns a snapshot of the store's counters.
+func (p *PubsubValueStore) Stats() Stats {
+	return Stats{
+		DuplicatesSuppressed: atomic.LoadUint64(&p.stats.duplicatesSuppressed),
+		EchoesSuppressed:     atomic.LoadUint64(&p.stats.echoesSuppressed),
+		PublisherRejected:    atomic.LoadUint64(&p.stats.publisherRejected),
+		OversizedRejected:    atomic.LoadUint64(&p.stats.oversizedRejected),
+		RateLimited:          atomic.LoadUint64(&p.stats.rateLimited),
+		PendingPublishes:     uint64(atomic.LoadInt64(&p.stats.pendingPublishes)),
+		PublishesAbandoned:   atomic.LoadUint64(&p.stats.publishesAbandoned),
+		QueuedPublishes:      uint64(atomic.LoadInt64(&p.stats.queuedPublishes)),
+		PublishesDropped:     atomic.LoadUint64(&p.stats.publishesDropped),
+		Resubscribes:         atomic.LoadUint64(&p.stats.resubscribes),
+		ErrorsDropped:        atomic.LoadUint64(&p.stats.errorsDropped),
+		UnsavedRecords:       uint64(p.unsaved.len()),
+		Evictions:            atomic.LoadUint64(&p.stats.evictions),
+		HistoryDropped:       atomic.LoadUint64(&p.stats.historyDropped),
+		InvalidRecords:       atomic.LoadUint64(&p.stats.invalidRecords),
+		BlacklistRejected:    atomic.LoadUint64(&p.stats.blacklistRejected),
+		PeerDialAttempts:     atomic.LoadUint64(&p.stats.peerDialAttempts),
+		LogsSuppressed:       atomic.LoadUint64(&p.stats.logsSuppressed),
+		JoinRepublishes:      atomic.LoadUint64(&p.stats.joinRepublishes),
+		RecordsExpired:       atomic.LoadUint64(&p.stats.recordsExpired),
+		RecordsEvicted:       atomic.LoadUint64(&p.stats.recordsEvicted),
+
+		RebroadcastInterval: time.Duration(atomic.LoadInt64(&p.stats.rebroadcastInterval)),
+		DiscoveryInterval:   time.Duration(atomic.LoadInt64(&p.stats.discoveryInterval)),
+		FetchFanout:         int(atomic.LoadInt64(&p.stats.fetchFanout)),
+
+		Watchers: p.KeysWatchedMoreThan(0),
+	}
+}
@@ -0,0 +1,106 @@
+package namesys
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MetricErrorsDropped counts asynchronous errors dropped because the Errors
+// channel was full.
+const MetricErrorsDropped = "errors_dropped"
+
+// errorsBuffer is the capacity of the Errors channel.
+const errorsBuffer = 64
+
+// Op is the background operation that failed in a StoreError.
+type Op int
+
+const (
+	// OpSubscribe is receiving the messages of a topic.
+	OpSubscribe Op = iota
+	// OpStore is writing a record or its bookkeeping to the datastore.
+	OpStore
+	// OpFetch is fetching the record from a peer that joined a topic.
+	OpFetch
+	// OpPublish is publishing a record in the background, when rebroadcasting
+	// or retrying.
+	OpPublish
+	// OpValidate is rejecting an invalid record received from the network. It
+	// is only reported to SearchResults: misbehaving peers would flood the
+	// Errors channel.
+	OpValidate
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpSubscribe:
+		return "subscribe"
+	case OpStore:
+		return "store"
+	case OpFetch:
+		return "fetch"
+	case OpPublish:
+		return "publish"
+	case OpValidate:
+		return "validate"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+// StoreError is an error that occurred in the background, outside of any
+// call returning errors.
+type StoreError struct {
+	Key string
+	Op  Op
+	Err error
+}
+
+func (e StoreError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, formatKey(e.Key), e.Err)
+}
+
+func (e StoreError) Unwrap() error {
+	return e.Err
+}
+
+// storeErrors is the buffered Errors channel, closed along with the store.
+type storeErrors struct {
+	mx     sync.RWMutex
+	ch     chan StoreError
+	closed bool
+}
+
+// Errors returns the channel reporting the errors that occurred in the
+// background, e.g. when storing a received record failed. Errors are dropped
+// rather than delayed if the channel is full, so it needs not be read. The
+// channel is closed by Close.
+func (p *PubsubValueStore) Errors() <-chan StoreError {
+	return p.errs.ch
+}
+
+// reportError sends the error to the Errors channel, and to the SearchResults
+// of the key, without blocking.
+func (p *PubsubValueStore) reportError(key string, op Op, err error) {
+	p.notifyKeyError(StoreError{Key: key, Op: op, Err: err})
+
+	p.errs.mx.RLock()
+	defer p.errs.mx.RUnlock()
+	if p.errs.closed {
+		return
+	}
+	select {
+	case p.errs.ch <- StoreError{Key: key, Op: op, Err: err}:
+	default:
+		p.count(&p.stats.errorsDropped, MetricErrorsDropped)
+	}
+}
+
+func (p *PubsubValueStore) closeErrors() {
+	p.errs.mx.Lock()
+	defer p.errs.mx.Unlock()
+	if !p.errs.closed {
+		p.errs.closed = true
+		close(p.errs.ch)
+	}
+}
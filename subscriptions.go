@@ -0,0 +1,134 @@
+package namesys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+var subscriptionsPrefix = ds.NewKey("/subscriptions")
+
+// subscriptionKey returns the datastore key recording the subscription to
+// the key.
+func subscriptionKey(key string) ds.Key {
+	return subscriptionsPrefix.Child(dshelp.NewKeyFromBinary([]byte(key)))
+}
+
+// WithPersistentSubscriptions returns an option that records the subscribed
+// keys in the datastore, along with their number of holds by Subscribe, so
+// that RestoreSubscriptions can resubscribe to them after a restart. It is
+// only useful along with a persistent datastore.
+func WithPersistentSubscriptions() Option {
+	return func(store *PubsubValueStore) error {
+		store.persistSubscriptions = true
+		return nil
+	}
+}
+
+// RestoreSubscriptionsError is returned by RestoreSubscriptions when some of
+// the keys could not be resubscribed to. It maps each failing key to its
+// error.
+type RestoreSubscriptionsError map[string]error
+
+// savedSubscription is a subscription, as recorded in the datastore.
+type savedSubscription struct {
+	// Subscribe calls not yet released with Unsubscribe
+	Holds int `json:"holds,omitempty"`
+}
+
+func (e RestoreSubscriptionsError) Error() string {
+	return fmt.Sprintf("failed to restore %d subscriptions: %s", len(e), formatKeyErrors(e))
+}
+
+// RestoreSubscriptions resubscribes to the keys that were subscribed to when
+// the store, configured with WithPersistentSubscriptions, was last closed.
+// The keys that were held by Subscribe are held again as many times, which
+// the Subscribe calls since the restart count towards. Keys that fail are
+// reported in a RestoreSubscriptionsError, without preventing the others from
+// being restored.
+func (p *PubsubValueStore) RestoreSubscriptions(ctx context.Context) error {
+	res, err := p.ds.Query(ctx, dsq.Query{Prefix: subscriptionsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+
+	errs := RestoreSubscriptionsError{}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k, err := dshelp.BinaryFromDsKey(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			errs[e.Key] = err
+			continue
+		}
+		key := string(k)
+		var saved savedSubscription
+		if len(e.Value) > 0 {
+			if err := json.Unmarshal(e.Value, &saved); err != nil {
+				p.log.Debugf("restoring the corrupt subscription to %s unheld: %s", logKey(key), err)
+			}
+		}
+		if err := p.subscribe(ctx, key, false); err != nil {
+			errs[key] = err
+			continue
+		}
+		if saved.Holds > 0 {
+			p.restoreHolds(key, saved.Holds)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// restoreHolds holds the subscription to the key up to holds times.
+func (p *PubsubValueStore) restoreHolds(key string, holds int) {
+	unlock := p.keyLocks.lock(key)
+	defer unlock()
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if ti, ok := p.topics[key]; ok && ti.refs < holds {
+		ti.refs = holds
+		p.persistSubscription(key, holds)
+	}
+}
+
+// persistSubscription records the subscription to the key, held holds times.
+// Must be called with the key locked.
+func (p *PubsubValueStore) persistSubscription(key string, holds int) {
+	if !p.persistSubscriptions {
+		return
+	}
+	b, err := json.Marshal(savedSubscription{Holds: holds})
+	if err == nil {
+		err = p.ds.Put(p.ctx, subscriptionKey(key), b)
+	}
+	if err != nil {
+		p.log.Warnf("failed to persist the subscription to %s: %s", logKey(key), err)
+		p.reportError(key, OpStore, err)
+	}
+}
+
+// forgetSubscription removes the recorded subscription to the key, unless the
+// store is closing, in which case it is kept to be restored.
+// Must be called with p.mx held.
+func (p *PubsubValueStore) forgetSubscription(key string) {
+	if !p.persistSubscriptions || p.ctx.Err() != nil {
+		return
+	}
+	if err := p.ds.Delete(p.ctx, subscriptionKey(key)); err != nil {
+		p.log.Warnf("failed to forget the subscription to %s: %s", logKey(key), err)
+		p.reportError(key, OpStore, err)
+	}
+}
@@ -0,0 +1,99 @@
+package namesys
+
+import (
+	"context"
+	"time"
+)
+
+// Metric names reported through Telemetry.
+const (
+	// MetricPublishes counts records published through pubsub.
+	MetricPublishes = "publishes"
+	// MetricPublishDuration observes the time, in seconds, it took to hand a
+	// record over to pubsub.
+	MetricPublishDuration = "publish_duration_seconds"
+	// MetricMessages counts incoming pubsub messages by validation result.
+	MetricMessages = "messages"
+	// MetricFetches counts fetch protocol requests by result.
+	MetricFetches = "fetches"
+	// MetricSubscriptions is the number of active topic subscriptions.
+	MetricSubscriptions = "subscriptions"
+)
+
+// Attribute is a key/value pair attached to metrics and spans.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attr is a shorthand for constructing an Attribute.
+func Attr(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Telemetry is the instrumentation sink of the PubsubValueStore. It lets
+// embedders plug in the metrics and tracing stack of their choice; see the
+// telemetry/prometheus and telemetry/otel modules for adapters, kept separate
+// so that the store depends on neither.
+//
+// Implementations must be safe for concurrent use. A given metric name is
+// always reported with the same set of attribute keys.
+//
+// Spans are started for PutValue, with Subscribe and Publish children,
+// Subscribe, with a JoinTopic child for new subscriptions, GetValue and
+// SearchValue, annotated with whether the record was found locally, and
+// SubscribeAndWait. Each record received from the network gets a HandleUpdate
+// span, with the time spent validating and storing it as events. Children are
+// started with the context returned for their parent.
+type Telemetry interface {
+	IncCounter(name string, attrs ...Attribute)
+	SetGauge(name string, value float64, attrs ...Attribute)
+	ObserveHistogram(name string, value float64, attrs ...Attribute)
+	StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation started by Telemetry.StartSpan.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	AddEvent(name string, attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// NoopTelemetry is a Telemetry that discards everything. It is the default.
+type NoopTelemetry struct{}
+
+func (NoopTelemetry) IncCounter(string, ...Attribute)                {}
+func (NoopTelemetry) SetGauge(string, float64, ...Attribute)         {}
+func (NoopTelemetry) ObserveHistogram(string, float64, ...Attribute) {}
+
+func (NoopTelemetry) StartSpan(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute)    {}
+func (noopSpan) AddEvent(string, ...Attribute) {}
+func (noopSpan) RecordError(error)             {}
+func (noopSpan) End()                          {}
+
+// addTimedEvent adds an event to the span for a step that took d, without
+// formatting the duration for the no-op span.
+func addTimedEvent(span Span, name string, d time.Duration) {
+	if _, ok := span.(noopSpan); ok {
+		return
+	}
+	span.AddEvent(name, Attr("duration", d.String()))
+}
+
+// WithTelemetry returns an option that sets the instrumentation sink.
+func WithTelemetry(t Telemetry) Option {
+	return func(store *PubsubValueStore) error {
+		if t == nil {
+			t = NoopTelemetry{}
+		}
+		store.telemetry = t
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+module github.com/libp2p/go-libp2p-pubsub-router/telemetry/otel
+
+go 1.16
+
+require (
+	github.com/libp2p/go-libp2p-pubsub-router v0.6.0
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/metric v0.26.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
+)
+
+// v0.6.0 is the first release with the Telemetry interface. The replace only
+// builds both modules from this repository's tree, and doesn't apply to the
+// modules requiring this one.
+replace github.com/libp2p/go-libp2p-pubsub-router => ../../
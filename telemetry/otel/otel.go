@@ -0,0 +1,139 @@
+// Package otel reports PubsubValueStore metrics and traces to OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/libp2p/go-libp2p-pubsub-router"
+
+// Telemetry implements namesys.Telemetry on top of OpenTelemetry tracer and
+// meter providers. Instruments are created lazily, the first time they're
+// reported.
+type Telemetry struct {
+	tracer trace.Tracer
+	meter  metric.Meter
+
+	mx         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64UpDownCounter
+	histograms map[string]metric.Float64Histogram
+	// last gauge values, to turn Set into UpDownCounter deltas
+	gaugeVals map[string]float64
+}
+
+var _ namesys.Telemetry = (*Telemetry)(nil)
+
+// New returns a Telemetry reporting to the given providers.
+func New(tp trace.TracerProvider, mp metric.MeterProvider) *Telemetry {
+	return &Telemetry{
+		tracer:     tp.Tracer(instrumentationName),
+		meter:      mp.Meter(instrumentationName),
+		counters:   make(map[string]metric.Float64Counter),
+		gauges:     make(map[string]metric.Float64UpDownCounter),
+		histograms: make(map[string]metric.Float64Histogram),
+		gaugeVals:  make(map[string]float64),
+	}
+}
+
+func (t *Telemetry) IncCounter(name string, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	c, ok := t.counters[name]
+	if !ok {
+		var err error
+		c, err = t.meter.NewFloat64Counter(name)
+		if err != nil {
+			t.mx.Unlock()
+			return
+		}
+		t.counters[name] = c
+	}
+	t.mx.Unlock()
+	c.Add(context.Background(), 1, keyValues(attrs)...)
+}
+
+func (t *Telemetry) SetGauge(name string, value float64, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	g, ok := t.gauges[name]
+	if !ok {
+		var err error
+		g, err = t.meter.NewFloat64UpDownCounter(name)
+		if err != nil {
+			return
+		}
+		t.gauges[name] = g
+	}
+	series := seriesKey(name, attrs)
+	g.Add(context.Background(), value-t.gaugeVals[series], keyValues(attrs)...)
+	t.gaugeVals[series] = value
+}
+
+func (t *Telemetry) ObserveHistogram(name string, value float64, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	h, ok := t.histograms[name]
+	if !ok {
+		var err error
+		h, err = t.meter.NewFloat64Histogram(name)
+		if err != nil {
+			t.mx.Unlock()
+			return
+		}
+		t.histograms[name] = h
+	}
+	t.mx.Unlock()
+	h.Record(context.Background(), value, keyValues(attrs)...)
+}
+
+func (t *Telemetry) StartSpan(ctx context.Context, name string, attrs ...namesys.Attribute) (context.Context, namesys.Span) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithAttributes(keyValues(attrs)...))
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SetAttributes(attrs ...namesys.Attribute) {
+	s.s.SetAttributes(keyValues(attrs)...)
+}
+
+func (s span) AddEvent(name string, attrs ...namesys.Attribute) {
+	s.s.AddEvent(name, trace.WithAttributes(keyValues(attrs)...))
+}
+
+func (s span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
+
+func keyValues(attrs []namesys.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		kvs[i] = attribute.String(a.Key, a.Value)
+	}
+	return kvs
+}
+
+func seriesKey(name string, attrs []namesys.Attribute) string {
+	parts := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		parts = append(parts, a.Key+"="+a.Value)
+	}
+	sort.Strings(parts)
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,62 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric/metrictest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTelemetry(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	mp := metrictest.NewMeterProvider()
+	tm := New(tp, mp)
+
+	tm.IncCounter(namesys.MetricMessages, namesys.Attr("result", "accept"))
+	tm.SetGauge(namesys.MetricSubscriptions, 3)
+	tm.SetGauge(namesys.MetricSubscriptions, 2)
+	tm.ObserveHistogram(namesys.MetricPublishDuration, 0.5)
+
+	var gauge float64
+	got := map[string]int{}
+	for _, m := range metrictest.AsStructs(mp.MeasurementBatches) {
+		got[m.Name]++
+		if m.Name == namesys.MetricSubscriptions {
+			gauge += m.Number.AsFloat64()
+		}
+	}
+	if got[namesys.MetricMessages] != 1 || got[namesys.MetricPublishDuration] != 1 || got[namesys.MetricSubscriptions] != 2 {
+		t.Fatalf("unexpected measurements: %v", got)
+	}
+	if gauge != 2 {
+		t.Fatalf("expected gauge at 2, got %f", gauge)
+	}
+
+	ctx, span := tm.StartSpan(context.Background(), "PutValue", namesys.Attr("key", "/namespace/key"))
+	_, child := tm.StartSpan(ctx, "publish")
+	child.AddEvent("published")
+	child.End()
+	span.RecordError(errors.New("failed"))
+	span.End()
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "publish" || spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Fatal("child span not parented")
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Fatal("error not recorded")
+	}
+	if len(spans[0].Events()) != 1 {
+		t.Fatal("event not recorded")
+	}
+}
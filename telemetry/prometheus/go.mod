@@ -0,0 +1,13 @@
+module github.com/libp2p/go-libp2p-pubsub-router/telemetry/prometheus
+
+go 1.16
+
+require (
+	github.com/libp2p/go-libp2p-pubsub-router v0.6.0
+	github.com/prometheus/client_golang v1.11.0
+)
+
+// v0.6.0 is the first release with the Telemetry interface. The replace only
+// builds both modules from this repository's tree, and doesn't apply to the
+// modules requiring this one.
+replace github.com/libp2p/go-libp2p-pubsub-router => ../../
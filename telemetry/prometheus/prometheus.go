@@ -0,0 +1,116 @@
+// Package prometheus reports PubsubValueStore metrics to prometheus.
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Telemetry implements namesys.Telemetry on top of a prometheus registry.
+// Metrics are registered lazily, the first time they're reported. Prometheus
+// has no notion of tracing, so spans are discarded.
+type Telemetry struct {
+	reg       prom.Registerer
+	namespace string
+
+	mx         sync.Mutex
+	counters   map[string]*prom.CounterVec
+	gauges     map[string]*prom.GaugeVec
+	histograms map[string]*prom.HistogramVec
+}
+
+var _ namesys.Telemetry = (*Telemetry)(nil)
+
+// New returns a Telemetry registering its metrics with reg under the given
+// namespace.
+func New(reg prom.Registerer, namespace string) *Telemetry {
+	return &Telemetry{
+		reg:        reg,
+		namespace:  namespace,
+		counters:   make(map[string]*prom.CounterVec),
+		gauges:     make(map[string]*prom.GaugeVec),
+		histograms: make(map[string]*prom.HistogramVec),
+	}
+}
+
+func (t *Telemetry) IncCounter(name string, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	c, ok := t.counters[name]
+	if !ok {
+		c = prom.NewCounterVec(prom.CounterOpts{
+			Namespace: t.namespace,
+			Name:      name + "_total",
+		}, labelNames(attrs))
+		c = t.register(c).(*prom.CounterVec)
+		t.counters[name] = c
+	}
+	t.mx.Unlock()
+	c.With(labels(attrs)).Inc()
+}
+
+func (t *Telemetry) SetGauge(name string, value float64, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	g, ok := t.gauges[name]
+	if !ok {
+		g = prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: t.namespace,
+			Name:      name,
+		}, labelNames(attrs))
+		g = t.register(g).(*prom.GaugeVec)
+		t.gauges[name] = g
+	}
+	t.mx.Unlock()
+	g.With(labels(attrs)).Set(value)
+}
+
+func (t *Telemetry) ObserveHistogram(name string, value float64, attrs ...namesys.Attribute) {
+	t.mx.Lock()
+	h, ok := t.histograms[name]
+	if !ok {
+		h = prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: t.namespace,
+			Name:      name,
+		}, labelNames(attrs))
+		h = t.register(h).(*prom.HistogramVec)
+		t.histograms[name] = h
+	}
+	t.mx.Unlock()
+	h.With(labels(attrs)).Observe(value)
+}
+
+func (t *Telemetry) StartSpan(ctx context.Context, name string, attrs ...namesys.Attribute) (context.Context, namesys.Span) {
+	return namesys.NoopTelemetry{}.StartSpan(ctx, name, attrs...)
+}
+
+// register registers the collector, returning the already registered one if
+// another Telemetry registered it first.
+func (t *Telemetry) register(c prom.Collector) prom.Collector {
+	err := t.reg.Register(c)
+	var are prom.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		return are.ExistingCollector
+	}
+	// On any other error, the collector keeps working, it just isn't exported.
+	return c
+}
+
+func labelNames(attrs []namesys.Attribute) []string {
+	names := make([]string, len(attrs))
+	for i, a := range attrs {
+		names[i] = a.Key
+	}
+	return names
+}
+
+func labels(attrs []namesys.Attribute) prom.Labels {
+	l := make(prom.Labels, len(attrs))
+	for _, a := range attrs {
+		l[a.Key] = a.Value
+	}
+	return l
+}
@@ -0,0 +1,52 @@
+package prometheus
+
+import (
+	"context"
+	"testing"
+
+	namesys "github.com/libp2p/go-libp2p-pubsub-router"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTelemetry(t *testing.T) {
+	reg := prom.NewRegistry()
+	tm := New(reg, "test")
+
+	tm.IncCounter(namesys.MetricMessages, namesys.Attr("result", "accept"))
+	tm.IncCounter(namesys.MetricMessages, namesys.Attr("result", "accept"))
+	tm.IncCounter(namesys.MetricMessages, namesys.Attr("result", "reject"))
+	tm.SetGauge(namesys.MetricSubscriptions, 3)
+	tm.SetGauge(namesys.MetricSubscriptions, 2)
+	tm.ObserveHistogram(namesys.MetricPublishDuration, 0.5)
+
+	if v := testutil.ToFloat64(tm.counters[namesys.MetricMessages].WithLabelValues("accept")); v != 2 {
+		t.Fatalf("expected 2 accepted messages, got %f", v)
+	}
+	if v := testutil.ToFloat64(tm.counters[namesys.MetricMessages].WithLabelValues("reject")); v != 1 {
+		t.Fatalf("expected 1 rejected message, got %f", v)
+	}
+	if v := testutil.ToFloat64(tm.gauges[namesys.MetricSubscriptions]); v != 2 {
+		t.Fatalf("expected 2 subscriptions, got %f", v)
+	}
+
+	n, err := testutil.GatherAndCount(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 series, got %d", n)
+	}
+
+	// a second Telemetry on the same registry shares the collectors
+	tm2 := New(reg, "test")
+	tm2.IncCounter(namesys.MetricMessages, namesys.Attr("result", "accept"))
+	if v := testutil.ToFloat64(tm.counters[namesys.MetricMessages].WithLabelValues("accept")); v != 3 {
+		t.Fatalf("expected 3 accepted messages, got %f", v)
+	}
+
+	// spans are no-ops
+	_, span := tm.StartSpan(context.Background(), "PutValue")
+	span.End()
+}
@@ -0,0 +1,100 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/routing"
+	pb "github.com/libp2p/go-libp2p-pubsub-router/pb"
+)
+
+// MetricRecordsExpired counts the records deleted because their TTL was over.
+const MetricRecordsExpired = "records_expired"
+
+// ErrRecordExpired is the validation error of an enveloped record whose TTL,
+// set with WithTTL, is over.
+var ErrRecordExpired = errors.New("record expired")
+
+// ErrTTLWithoutEnvelopes is returned by PutValue when given WithTTL by a store
+// without WithEnvelopes, which has nowhere to put the expiry.
+var ErrTTLWithoutEnvelopes = errors.New("record TTL requires envelopes")
+
+type ttlKey struct{}
+
+// WithTTL is a PutValue option that makes the record expire once d is over,
+// even if no better record replaced it. Expired records are not found, are no
+// longer rebroadcast, and get deleted by the next rebroadcast pass; any valid
+// record replaces them.
+//
+// The expiry is carried in the record's envelope, so it requires WithEnvelopes.
+// Every store opens envelopes, so the stores without this option accept the
+// record and honor its expiry too. The expiry is checked against the local
+// clock of each node.
+func WithTTL(d time.Duration) routing.Option {
+	return func(opts *routing.Options) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid TTL: %s", d)
+		}
+		if opts.Other == nil {
+			opts.Other = make(map[interface{}]interface{})
+		}
+		opts.Other[ttlKey{}] = d
+		return nil
+	}
+}
+
+// ttlOf returns the TTL set by the options, 0 if none.
+func ttlOf(opts []routing.Option) time.Duration {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		return 0
+	}
+	ttl, _ := options.Other[ttlKey{}].(time.Duration)
+	return ttl
+}
+
+// checkTTL returns ErrTTLWithoutEnvelopes if the options set a TTL the store
+// can't honor.
+func (p *PubsubValueStore) checkTTL(opts []routing.Option) error {
+	if ttlOf(opts) > 0 && !p.envelopes {
+		return ErrTTLWithoutEnvelopes
+	}
+	return nil
+}
+
+// expired returns whether the envelope has an expiry, and it is over.
+func expired(env *pb.Envelope, now time.Time) bool {
+	return env.Expires != 0 && now.UnixNano() >= env.Expires
+}
+
+// dropExpired deletes the key's stored record and its metadata if the record
+// expired.
+func (p *PubsubValueStore) dropExpired(ctx context.Context, ti *topicInfo, key string) {
+	if p.passThrough {
+		return
+	}
+	ti.dbWriteMx.Lock()
+	defer ti.dbWriteMx.Unlock()
+
+	val, err := p.records.GetBest(ctx, key)
+	if err != nil {
+		return
+	}
+	env, err := openRecord(val)
+	if err != nil || !expired(env, time.Now()) {
+		return
+	}
+	if err := p.records.Delete(ctx, key); err != nil {
+		p.reportError(key, OpStore, err)
+		return
+	}
+	p.invalidateBest(key)
+	p.uncache(key)
+	if err := p.ds.Delete(ctx, metaKey(key)); err != nil {
+		p.reportError(key, OpStore, err)
+	}
+	p.count(&p.stats.recordsExpired, MetricRecordsExpired)
+	p.log.Debugf("PubsubRebroadcast: deleted expired record of %s", logKey(key))
+}
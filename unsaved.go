@@ -0,0 +1,163 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// dsPutAttempts is the number of times a received record is written
+	// before it is kept in memory until the record store recovers.
+	dsPutAttempts = 3
+	dsPutBackoff  = 50 * time.Millisecond
+
+	unsavedMinBackoff = time.Second
+	unsavedMaxBackoff = 30 * time.Second
+)
+
+// unsavedRecords holds the records that were better than the stored ones, but
+// failed to be written to the record store. They are served from memory until
+// written, and lost if the store is closed before that.
+type unsavedRecords struct {
+	mx   sync.Mutex
+	vals map[string][]byte
+	// whether writeUnsaved is running
+	writing bool
+}
+
+func (u *unsavedRecords) get(key string) []byte {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	return u.vals[key]
+}
+
+// drop forgets the key's unsaved record, if it is still val.
+func (u *unsavedRecords) drop(key string, val []byte) {
+	if val == nil {
+		return
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	if cur, ok := u.vals[key]; ok && bytes.Equal(cur, val) {
+		delete(u.vals, key)
+	}
+}
+
+func (u *unsavedRecords) len() int {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	return len(u.vals)
+}
+
+func (u *unsavedRecords) keys() []string {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	keys := make([]string, 0, len(u.vals))
+	for k := range u.vals {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (u *unsavedRecords) forget(key string) {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	delete(u.vals, key)
+}
+
+// keepUnsaved keeps the record that failed to be written in memory, and makes
+// sure it is written once the record store recovers. The write error is left
+// to the caller to report.
+func (p *PubsubValueStore) keepUnsaved(key string, value []byte) {
+	p.log.Debugf("PubsubResolve: keeping %s in memory until it can be written", logKey(key))
+
+	p.unsaved.mx.Lock()
+	defer p.unsaved.mx.Unlock()
+	if p.unsaved.vals == nil {
+		p.unsaved.vals = make(map[string][]byte)
+	}
+	p.unsaved.vals[key] = value
+	if !p.unsaved.writing {
+		p.unsaved.writing = true
+		go p.writeUnsaved()
+	}
+}
+
+// backoffWrite waits before the attempt-th retry of a write. It returns false
+// if the context ended first.
+func backoffWrite(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(dsPutBackoff << (attempt - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeUnsaved retries writing the unsaved records, with exponential backoff,
+// until all of them are written or the store is closed.
+func (p *PubsubValueStore) writeUnsaved() {
+	backoff := unsavedMinBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			return
+		}
+
+		p.unsaved.mx.Lock()
+		vals := make(map[string][]byte, len(p.unsaved.vals))
+		for k, v := range p.unsaved.vals {
+			vals[k] = v
+		}
+		p.unsaved.mx.Unlock()
+
+		failed := false
+		validator := p.recordValidator()
+		for key, val := range vals {
+			written := p.cacheWriting(p.ctx, key)
+			stored, err := p.records.PutIfBetter(p.ctx, key, val, func(old, val []byte) bool {
+				if old != nil && validator.Validate(key, old) != nil {
+					old = nil
+				}
+				return p.compareRecords(validator, key, val, old) > 0
+			})
+			written(err == nil && stored)
+			if err != nil {
+				failed = true
+				continue
+			}
+			if stored {
+				// not written under the key's dbWriteMx
+				p.invalidateBest(key)
+			}
+			// written, or superseded by a better record in the meantime
+			p.unsaved.drop(key, val)
+		}
+
+		p.unsaved.mx.Lock()
+		if len(p.unsaved.vals) == 0 {
+			p.unsaved.writing = false
+			p.unsaved.mx.Unlock()
+			return
+		}
+		p.unsaved.mx.Unlock()
+
+		if failed {
+			if backoff *= 2; backoff > unsavedMaxBackoff {
+				backoff = unsavedMaxBackoff
+			}
+		} else {
+			// new records failed while writing the previous ones
+			backoff = unsavedMinBackoff
+		}
+		timer.Reset(backoff)
+	}
+}
@@ -0,0 +1,97 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// errValidationTimeout is returned by compareWithin when the record couldn't
+// be validated within the validation timeout.
+var errValidationTimeout = errors.New("validation timed out")
+
+// WithValidationTimeout returns an option that bounds the time spent
+// validating a message, e.g. for validators looking keys up remotely. It is
+// passed on to the pubsub when registering the topic validators, and also
+// enforced by the store, should the pubsub ignore it: messages whose record
+// isn't validated in time are ignored, without holding their peer responsible.
+// Unlimited by default.
+func WithValidationTimeout(timeout time.Duration) Option {
+	return func(store *PubsubValueStore) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid validation timeout: %s", timeout)
+		}
+		store.validationTimeout = timeout
+		return nil
+	}
+}
+
+// WithTopicValidationConcurrency returns an option that sets the number of
+// messages of a topic the pubsub validates at the same time; it throttles the
+// messages beyond that. Unlike WithValidationWorkers, the limit is applied by
+// the pubsub to each topic. Defaults to the pubsub's default.
+func WithTopicValidationConcurrency(n int) Option {
+	return func(store *PubsubValueStore) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid topic validation concurrency: %d", n)
+		}
+		store.topicValidationConcurrency = n
+		return nil
+	}
+}
+
+// WithInlineValidation returns an option that has the pubsub run the topic
+// validators inline, rather than on their own goroutines. This is cheaper for
+// validators that are quick and don't block, and stalls the pubsub's
+// validation pipeline otherwise.
+func WithInlineValidation() Option {
+	return func(store *PubsubValueStore) error {
+		store.inlineValidation = true
+		return nil
+	}
+}
+
+// validatorOpts returns the options the topic validators are registered with.
+func (p *PubsubValueStore) validatorOpts() []pubsub.ValidatorOpt {
+	var opts []pubsub.ValidatorOpt
+	if p.validationTimeout > 0 {
+		opts = append(opts, pubsub.WithValidatorTimeout(p.validationTimeout))
+	}
+	if p.topicValidationConcurrency > 0 {
+		opts = append(opts, pubsub.WithValidatorConcurrency(p.topicValidationConcurrency))
+	}
+	if p.inlineValidation {
+		opts = append(opts, pubsub.WithValidatorInline(true))
+	}
+	return opts
+}
+
+// compareWithin is compare, giving up with errValidationTimeout once the
+// validation timeout is over. The comparison keeps running in the background
+// until the validator returns.
+func (p *PubsubValueStore) compareWithin(ctx context.Context, key string, val []byte) (verdict, error) {
+	if p.validationTimeout <= 0 {
+		return p.compare(ctx, key, val)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.validationTimeout)
+	defer cancel()
+
+	type result struct {
+		v   verdict
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		v, err := p.compare(ctx, key, val)
+		res <- result{v, err}
+	}()
+	select {
+	case r := <-res:
+		return r.v, r.err
+	case <-ctx.Done():
+		return verdict{cmp: -1}, errValidationTimeout
+	}
+}
@@ -0,0 +1,45 @@
+package namesys
+
+// size returns the number of watchers in the group.
+func (wg *watchGroup) size() int {
+	return len(wg.listeners) + len(wg.metaListeners) + len(wg.callbacks) + len(wg.history)
+}
+
+// WatcherCount returns the number of watchers of the key: its searches,
+// including those of SearchValues and WithFullHistory, and its callbacks set
+// with RegisterOnValueChanged. Watchers are counted until they are done, e.g.
+// once their context ended or the key's watchers were ended.
+func (p *PubsubValueStore) WatcherCount(key string) int {
+	p.watchLk.Lock()
+	defer p.watchLk.Unlock()
+	if wg, ok := p.watching[key]; ok {
+		return wg.size()
+	}
+	return 0
+}
+
+// TotalWatchers returns the number of watchers of all the keys, see
+// WatcherCount.
+func (p *PubsubValueStore) TotalWatchers() int {
+	p.watchLk.Lock()
+	defer p.watchLk.Unlock()
+	var n int
+	for _, wg := range p.watching {
+		n += wg.size()
+	}
+	return n
+}
+
+// KeysWatchedMoreThan returns the keys with more than n watchers, mapped to
+// their number of watchers. It helps finding watchers that are never released.
+func (p *PubsubValueStore) KeysWatchedMoreThan(n int) map[string]int {
+	p.watchLk.Lock()
+	defer p.watchLk.Unlock()
+	keys := make(map[string]int)
+	for key, wg := range p.watching {
+		if size := wg.size(); size > n {
+			keys[key] = size
+		}
+	}
+	return keys
+}